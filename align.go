@@ -0,0 +1,67 @@
+package main
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"sort"
+)
+
+// alignTags lines up the tags of every struct in formatted source on one
+// column per struct. gofmt only aligns tags within runs of consecutive
+// fields, so blank lines and comments between fields leave them at
+// different columns. Spaces are inserted before each tag, which keeps the
+// code valid but is undone by a later gofmt.
+func alignTags(src []byte) ([]byte, error) {
+	fset := token.NewFileSet()
+	astFile, err := parser.ParseFile(fset, "", src, parser.ParseComments)
+	if err != nil {
+		return nil, err
+	}
+
+	// Number of spaces to insert at each tag offset
+	pads := make(map[int]int)
+	ast.Inspect(astFile, func(n ast.Node) bool {
+		structType, ok := n.(*ast.StructType)
+		if !ok {
+			return true
+		}
+
+		// Only tags on the line their field starts on can be aligned
+		var tags []*ast.BasicLit
+		column := 0
+		for _, field := range structType.Fields.List {
+			if field.Tag == nil || fset.Position(field.Pos()).Line != fset.Position(field.Tag.Pos()).Line {
+				continue
+			}
+			tags = append(tags, field.Tag)
+			column = max(column, fset.Position(field.Tag.Pos()).Column)
+		}
+		for _, tag := range tags {
+			if pad := column - fset.Position(tag.Pos()).Column; pad > 0 {
+				pads[fset.Position(tag.Pos()).Offset] = pad
+			}
+		}
+		return true
+	})
+	if len(pads) == 0 {
+		return src, nil
+	}
+
+	offsets := make([]int, 0, len(pads))
+	for offset := range pads {
+		offsets = append(offsets, offset)
+	}
+	sort.Ints(offsets)
+
+	out := make([]byte, 0, len(src)+len(pads)*8)
+	last := 0
+	for _, offset := range offsets {
+		out = append(out, src[last:offset]...)
+		for i := 0; i < pads[offset]; i++ {
+			out = append(out, ' ')
+		}
+		last = offset
+	}
+	return append(out, src[last:]...), nil
+}
@@ -0,0 +1,28 @@
+package main
+
+import "testing"
+
+func TestAlignTags(t *testing.T) {
+	src := "package pb\n\ntype A struct {\n" +
+		"\tId int `json:\"id\"`\n\n" +
+		"\tLongName string `json:\"long_name\"`\n" +
+		"\t// Untagged fields don't move\n" +
+		"\tX bool\n" +
+		"}\n"
+	want := "package pb\n\ntype A struct {\n" +
+		"\tId int          `json:\"id\"`\n\n" +
+		"\tLongName string `json:\"long_name\"`\n" +
+		"\t// Untagged fields don't move\n" +
+		"\tX bool\n" +
+		"}\n"
+	got, err := alignTags([]byte(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+	if _, err := alignTags([]byte("package")); err == nil {
+		t.Error("alignTags accepted invalid source")
+	}
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"strings"
+	"unicode"
+)
+
+// The functions below turn a single annotation into what processFile
+// collects for it before anything is applied. Errors don't carry the
+// annotation's line, which processFile adds.
+
+// importAnnotation returns the import a @goimport asks for, checking its
+// option and path
+func importAnnotation(ann Annotation, line int) (importEntry, error) {
+	imp := importEntry{Path: normalizeImportPath(ann.Content), Alias: ann.Alias, Line: line, IfUsed: ann.Target == "used"}
+	if ann.Target != "" && ann.Target != "used" {
+		return imp, fmt.Errorf("invalid @goimport option %q, expected (used)", ann.Target)
+	}
+	if err := validateImport(imp); err != nil {
+		return imp, fmt.Errorf("invalid @goimport %q: %v", ann.Content, err)
+	}
+	return imp, nil
+}
+
+// addImportEntry adds an import to those collected, in annotation order. The
+// first one for a path wins, but is always added if any of them is.
+func addImportEntry(imports []importEntry, imp importEntry) []importEntry {
+	for i, existing := range imports {
+		if existing.Path == imp.Path {
+			imports[i].IfUsed = existing.IfUsed && imp.IfUsed
+			return imports
+		}
+	}
+	return append(imports, imp)
+}
+
+// constructorAnnotation returns the constructor a @goconstructor asks for,
+// taking the fields it lists, or all of them without a list
+func constructorAnnotation(ann Annotation, line int) constructorSpec {
+	spec := constructorSpec{Line: line}
+	if ann.Content != "" {
+		spec.Fields = strings.FieldsFunc(ann.Content, func(r rune) bool { return r == ',' || unicode.IsSpace(r) })
+	}
+	return spec
+}
+
+// methodAnnotation creates the methods a @govalidate, @gomethod or
+// @goimplement asks for on recv. For @goimplement it also returns the
+// imports its stubs need and, with check, the assertion that the type
+// implements the interface, which needs the interface's package imported
+// too. Interfaces that can't be resolved are warned about and give nothing.
+func (p *Processor) methodAnnotation(inputPath string, fset *token.FileSet, astFile *ast.File, ann Annotation, line int, recv string, check bool) ([]*ast.FuncDecl, *ast.GenDecl, []string, error) {
+	var decls, imports []string
+	var assertion *ast.GenDecl
+	switch ann.Type {
+	case "govalidate":
+		body := ann.Content
+		if body == "" {
+			body = defaultValidateBody
+		}
+		decls = []string{"Validate() error {\n" + body + "\n}"}
+	case "gomethod":
+		decls = []string{ann.Content}
+	case "goimplement":
+		stubs, err := resolveInterface(inputPath, astFile, ann.Content)
+		if err != nil {
+			p.warnAtf(inputPath, line, "ignoring @goimplement: %s: %v", ann.Content, err)
+			return nil, nil, nil, nil
+		}
+		decls = stubs.Methods
+		imports = stubs.Imports
+		if check && (stubs.Path != "" || !strings.Contains(stubs.Name, ".")) {
+			if assertion, err = createAssertion(fset, recv, stubs.Name); err != nil {
+				return nil, nil, nil, fmt.Errorf("invalid @goimplement %q: %v", ann.Content, err)
+			}
+			if stubs.Path != "" {
+				imports = append(imports, stubs.Path)
+			}
+		}
+	}
+
+	var methods []*ast.FuncDecl
+	for _, decl := range decls {
+		method, err := createMethod(fset, recv, decl)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("invalid @%s %q: %v", ann.Type, ann.Content, err)
+		}
+		methods = append(methods, method)
+	}
+	return methods, assertion, imports, nil
+}
+
+// addMethod adds a method to those collected for a type, in annotation
+// order. The first one of a name wins.
+func addMethod(methods []*ast.FuncDecl, method *ast.FuncDecl) []*ast.FuncDecl {
+	for _, existing := range methods {
+		if existing.Name.Name == method.Name.Name {
+			return methods
+		}
+	}
+	return append(methods, method)
+}
+
+// fieldAnnotation returns the field a @gofield, @goprimarykey or
+// @goreplacefield asks for
+func (p *Processor) fieldAnnotation(ann Annotation, line int) (fieldSpec, error) {
+	spec := fieldSpec{Decl: ann.Content, Index: ann.Index, Placement: ann.Placement, Anchor: ann.Target}
+	switch ann.Type {
+	case "goprimarykey":
+		spec = primaryKeySpec(ann.Content)
+	case "goreplacefield":
+		spec = replaceFieldSpec(ann.Content)
+		if key := p.disallowedTagKey(spec.Tag); key != "" {
+			return spec, fmt.Errorf("@goreplacefield %s sets tag key %q, which is not in --allowed-tags", spec.Decl, key)
+		}
+	case "gofield":
+		spec.Decl, spec.Default = splitFieldDefault(spec.Decl)
+		if spec.Default != "" {
+			if _, err := parser.ParseExpr(spec.Default); err != nil {
+				return spec, fmt.Errorf("invalid default %q for @gofield %s: %v", spec.Default, spec.Decl, err)
+			}
+		}
+	}
+	spec.Line = line
+	return spec, nil
+}
+
+// addFieldSpec adds a field to those collected for a type, in annotation
+// order, ignoring repeats
+func addFieldSpec(fields []fieldSpec, spec fieldSpec) []fieldSpec {
+	for _, existing := range fields {
+		if existing.Decl == spec.Decl {
+			return fields
+		}
+	}
+	return append(fields, spec)
+}
+
+// tagAnnotationTarget returns the field a @gotags, @gotagopt or
+// @gorenametag on source line lineNum targets. An explicit target names the
+// field (or embedded type) directly, otherwise it's the field on the
+// annotation's line, or every field for annotations in the struct's doc
+// comment. It is "" when there is none.
+func (p *Processor) tagAnnotationTarget(ann Annotation, line string, lineNum int, structName string, docLines map[int]string) (string, error) {
+	fieldName := ann.Target
+	if fieldName == "" && docLines[lineNum] != "" && docLines[lineNum] == structName {
+		fieldName = allFields
+	}
+	if fieldName == "" {
+		fieldName = lineFieldName(line, p.GoNames, p.NameFallback)
+	}
+	if strings.HasPrefix(fieldName, "/") {
+		if _, ok := targetPattern(fieldName); !ok {
+			return "", fmt.Errorf("invalid @%s target %s, expected a regular expression in slashes", ann.Type, fieldName)
+		}
+	}
+	if strings.HasPrefix(fieldName, "#") {
+		if _, ok := targetNumber(fieldName); !ok {
+			return "", fmt.Errorf("invalid @%s target %s, expected a proto field number like #3", ann.Type, fieldName)
+		}
+	}
+	return fieldName, nil
+}
+
+// tagRenameAnnotation returns the rename a @gorenametag for the field name
+// asks for
+func (p *Processor) tagRenameAnnotation(ann Annotation, line int, name string) (tagRename, error) {
+	oldKey, newKey, _ := strings.Cut(ann.Content, " ")
+	newKey = strings.TrimSpace(newKey)
+	if p.AllowedTags != nil && newKey != "" && !p.AllowedTags[newKey] {
+		return tagRename{}, fmt.Errorf("@gorenametag for %s renames %s to tag key %q, which is not in --allowed-tags", name, oldKey, newKey)
+	}
+	return tagRename{Old: oldKey, New: newKey, Line: line}, nil
+}
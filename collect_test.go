@@ -0,0 +1,96 @@
+package main
+
+import (
+	"go/token"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestAddImportEntry(t *testing.T) {
+	var imports []importEntry
+	for _, imp := range []importEntry{
+		{Path: "gorm.io/gorm", IfUsed: true, Line: 1},
+		{Path: "time", Line: 2},
+		{Path: "gorm.io/gorm", Alias: "g", Line: 3},
+		{Path: "time", IfUsed: true, Line: 4},
+	} {
+		imports = addImportEntry(imports, imp)
+	}
+	// The first import of a path wins, and is added if any of them is
+	want := []importEntry{{Path: "gorm.io/gorm", Line: 1}, {Path: "time", Line: 2}}
+	if !reflect.DeepEqual(imports, want) {
+		t.Errorf("got %+v, want %+v", imports, want)
+	}
+
+	if _, err := importAnnotation(Annotation{Type: "goimport", Content: "fmt", Target: "always"}, 1); err == nil || !strings.Contains(err.Error(), `invalid @goimport option "always"`) {
+		t.Errorf("got %v for an unknown option", err)
+	}
+}
+
+func TestFieldAnnotation(t *testing.T) {
+	p := newTestProcessor()
+	spec, err := p.fieldAnnotation(Annotation{Type: "gofield", Content: "Age int = 18", Index: -1}, 7)
+	if err != nil || spec.Decl != "Age int" || spec.Default != "18" || spec.Line != 7 {
+		t.Errorf("got %+v, %v", spec, err)
+	}
+	if _, err := p.fieldAnnotation(Annotation{Type: "gofield", Content: "Age int = (", Index: -1}, 7); err == nil {
+		t.Error("invalid default accepted")
+	}
+
+	// Repeats are ignored
+	fields := addFieldSpec(nil, spec)
+	fields = addFieldSpec(fields, fieldSpec{Decl: "Age int", Line: 9})
+	if len(fields) != 1 || fields[0].Line != 7 {
+		t.Errorf("got %+v", fields)
+	}
+}
+
+func TestMethodAnnotation(t *testing.T) {
+	p := newTestProcessor()
+	fset := token.NewFileSet()
+	methods, assertion, imports, err := p.methodAnnotation("test.pb.go", fset, nil, Annotation{Type: "govalidate"}, 3, "*User", true)
+	if err != nil || len(methods) != 1 || methods[0].Name.Name != "Validate" || assertion != nil || imports != nil {
+		t.Fatalf("got %v, %v, %v, %v", methods, assertion, imports, err)
+	}
+	// The first method of a name wins
+	other, _, _, err := p.methodAnnotation("test.pb.go", fset, nil, Annotation{Type: "gomethod", Content: "Validate() error { return nil }"}, 4, "*User", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := addMethod(methods, other[0]); len(got) != 1 || got[0] != methods[0] {
+		t.Errorf("got %d methods", len(got))
+	}
+	if _, _, _, err := p.methodAnnotation("test.pb.go", fset, nil, Annotation{Type: "gomethod", Content: "Broken( {"}, 5, "*User", true); err == nil || !strings.Contains(err.Error(), "invalid @gomethod") {
+		t.Errorf("got %v for a malformed method", err)
+	}
+}
+
+func TestTagAnnotationTarget(t *testing.T) {
+	p := newTestProcessor()
+	docLines := map[int]string{2: "User"}
+	line := "\tUserId int64 `protobuf:\"varint,1,opt,name=user_id,proto3\"` // @gotags: gorm:\"primaryKey\""
+	tests := []struct {
+		ann     Annotation
+		lineNum int
+		want    string
+		wantErr bool
+	}{
+		{Annotation{Type: "gotags", Target: "Email"}, 5, "Email", false},
+		{Annotation{Type: "gotags"}, 5, "user_id", false},
+		{Annotation{Type: "gotags"}, 2, allFields, false},
+		{Annotation{Type: "gotagopt", Target: "/(/"}, 5, "", true},
+		{Annotation{Type: "gorenametag", Target: "#x"}, 5, "", true},
+	}
+	for _, tt := range tests {
+		got, err := p.tagAnnotationTarget(tt.ann, line, tt.lineNum, "User", docLines)
+		if got != tt.want || (err != nil) != tt.wantErr {
+			t.Errorf("tagAnnotationTarget(%+v, line %d) = %q, %v, want %q", tt.ann, tt.lineNum, got, err, tt.want)
+		}
+	}
+
+	p.AllowedTags = map[string]bool{"json": true}
+	if _, err := p.tagRenameAnnotation(Annotation{Type: "gorenametag", Content: "json bson"}, 5, "User.Name"); err == nil || !strings.Contains(err.Error(), `@gorenametag for User.Name renames json to tag key "bson"`) {
+		t.Errorf("got %v for a disallowed key", err)
+	}
+}
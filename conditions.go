@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// conditionsFlag collects the comma-separated conditions enabled with
+// --conditions, which may be given more than once
+type conditionsFlag struct {
+	p *Processor
+}
+
+func (f conditionsFlag) String() string {
+	return ""
+}
+
+func (f conditionsFlag) Set(value string) error {
+	for _, name := range strings.Split(value, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if !isConditionName(name) {
+			return fmt.Errorf("invalid condition %q", name)
+		}
+		if f.p.Conditions == nil {
+			f.p.Conditions = make(map[string]bool)
+		}
+		f.p.Conditions[name] = true
+	}
+	return nil
+}
+
+// isConditionName reports whether name can be used as a condition, which
+// must not start with a digit so it can't be mistaken for a field index
+func isConditionName(name string) bool {
+	for i, r := range name {
+		isLetter := r == '_' || ('a' <= r && r <= 'z') || ('A' <= r && r <= 'Z')
+		if !isLetter && (i == 0 || r < '0' || r > '9') {
+			return false
+		}
+	}
+	return name != ""
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestConditionsFlag(t *testing.T) {
+	p := newTestProcessor()
+	for _, value := range []string{"gorm, sql", "", "bun"} {
+		if err := (conditionsFlag{p}).Set(value); err != nil {
+			t.Fatalf("Set(%q): %v", value, err)
+		}
+	}
+	want := map[string]bool{"gorm": true, "sql": true, "bun": true}
+	if !reflect.DeepEqual(p.Conditions, want) {
+		t.Errorf("Conditions = %v, want %v", p.Conditions, want)
+	}
+	for _, value := range []string{"0", "gorm,1x", "a-b"} {
+		if err := (conditionsFlag{p}).Set(value); err == nil {
+			t.Errorf("Set(%q) succeeded", value)
+		}
+	}
+}
+
+func TestParseConditions(t *testing.T) {
+	tests := []struct {
+		comment string
+		want    []Annotation
+	}{
+		{`// @gotags[gorm]: gorm:"primaryKey"`, []Annotation{{Type: "gotags", Content: `gorm:"primaryKey"`, Condition: "gorm"}}},
+		{`// @gotags[gorm](Id): gorm:"primaryKey"`, []Annotation{{Type: "gotags", Content: `gorm:"primaryKey"`, Target: "Id", Condition: "gorm"}}},
+		{`// @gofield[sql]: Deleted bool`, []Annotation{{Type: "gofield", Content: "Deleted bool", Index: -1, Condition: "sql"}}},
+		// A number is a field index, not a condition
+		{`// @gofield[0]: sync.Mutex`, []Annotation{{Type: "gofield", Content: "sync.Mutex", Index: 0}}},
+		{`//go:inject-tags[gorm] gorm:"primaryKey"`, []Annotation{{Type: "gotags", Content: `gorm:"primaryKey"`, Condition: "gorm"}}},
+	}
+	for _, tt := range tests {
+		if got := parseAnnotations(tt.comment, defaultPrefix); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseAnnotations(%q) = %+v, want %+v", tt.comment, got, tt.want)
+		}
+	}
+}
+
+func TestConditionalAnnotations(t *testing.T) {
+	src := "package pb\n\ntype User struct {\n" +
+		"\t// @gofield[sql]: Deleted bool\n" +
+		"\tId string `json:\"id\"` // @gotags[gorm]: gorm:\"primaryKey\"\n" +
+		"}\n"
+	out := process(t, src)
+	if strings.Contains(out, "primaryKey\"`") || strings.Contains(out, "\tDeleted bool\n") {
+		t.Errorf("gated annotations applied without their conditions:\n%s", out)
+	}
+	if strings.HasPrefix(out, enhancedMarker) {
+		t.Errorf("file without injections marked:\n%s", out)
+	}
+
+	p := newTestProcessor()
+	p.Conditions = map[string]bool{"gorm": true}
+	out = processSource(t, p, "test.pb.go", src)
+	if !strings.Contains(out, "`json:\"id\" gorm:\"primaryKey\"`") {
+		t.Errorf("gorm tags not applied:\n%s", out)
+	}
+	if strings.Contains(out, "\tDeleted bool\n") {
+		t.Errorf("sql field injected:\n%s", out)
+	}
+}
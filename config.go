@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+)
+
+// configEntry is a single setting shown by --print-config
+type configEntry struct {
+	Key   string
+	Value any
+}
+
+// config returns the settings of a run, after defaults and the options
+// implied by others (e.g. --wkt-tag enabling --wkt-tags) are applied, in
+// the order --print-config shows them
+func (p *Processor) config() []configEntry {
+	output := "in place"
+	switch {
+	case p.Validate:
+		output = "none (--validate)"
+	case p.PatchFile != "":
+		output = "patch " + p.PatchFile
+	case p.OutDir != "":
+		output = "directory " + p.OutDir
+	}
+	renamePattern := ""
+	if p.RenamePattern != nil {
+		renamePattern = p.RenamePattern.String() + "=" + p.RenameReplacement
+	}
+	nameFallback := p.NameFallback
+	if nameFallback == nil {
+		nameFallback = defaultNameFallback
+	}
+
+	return []configEntry{
+		{"output", output},
+		{"rename_pattern", renamePattern},
+		{"suffix", p.Suffix},
+		{"external_methods", p.ExternalMethods},
+		{"report_unused", p.ReportUnused},
+		{"prefix", p.Prefix},
+		{"custom_annotations", slices.Sorted(maps.Keys(p.handlers))},
+		{"templates", slices.Sorted(maps.Keys(p.Templates))},
+		{"conditions", slices.Sorted(maps.Keys(p.Conditions))},
+		{"only", slices.Sorted(maps.Keys(p.Only))},
+		{"go_names", p.GoNames},
+		{"name_fallback", nameFallback},
+		{"strict_types", p.StrictTypes},
+		{"allowed_tags", slices.Sorted(maps.Keys(p.AllowedTags))},
+		{"tag_order", p.TagOrder},
+		{"merge", p.MergeStrategies},
+		{"wkt_tags", p.WKTTags},
+		{"type_tags", p.TypeTags},
+		{"yaml_case", p.YAMLCase},
+		{"require_annotations", p.RequireAnnotations},
+		{"normalize_imports", p.NormalizeImports},
+		{"warn_import_cycles", p.WarnImportCycles},
+		{"goimports", p.GoImports},
+		{"align_tags", p.AlignTags},
+		{"preserve_formatting", p.PreserveFormatting},
+		{"log_format", p.LogFormat},
+		{"verbose", p.Verbose},
+		{"debug", p.Debug},
+		{"color", p.Color},
+	}
+}
+
+// printConfig prints the settings of a run, followed by extra ones only
+// main knows about, as "key: value" lines or, with --log-format=json, a
+// JSON object
+func (p *Processor) printConfig(extra ...configEntry) error {
+	entries := append(p.config(), extra...)
+	if p.LogFormat == "json" {
+		var sb strings.Builder
+		sb.WriteString("{")
+		for i, entry := range entries {
+			value, err := json.Marshal(entry.Value)
+			if err != nil {
+				return fmt.Errorf("failed to encode %s: %v", entry.Key, err)
+			}
+			if i > 0 {
+				sb.WriteString(",")
+			}
+			fmt.Fprintf(&sb, "%q:%s", entry.Key, value)
+		}
+		sb.WriteString("}")
+		fmt.Println(sb.String())
+		return nil
+	}
+
+	width := 0
+	for _, entry := range entries {
+		width = max(width, len(entry.Key))
+	}
+	indent := "\n" + strings.Repeat(" ", width+3)
+	for _, entry := range entries {
+		fmt.Printf("%-*s  %s\n", width+1, entry.Key+":", strings.ReplaceAll(formatConfigValue(entry.Value), "\n", indent))
+	}
+	return nil
+}
+
+// formatConfigValue formats a setting for the text output of
+// --print-config: lists comma-separated, maps as sorted key=value pairs,
+// one per line
+func formatConfigValue(value any) string {
+	switch v := value.(type) {
+	case string:
+		if v == "" {
+			return "-"
+		}
+		return v
+	case []string:
+		if len(v) == 0 {
+			return "-"
+		}
+		return strings.Join(v, ",")
+	case map[string]string:
+		if len(v) == 0 {
+			return "-"
+		}
+		var pairs []string
+		for _, key := range slices.Sorted(maps.Keys(v)) {
+			pairs = append(pairs, key+"="+v[key])
+		}
+		return strings.Join(pairs, "\n")
+	default:
+		return fmt.Sprint(v)
+	}
+}
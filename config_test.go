@@ -0,0 +1,56 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestPrintConfig(t *testing.T) {
+	p := newTestProcessor()
+	p.PatchFile = "changes.patch"
+	p.TagOrder = []string{"json", "gorm"}
+	p.MergeStrategies = map[string]string{"json": "json", "gorm": "gorm"}
+	if err := (wktTagFlag{p}).Set(`timestamppb.Timestamp=gorm:"type:timestamptz"`); err != nil {
+		t.Fatal(err)
+	}
+
+	out := captureStdout(t, func() {
+		if err := p.printConfig(configEntry{"files", []string{"a.pb.go", "b.pb.go"}}); err != nil {
+			t.Fatal(err)
+		}
+	})
+	width := len("require_annotations:") + 2
+	for _, want := range []string{
+		"output:" + strings.Repeat(" ", width-len("output:")) + "patch changes.patch\n",
+		"tag_order:" + strings.Repeat(" ", width-len("tag_order:")) + "json,gorm\n",
+		// Maps take a line per key, aligned under the first
+		"merge:" + strings.Repeat(" ", width-len("merge:")) + "gorm=gorm\n" + strings.Repeat(" ", width) + "json=json\n",
+		"name_fallback:" + strings.Repeat(" ", width-len("name_fallback:")) + "json,go\n",
+		"only:" + strings.Repeat(" ", width-len("only:")) + "-\n",
+		"files:" + strings.Repeat(" ", width-len("files:")) + "a.pb.go,b.pb.go\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("missing %q in:\n%s", want, out)
+		}
+	}
+
+	// --wkt-tag implies --wkt-tags, so the defaults are listed too
+	p.LogFormat = "json"
+	out = captureStdout(t, func() {
+		if err := p.printConfig(configEntry{"jobs", 4}); err != nil {
+			t.Fatal(err)
+		}
+	})
+	var config map[string]any
+	if err := json.Unmarshal([]byte(out), &config); err != nil {
+		t.Fatalf("output isn't a JSON object: %v\n%s", err, out)
+	}
+	if config["output"] != "patch changes.patch" || config["jobs"] != 4.0 {
+		t.Errorf("got %v", config)
+	}
+	wktTags, _ := config["wkt_tags"].(map[string]any)
+	if wktTags["timestamppb.Timestamp"] != `gorm:"type:timestamptz"` || len(wktTags) < 2 {
+		t.Errorf("wkt_tags = %v", config["wkt_tags"])
+	}
+}
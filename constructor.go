@@ -0,0 +1,197 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"slices"
+	"strings"
+)
+
+// constructorSpec is a constructor to generate for a struct with
+// @goconstructor
+type constructorSpec struct {
+	Fields []string // Fields to take as parameters, in order; nil for all exported ones
+	Line   int      // Line of the annotation, for errors
+}
+
+// hasFunc reports whether the file declares a function (not a method) with
+// the given name
+func hasFunc(astFile *ast.File, name string) bool {
+	for _, decl := range astFile.Decls {
+		if funcDecl, ok := decl.(*ast.FuncDecl); ok && funcDecl.Recv == nil && funcDecl.Name.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// createConstructor generates New<Type>, taking a parameter for each of the
+// spec's fields and returning a pointer to the struct with them set. It is
+// parsed as a file of its own in fset. Fields are found like @gotags targets,
+// by Go name only with goNames. Without a list of fields, the exported ones
+// and those named in injected are taken, except fields holding a lock, which
+// can't be passed by value and are ready to use as their zero value.
+func createConstructor(fset *token.FileSet, astFile *ast.File, typeSpec *ast.TypeSpec, structType *ast.StructType, spec constructorSpec, injected map[string]bool, goNames bool) (*ast.FuncDecl, error) {
+	var fields []*ast.Field
+	var names []string
+	if spec.Fields == nil {
+		for _, field := range structType.Fields.List {
+			if holdsLock(astFile, field.Type, nil) {
+				continue
+			}
+			for _, ident := range field.Names {
+				if ident.IsExported() || injected[ident.Name] {
+					fields = append(fields, field)
+					names = append(names, ident.Name)
+				}
+			}
+			if name := embeddedFieldName(fieldLabel(field)); len(field.Names) == 0 && (ast.IsExported(name) || injected[name]) {
+				fields = append(fields, field)
+				names = append(names, name)
+			}
+		}
+	} else {
+		for _, name := range spec.Fields {
+			index := findField(structType, name, goNames)
+			if index < 0 {
+				return nil, fmt.Errorf("no field %s in %s", name, typeSpec.Name.Name)
+			}
+			field := structType.Fields.List[index]
+			if holdsLock(astFile, field.Type, nil) {
+				return nil, fmt.Errorf("field %s of %s holds a lock, which can't be passed by value", name, typeSpec.Name.Name)
+			}
+			// The field may be named by its proto name, or be one of several
+			// declared together (A, B int)
+			if fieldName := fieldLabel(field); len(field.Names) == 0 {
+				names = append(names, embeddedFieldName(fieldName))
+			} else {
+				for _, ident := range field.Names {
+					if normalizeFieldName(ident.Name) == normalizeFieldName(name) {
+						fieldName = ident.Name
+					}
+				}
+				names = append(names, fieldName)
+			}
+			fields = append(fields, field)
+		}
+	}
+
+	// Generic structs need their type parameters on the constructor
+	typeName := typeSpec.Name.Name
+	typeParams, typeArgs := "", ""
+	if typeSpec.TypeParams != nil {
+		var params, args []string
+		for _, param := range typeSpec.TypeParams.List {
+			var idents []string
+			for _, ident := range param.Names {
+				idents = append(idents, ident.Name)
+			}
+			params = append(params, strings.Join(idents, ", ")+" "+types.ExprString(param.Type))
+			args = append(args, idents...)
+		}
+		typeParams = "[" + strings.Join(params, ", ") + "]"
+		typeArgs = "[" + strings.Join(args, ", ") + "]"
+	}
+
+	var params, values []string
+	used := make(map[string]bool)
+	for i, field := range fields {
+		param := constructorParam(names[i], used)
+		params = append(params, param+" "+types.ExprString(field.Type))
+		values = append(values, "\t\t"+names[i]+": "+param+",\n")
+	}
+
+	src := fmt.Sprintf("package p\n\nfunc New%s%s(%s) *%s%s {\n\treturn &%s%s{\n%s\t}\n}\n",
+		typeName, typeParams, strings.Join(params, ", "), typeName, typeArgs, typeName, typeArgs, strings.Join(values, ""))
+	file, err := parser.ParseFile(fset, "", src, 0)
+	if err != nil {
+		return nil, err
+	}
+	return file.Decls[0].(*ast.FuncDecl), nil
+}
+
+// constructorParam names the parameter for a field in lowerCamelCase, e.g.
+// userId for UserID, avoiding keywords (type_) and names already used
+func constructorParam(fieldName string, used map[string]bool) string {
+	param := toLowerCamelCase(fieldName)
+	if token.IsKeyword(param) {
+		param += "_"
+	}
+	for i := 2; used[param]; i++ {
+		param = fmt.Sprintf("%s%d", toLowerCamelCase(fieldName), i)
+	}
+	used[param] = true
+	return param
+}
+
+// lockTypes are the types of the sync and sync/atomic packages that must not
+// be copied after first use, which go vet's copylocks check reports
+var lockTypes = map[string][]string{
+	"sync":        {"Cond", "Map", "Mutex", "Once", "Pool", "RWMutex", "WaitGroup"},
+	"sync/atomic": {"Bool", "Int32", "Int64", "Pointer", "Uint32", "Uint64", "Uintptr", "Value"},
+}
+
+// holdsLock reports whether a value of a field type holds a lock: a lock
+// type, an array of them, or a struct of the file with a field holding one.
+// Other types of other packages can't be looked into and are assumed not
+// to.
+// seen holds the file's types already being checked.
+func holdsLock(astFile *ast.File, expr ast.Expr, seen map[string]bool) bool {
+	switch t := expr.(type) {
+	case *ast.ParenExpr:
+		return holdsLock(astFile, t.X, seen)
+	case *ast.ArrayType:
+		return t.Len != nil && holdsLock(astFile, t.Elt, seen)
+	case *ast.IndexExpr:
+		return holdsLock(astFile, t.X, seen)
+	case *ast.IndexListExpr:
+		return holdsLock(astFile, t.X, seen)
+	case *ast.StructType:
+		for _, field := range t.Fields.List {
+			if holdsLock(astFile, field.Type, seen) {
+				return true
+			}
+		}
+	case *ast.SelectorExpr:
+		pkg, ok := t.X.(*ast.Ident)
+		if !ok {
+			return false
+		}
+		// Packages imported automatically for injected fields may not be
+		// imported yet
+		path := stdlibPackages[pkg.Name]
+		for _, imp := range astFile.Imports {
+			alias := ""
+			if imp.Name != nil {
+				alias = imp.Name.Name
+			}
+			if impPath := strings.Trim(imp.Path.Value, "\"`"); importName(alias, impPath) == pkg.Name {
+				path = impPath
+			}
+		}
+		return slices.Contains(lockTypes[path], t.Sel.Name)
+	case *ast.Ident:
+		if seen[t.Name] {
+			return false
+		}
+		for _, decl := range astFile.Decls {
+			genDecl, ok := decl.(*ast.GenDecl)
+			if !ok || genDecl.Tok != token.TYPE {
+				continue
+			}
+			for _, spec := range genDecl.Specs {
+				if typeSpec := spec.(*ast.TypeSpec); typeSpec.Name.Name == t.Name {
+					if seen == nil {
+						seen = make(map[string]bool)
+					}
+					seen[t.Name] = true
+					return holdsLock(astFile, typeSpec.Type, seen)
+				}
+			}
+		}
+	}
+	return false
+}
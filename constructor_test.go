@@ -0,0 +1,78 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestConstructor(t *testing.T) {
+	tests := []struct {
+		name        string
+		annotations string
+		want        string // Constructor expected in the output
+		wantErr     string // Part of the error expected instead
+	}{
+		{
+			"exported and injected fields",
+			"// @gofield: Nickname string\n// @gofield: cache map[string]string\n// @goconstructor",
+			"func NewUser(name string, nickname string, cache map[string]string) *User {\n\treturn &User{\n\t\tName:     name,\n\t\tNickname: nickname,\n\t\tcache:    cache,\n\t}\n}",
+			"",
+		},
+		{
+			"locks skipped",
+			"// @gofield[0]: sync.Mutex\n// @gofield: hits atomic.Int64\n// @gofield: Lock sync.RWMutex\n// @goconstructor",
+			"func NewUser(name string) *User {\n\treturn &User{\n\t\tName: name,\n\t}\n}",
+			"",
+		},
+		{
+			"listed fields",
+			"// @gofield: Nickname string\n// @goconstructor: Nickname, id",
+			"func NewUser(nickname string, id int64) *User {\n\treturn &User{\n\t\tNickname: nickname,\n\t\tid:       id,\n\t}\n}",
+			"",
+		},
+		{
+			"listed lock",
+			"// @gofield: Lock sync.Mutex\n// @goconstructor: Name, Lock",
+			"",
+			"field Lock of User holds a lock",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src := "package pb\n\n// @gotype: User\n" + tt.annotations + "\n\ntype User struct {\n\tName string\n\tid   int64\n\tmu   guarded\n}\n\ntype guarded struct{ locks [2]sync.Mutex }\n"
+			if tt.wantErr != "" {
+				path := filepath.Join(t.TempDir(), "test.pb.go")
+				if err := os.WriteFile(path, []byte(src), 0644); err != nil {
+					t.Fatal(err)
+				}
+				var err error
+				captureStdout(t, func() { _, err = newTestProcessor().processFile(path) })
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("expected error %q, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			out := process(t, src)
+			if !strings.Contains(out, tt.want) {
+				t.Errorf("constructor\n%s\nnot found in:\n%s", tt.want, out)
+			}
+		})
+	}
+}
+
+func TestConstructorExisting(t *testing.T) {
+	src := "package pb\n\n// @gotype: User\n// @goconstructor\n\ntype User struct {\n\tName string\n}\n\nfunc NewUser() *User { return &User{} }\n"
+	if out := process(t, src); strings.Count(out, "func NewUser") != 1 {
+		t.Errorf("existing constructor not kept:\n%s", out)
+	}
+}
+
+func TestConstructorGeneric(t *testing.T) {
+	src := "package pb\n\n// @gotype: Box\n// @gofield: Items []T\n// @goconstructor\n\ntype Box[T any, K comparable] struct {\n\tValue T\n\tKey   K\n}\n"
+	want := "func NewBox[T any, K comparable](value T, key K, items []T) *Box[T, K] {\n\treturn &Box[T, K]{\n\t\tValue: value,\n\t\tKey:   key,\n\t\tItems: items,\n\t}\n}"
+	if out := process(t, src); !strings.Contains(out, want) {
+		t.Errorf("constructor\n%s\nnot found in:\n%s", want, out)
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// ownPackage is the import path of the package a processed file belongs
+// to, and of the module containing it, found from the nearest go.mod
+type ownPackage struct {
+	Path   string // e.g. example.com/app/gen/userpb
+	Module string // e.g. example.com/app
+}
+
+// findOwnPackage looks for the go.mod above a file to tell the import path
+// of its package. It returns nil when the file isn't in a module.
+func findOwnPackage(inputPath string) (*ownPackage, error) {
+	dir, err := filepath.Abs(filepath.Dir(inputPath))
+	if err != nil {
+		return nil, err
+	}
+	for root := dir; ; root = filepath.Dir(root) {
+		module, err := readModulePath(filepath.Join(root, "go.mod"))
+		if err != nil {
+			return nil, err
+		}
+		if module != "" {
+			rel, err := filepath.Rel(root, dir)
+			if err != nil {
+				return nil, err
+			}
+			pkg := &ownPackage{Path: module, Module: module}
+			if rel != "." {
+				pkg.Path += "/" + filepath.ToSlash(rel)
+			}
+			return pkg, nil
+		}
+		if filepath.Dir(root) == root {
+			return nil, nil
+		}
+	}
+}
+
+// readModulePath returns the module path declared in a go.mod file, or ""
+// when there is no such file
+func readModulePath(path string) (string, error) {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 2 && fields[0] == "module" {
+			if module, err := strconv.Unquote(fields[1]); err == nil {
+				return module, nil
+			}
+			return fields[1], nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+	return "", fmt.Errorf("%s has no module line", path)
+}
+
+// checkImportCycle warns about an added import that may create an import
+// cycle. Telling for sure needs the whole build graph, so any package of
+// the file's own module is suspect, as it may import the file's package.
+func (p *Processor) checkImportCycle(inputPath string, pkg *ownPackage, imp importEntry) {
+	switch {
+	case imp.Path == pkg.Path:
+		p.warnAtf(inputPath, imp.Line, "import %q is the file's own package, which is an import cycle", imp.Path)
+	case imp.Path == pkg.Module || strings.HasPrefix(imp.Path, pkg.Module+"/"):
+		p.warnAtf(inputPath, imp.Line, "import %q is in the module of the file's package %s, and may create an import cycle if it imports it", imp.Path, pkg.Path)
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFindOwnPackage(t *testing.T) {
+	root := t.TempDir()
+	writeTree(t, root, map[string]string{
+		"go.mod":                "// app\nmodule \"example.com/app\"\n\ngo 1.23\n",
+		"gen/userpb/user.pb.go": "",
+		"user.pb.go":            "",
+		"tools/go.mod":          "module example.com/tools\n",
+		"tools/gen/tool.pb.go":  "",
+		"broken/go.mod":         "go 1.23\n",
+		"broken/gen/bad.pb.go":  "",
+	})
+	tests := []struct {
+		file string
+		want ownPackage
+	}{
+		{"gen/userpb/user.pb.go", ownPackage{"example.com/app/gen/userpb", "example.com/app"}},
+		{"user.pb.go", ownPackage{"example.com/app", "example.com/app"}},
+		{"tools/gen/tool.pb.go", ownPackage{"example.com/tools/gen", "example.com/tools"}},
+	}
+	for _, tt := range tests {
+		pkg, err := findOwnPackage(filepath.Join(root, tt.file))
+		if err != nil || pkg == nil || *pkg != tt.want {
+			t.Errorf("findOwnPackage(%s) = %+v, %v, want %+v", tt.file, pkg, err, tt.want)
+		}
+	}
+	if _, err := findOwnPackage(filepath.Join(root, "broken/gen/bad.pb.go")); err == nil {
+		t.Error("go.mod without a module line accepted")
+	}
+}
+
+func TestWarnImportCycles(t *testing.T) {
+	root := t.TempDir()
+	src := "package userpb\n\n" +
+		"// @goimport: \"example.com/app/gen/userpb\"\n" +
+		"// @goimport: \"example.com/app/internal/store\"\n" +
+		"// @goimport: \"example.com/application\"\n" +
+		"// @goimport: \"gorm.io/gorm\"\n\n" +
+		"type User struct{}\n"
+	writeTree(t, root, map[string]string{
+		"go.mod":                "module example.com/app\n",
+		"gen/userpb/user.pb.go": src,
+	})
+	path := filepath.Join(root, "gen", "userpb", "user.pb.go")
+
+	p := newTestProcessor()
+	p.WarnImportCycles = true
+	out := captureStdout(t, func() {
+		if _, err := p.processFile(path); err != nil {
+			t.Fatal(err)
+		}
+	})
+	for _, want := range []string{
+		path + `:3: warning: import "example.com/app/gen/userpb" is the file's own package`,
+		path + `:4: warning: import "example.com/app/internal/store" is in the module of the file's package example.com/app/gen/userpb`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("missing warning %q:\n%s", want, out)
+		}
+	}
+	if p.warnings != 2 {
+		t.Errorf("got %d warnings, want 2:\n%s", p.warnings, out)
+	}
+
+	// Nothing is checked without the option
+	p = newTestProcessor()
+	captureStdout(t, func() { p.processFile(path) })
+	if p.warnings != 0 {
+		t.Errorf("got %d warnings without WarnImportCycles", p.warnings)
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"sort"
+	"strings"
+)
+
+// defaultCommentPrefix starts the trailing comment recording the default of
+// a field injected with @gofield: Name Type = expr
+const defaultCommentPrefix = "// default: "
+
+// splitFieldDefault splits the "= expr" noting a field's default off a
+// @gofield declaration. An = inside brackets or quotes belongs to the type
+// (e.g. func(a [n]int) or struct{ K string `x:"a=b"` }).
+func splitFieldDefault(decl string) (string, string) {
+	depth := 0
+	var quote rune
+	for i, r := range decl {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '"' || r == '`' || r == '\'':
+			quote = r
+		case r == '[' || r == '(' || r == '{':
+			depth++
+		case r == ']' || r == ')' || r == '}':
+			depth--
+		case r == '=' && depth == 0:
+			return strings.TrimSpace(decl[:i]), strings.TrimSpace(decl[i+1:])
+		}
+	}
+	return decl, ""
+}
+
+// addDefaultComments appends a "// default: expr" comment to the injected
+// fields given defaults, by struct and field name, in formatted source. Struct
+// fields can't have defaults, so they are recorded for readers only. The
+// source is formatted again to align the comments.
+func addDefaultComments(src []byte, defaults map[string]map[string]string) ([]byte, error) {
+	fset := token.NewFileSet()
+	astFile, err := parser.ParseFile(fset, "", src, parser.ParseComments)
+	if err != nil {
+		return nil, err
+	}
+
+	// Comment to insert at each field end offset
+	inserts := make(map[int]string)
+	for _, decl := range astFile.Decls {
+		genDecl, ok := decl.(*ast.GenDecl)
+		if !ok || genDecl.Tok != token.TYPE {
+			continue
+		}
+		for _, spec := range genDecl.Specs {
+			typeSpec, ok := spec.(*ast.TypeSpec)
+			if !ok {
+				continue
+			}
+			structType, ok := typeSpec.Type.(*ast.StructType)
+			if !ok || defaults[typeSpec.Name.Name] == nil {
+				continue
+			}
+			for _, field := range structType.Fields.List {
+				def, ok := defaults[typeSpec.Name.Name][fieldLabel(field)]
+				if ok && field.Comment == nil {
+					inserts[fset.Position(field.End()).Offset] = " " + defaultCommentPrefix + def
+				}
+			}
+		}
+	}
+	if len(inserts) == 0 {
+		return src, nil
+	}
+
+	offsets := make([]int, 0, len(inserts))
+	for offset := range inserts {
+		offsets = append(offsets, offset)
+	}
+	sort.Ints(offsets)
+
+	out := make([]byte, 0, len(src)+len(inserts)*16)
+	last := 0
+	for _, offset := range offsets {
+		out = append(out, src[last:offset]...)
+		out = append(out, inserts[offset]...)
+		last = offset
+	}
+	return format.Source(append(out, src[last:]...))
+}
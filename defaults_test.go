@@ -0,0 +1,67 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSplitFieldDefault(t *testing.T) {
+	tests := []struct {
+		decl, want, def string
+	}{
+		{"Retries int = 5", "Retries int", "5"},
+		{"Retries int", "Retries int", ""},
+		{`Name string = "a=b"`, "Name string", `"a=b"`},
+		{"Limits [n]int = [n]int{1}", "Limits [n]int", "[n]int{1}"},
+		{"Fn func(a, b int) bool", "Fn func(a, b int) bool", ""},
+		{"Meta struct{ K string `x:\"a=b\"` } = nil", "Meta struct{ K string `x:\"a=b\"` }", "nil"},
+		{"Sep rune = '='", "Sep rune", "'='"},
+	}
+	for _, tt := range tests {
+		if decl, def := splitFieldDefault(tt.decl); decl != tt.want || def != tt.def {
+			t.Errorf("splitFieldDefault(%q) = %q, %q, want %q, %q", tt.decl, decl, def, tt.want, tt.def)
+		}
+	}
+}
+
+func TestFieldDefaults(t *testing.T) {
+	src := "package pb\n\n// @gotype: Config\n// @gofield: Retries int = 5\n// @gofield: Name string = \"default\"\n// @gofield: Debug bool\n\n" +
+		"type Config struct {\n\tId int64 `protobuf:\"varint,1,opt,name=id,proto3\" json:\"id,omitempty\"`\n}\n"
+	want := "type Config struct {\n\tId      int64  `protobuf:\"varint,1,opt,name=id,proto3\" json:\"id,omitempty\"`\n" +
+		"\tRetries int    // default: 5\n\tName    string // default: \"default\"\n\tDebug   bool\n}"
+	out := process(t, src)
+	if got := structDecl(out, "Config"); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+	if again := process(t, out); again != out {
+		t.Errorf("rerun changed the file:\n%s", again)
+	}
+
+	// --extract gives the defaults back
+	path := filepath.Join(t.TempDir(), "test.pb.go")
+	if err := os.WriteFile(path, []byte(strings.Replace(out, "// @gotype: Config\n", "", 1)), 0644); err != nil {
+		t.Fatal(err)
+	}
+	annotations, err := newTestProcessor().extractAnnotations(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"// @gofield: Retries int = 5\n", "// @gofield: Name string = \"default\"\n", "// @gofield: Debug bool\n"} {
+		if !strings.Contains(annotations, want) {
+			t.Errorf("missing %q in:\n%s", want, annotations)
+		}
+	}
+}
+
+func TestFieldDefaultInvalid(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.pb.go")
+	src := "package pb\n\ntype Config struct {\n\t// @gofield: Retries int = 5 +\n}\n"
+	if err := os.WriteFile(path, []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := newTestProcessor().processFile(path); err == nil || !strings.Contains(err.Error(), `invalid default "5 +"`) {
+		t.Errorf("got error %v", err)
+	}
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// diffContext is the number of unchanged lines shown around each change
+const diffContext = 3
+
+// diffOp is a single line of an edit script: ' ' keeps, '-' deletes and '+'
+// inserts the line
+type diffOp struct {
+	Kind byte
+	Line string
+}
+
+// diffLines computes the shortest edit script turning a into b using Myers'
+// algorithm in its linear space form: the middle snake of an optimal path
+// splits the problem in two, so memory stays proportional to the input even
+// when a file is rewritten entirely. Within a run of changes the deleted
+// lines come before the inserted ones.
+func diffLines(a, b []string) []diffOp {
+	size := (len(a)+len(b)+1)/2 + 2
+	d := &differ{
+		a:  a,
+		b:  b,
+		vf: make([]int, 2*size),
+		vb: make([]int, 2*size),
+	}
+	d.compare(0, len(a), 0, len(b))
+
+	// Order each run of changes as deletions followed by insertions
+	for i := 0; i < len(d.ops); {
+		if d.ops[i].Kind == ' ' {
+			i++
+			continue
+		}
+		j := i
+		for j < len(d.ops) && d.ops[j].Kind != ' ' {
+			j++
+		}
+		sort.SliceStable(d.ops[i:j], func(x, y int) bool {
+			return d.ops[i+x].Kind == '-' && d.ops[i+y].Kind == '+'
+		})
+		i = j
+	}
+	return d.ops
+}
+
+// differ holds the state of a diffLines run. vf and vb are the furthest
+// reaching x of the forward and backward searches per diagonal, shared by
+// every level of the recursion.
+type differ struct {
+	a, b   []string
+	vf, vb []int
+	ops    []diffOp
+}
+
+// compare appends the edit script turning a[aLo:aHi] into b[bLo:bHi]
+func (d *differ) compare(aLo, aHi, bLo, bHi int) {
+	for aLo < aHi && bLo < bHi && d.a[aLo] == d.b[bLo] {
+		d.ops = append(d.ops, diffOp{Kind: ' ', Line: d.a[aLo]})
+		aLo++
+		bLo++
+	}
+	suffix := aHi
+	for aHi > aLo && bHi > bLo && d.a[aHi-1] == d.b[bHi-1] {
+		aHi--
+		bHi--
+	}
+
+	switch {
+	case aLo == aHi:
+		for _, line := range d.b[bLo:bHi] {
+			d.ops = append(d.ops, diffOp{Kind: '+', Line: line})
+		}
+	case bLo == bHi:
+		for _, line := range d.a[aLo:aHi] {
+			d.ops = append(d.ops, diffOp{Kind: '-', Line: line})
+		}
+	default:
+		// With the common prefix and suffix gone at least two edits are
+		// left, so both halves around the middle snake are smaller
+		x, y, u, v := d.middleSnake(aLo, aHi, bLo, bHi)
+		d.compare(aLo, x, bLo, y)
+		for _, line := range d.a[x:u] {
+			d.ops = append(d.ops, diffOp{Kind: ' ', Line: line})
+		}
+		d.compare(u, aHi, v, bHi)
+	}
+
+	for _, line := range d.a[aHi:suffix] {
+		d.ops = append(d.ops, diffOp{Kind: ' ', Line: line})
+	}
+}
+
+// middleSnake runs the forward and backward searches from both corners of
+// a[aLo:aHi] by b[bLo:bHi] until they meet, and returns the snake where they
+// do, from (x, y) to (u, v)
+func (d *differ) middleSnake(aLo, aHi, bLo, bHi int) (x, y, u, v int) {
+	n, m := aHi-aLo, bHi-bLo
+	delta := n - m
+	odd := delta%2 != 0
+	offset := len(d.vf) / 2
+	vf, vb := d.vf, d.vb
+	vf[offset+1], vb[offset+1] = 0, 0
+
+	for step := 0; step <= (n+m+1)/2; step++ {
+		// Forward search, in the coordinates of a and b
+		for k := -step; k <= step; k += 2 {
+			var x int
+			if k == -step || (k != step && vf[offset+k-1] < vf[offset+k+1]) {
+				x = vf[offset+k+1] // Move down, inserting from b
+			} else {
+				x = vf[offset+k-1] + 1 // Move right, deleting from a
+			}
+			y := x - k
+			startX, startY := x, y
+			for x < n && y < m && d.a[aLo+x] == d.b[bLo+y] {
+				x++
+				y++
+			}
+			vf[offset+k] = x
+			if kr := delta - k; odd && kr >= -(step-1) && kr <= step-1 && x+vb[offset+kr] >= n {
+				return aLo + startX, bLo + startY, aLo + x, bLo + y
+			}
+		}
+
+		// Backward search, in coordinates counted from the ends
+		for kr := -step; kr <= step; kr += 2 {
+			var xr int
+			if kr == -step || (kr != step && vb[offset+kr-1] < vb[offset+kr+1]) {
+				xr = vb[offset+kr+1]
+			} else {
+				xr = vb[offset+kr-1] + 1
+			}
+			yr := xr - kr
+			startX, startY := xr, yr
+			for xr < n && yr < m && d.a[aHi-1-xr] == d.b[bHi-1-yr] {
+				xr++
+				yr++
+			}
+			vb[offset+kr] = xr
+			if k := delta - kr; !odd && k >= -step && k <= step && xr+vf[offset+k] >= n {
+				return aHi - xr, bHi - yr, aHi - startX, bHi - startY
+			}
+		}
+	}
+	panic("diff: searches did not meet")
+}
+
+// unifiedDiff returns a git-style unified diff between two versions of a
+// file, or "" when they are equal
+func unifiedDiff(path string, before, after []byte) string {
+	if string(before) == string(after) {
+		return ""
+	}
+	ops := diffLines(splitLines(string(before)), splitLines(string(after)))
+
+	// Line numbers in a and b before each op
+	aLine := make([]int, len(ops)+1)
+	bLine := make([]int, len(ops)+1)
+	for i, op := range ops {
+		aLine[i+1], bLine[i+1] = aLine[i], bLine[i]
+		if op.Kind != '+' {
+			aLine[i+1]++
+		}
+		if op.Kind != '-' {
+			bLine[i+1]++
+		}
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "diff --git a/%s b/%s\n", path, path)
+	fmt.Fprintf(&sb, "--- a/%s\n+++ b/%s\n", path, path)
+	for i := 0; i < len(ops); {
+		if ops[i].Kind == ' ' {
+			i++
+			continue
+		}
+
+		// Extend the hunk while changes are close enough to share context
+		start := max(0, i-diffContext)
+		last := i
+		for j := i; j < len(ops) && j-last <= 2*diffContext; j++ {
+			if ops[j].Kind != ' ' {
+				last = j
+			}
+		}
+		end := min(len(ops), last+diffContext+1)
+
+		fmt.Fprintf(&sb, "@@ -%s +%s @@\n",
+			hunkRange(aLine[start], aLine[end]-aLine[start]),
+			hunkRange(bLine[start], bLine[end]-bLine[start]))
+		for _, op := range ops[start:end] {
+			sb.WriteByte(op.Kind)
+			sb.WriteString(op.Line)
+			if !strings.HasSuffix(op.Line, "\n") {
+				sb.WriteString("\n\\ No newline at end of file\n")
+			}
+		}
+		i = end
+	}
+	return sb.String()
+}
+
+// hunkRange formats the start,count part of a hunk header
+func hunkRange(start, count int) string {
+	switch count {
+	case 0:
+		return fmt.Sprintf("%d,0", start)
+	case 1:
+		return fmt.Sprintf("%d", start+1)
+	default:
+		return fmt.Sprintf("%d,%d", start+1, count)
+	}
+}
+
+// splitLines splits text into lines, keeping their line endings
+func splitLines(text string) []string {
+	lines := strings.SplitAfter(text, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// newFileDiff returns the diff creating a file, marked as such for git apply
+func newFileDiff(path string, content []byte) string {
+	diff := unifiedDiff(path, nil, content)
+	return strings.Replace(diff, "--- a/"+path+"\n", "new file mode 100644\n--- /dev/null\n", 1)
+}
+
+// deletedFileDiff returns the diff removing a file, marked as such for git
+// apply
+func deletedFileDiff(path string, content []byte) string {
+	diff := unifiedDiff(path, content, nil)
+	return strings.Replace(diff, "--- a/"+path+"\n+++ b/"+path+"\n", "deleted file mode 100644\n--- a/"+path+"\n+++ /dev/null\n", 1)
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestUnifiedDiff(t *testing.T) {
+	tests := []struct {
+		name          string
+		before, after string
+		want          string
+	}{
+		{
+			name:   "equal",
+			before: "a\nb\n",
+			after:  "a\nb\n",
+			want:   "",
+		},
+		{
+			name:   "insert",
+			before: "a\nb\nc\n",
+			after:  "a\nb\nx\nc\n",
+			want: "diff --git a/f.go b/f.go\n--- a/f.go\n+++ b/f.go\n" +
+				"@@ -1,3 +1,4 @@\n a\n b\n+x\n c\n",
+		},
+		{
+			name:   "delete",
+			before: "a\nb\nc\n",
+			after:  "a\nc\n",
+			want: "diff --git a/f.go b/f.go\n--- a/f.go\n+++ b/f.go\n" +
+				"@@ -1,3 +1,2 @@\n a\n-b\n c\n",
+		},
+		{
+			name:   "from empty",
+			before: "",
+			after:  "a\n",
+			want: "diff --git a/f.go b/f.go\n--- a/f.go\n+++ b/f.go\n" +
+				"@@ -0,0 +1 @@\n+a\n",
+		},
+		{
+			name:   "no newline at end",
+			before: "a\nb",
+			after:  "a\nb\n",
+			want: "diff --git a/f.go b/f.go\n--- a/f.go\n+++ b/f.go\n" +
+				"@@ -1,2 +1,2 @@\n a\n-b\n\\ No newline at end of file\n+b\n",
+		},
+		{
+			name:   "separate hunks",
+			before: "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n",
+			after:  "x\n2\n3\n4\n5\n6\n7\n8\n9\ny\n",
+			want: "diff --git a/f.go b/f.go\n--- a/f.go\n+++ b/f.go\n" +
+				"@@ -1,4 +1,4 @@\n-1\n+x\n 2\n 3\n 4\n" +
+				"@@ -7,4 +7,4 @@\n 7\n 8\n 9\n-10\n+y\n",
+		},
+		{
+			name:   "merged hunks",
+			before: "1\n2\n3\n4\n5\n6\n7\n",
+			after:  "x\n2\n3\n4\n5\n6\ny\n",
+			want: "diff --git a/f.go b/f.go\n--- a/f.go\n+++ b/f.go\n" +
+				"@@ -1,7 +1,7 @@\n-1\n+x\n 2\n 3\n 4\n 5\n 6\n-7\n+y\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := unifiedDiff("f.go", []byte(tt.before), []byte(tt.after))
+			if got != tt.want {
+				t.Errorf("got:\n%s\nwant:\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+// applyOps rebuilds both sides of an edit script
+func applyOps(ops []diffOp) (a, b []string) {
+	for _, op := range ops {
+		if op.Kind != '+' {
+			a = append(a, op.Line)
+		}
+		if op.Kind != '-' {
+			b = append(b, op.Line)
+		}
+	}
+	return a, b
+}
+
+// editDistance counts the inserted and deleted lines of the shortest edit
+// script by dynamic programming
+func editDistance(a, b []string) int {
+	prev := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur := make([]int, len(b)+1)
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			if a[i-1] == b[j-1] {
+				cur[j] = prev[j-1]
+			} else {
+				cur[j] = min(prev[j], cur[j-1]) + 1
+			}
+		}
+		prev = cur
+	}
+	return prev[len(b)]
+}
+
+func countEdits(ops []diffOp) int {
+	edits := 0
+	for _, op := range ops {
+		if op.Kind != ' ' {
+			edits++
+		}
+	}
+	return edits
+}
+
+func TestDiffLinesIsShortest(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 500; i++ {
+		a := make([]string, rng.Intn(20))
+		for j := range a {
+			a[j] = string(rune('a' + rng.Intn(4)))
+		}
+		b := make([]string, rng.Intn(20))
+		for j := range b {
+			b[j] = string(rune('a' + rng.Intn(4)))
+		}
+		ops := diffLines(a, b)
+		gotA, gotB := applyOps(ops)
+		if strings.Join(gotA, "") != strings.Join(a, "") || strings.Join(gotB, "") != strings.Join(b, "") {
+			t.Fatalf("diffLines(%q, %q) = %v does not rebuild the inputs", a, b, ops)
+		}
+		if got, want := countEdits(ops), editDistance(a, b); got != want {
+			t.Fatalf("diffLines(%q, %q) makes %d edits, want %d", a, b, got, want)
+		}
+	}
+}
+
+func TestDiffLinesLargeInput(t *testing.T) {
+	// A large file with a few scattered edits, as injection makes
+	var a, b []string
+	for i := 0; i < 100000; i++ {
+		line := fmt.Sprintf("line %d\n", i)
+		a = append(a, line)
+		if i%10000 == 5000 {
+			b = append(b, "\tAge int\n")
+		}
+		if i%25000 != 100 {
+			b = append(b, line)
+		}
+	}
+	ops := diffLines(a, b)
+	if gotA, gotB := applyOps(ops); !reflect.DeepEqual(gotA, a) || !reflect.DeepEqual(gotB, b) {
+		t.Fatal("edit script does not rebuild the inputs")
+	}
+	if got := countEdits(ops); got != 14 {
+		t.Errorf("got %d edits, want 14", got)
+	}
+
+	// Two files with nothing in common need an edit per line, which the
+	// quadratic form of the algorithm would need hundreds of megabytes for
+	a, b = a[:5000], nil
+	for i := 0; i < 5000; i++ {
+		b = append(b, fmt.Sprintf("other %d\n", i))
+	}
+	ops = diffLines(a, b)
+	if gotA, gotB := applyOps(ops); !reflect.DeepEqual(gotA, a) || !reflect.DeepEqual(gotB, b) {
+		t.Fatal("edit script does not rebuild the inputs")
+	}
+	if got := countEdits(ops); got != 10000 {
+		t.Errorf("got %d edits, want 10000", got)
+	}
+}
+
+func TestPatchLeavesFilesAlone(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "test.pb.go")
+	src := "package pb\n\ntype User struct {\n\t// @gofield: Age int\n}\n"
+	if err := os.WriteFile(path, []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+	p := newTestProcessor()
+	p.PatchFile = filepath.Join(dir, "inject.patch")
+	p.patches = make(map[string]string)
+	captureStdout(t, func() { p.run(path) })
+	if err := p.writePatch(); err != nil {
+		t.Fatal(err)
+	}
+
+	if data, err := os.ReadFile(path); err != nil || string(data) != src {
+		t.Errorf("input changed: %q, %v", data, err)
+	}
+	patch, err := os.ReadFile(p.PatchFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	name := patchPath(path)
+	if !strings.HasPrefix(string(patch), "diff --git a/"+name+" b/"+name+"\n") {
+		t.Errorf("patch header names the wrong file:\n%s", patch)
+	}
+	if !strings.Contains(string(patch), "+\tAge int\n") {
+		t.Errorf("patch does not add the field:\n%s", patch)
+	}
+}
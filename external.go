@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/token"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// companionHeader starts the files --external-methods writes, so they are
+// known to be the tool's to overwrite or remove
+const companionHeader = "// Code generated by protoc-go-inject. DO NOT EDIT."
+
+// companionPath returns the file --external-methods writes the methods of
+// a file to, next to it: user.pb.go -> user_inject.go
+func companionPath(fpath string) string {
+	return strings.TrimSuffix(strings.TrimSuffix(fpath, ".go"), ".pb") + "_inject.go"
+}
+
+// readCompanion reads a file's companion, nil if it doesn't exist. A file of
+// that name the tool didn't write is an error, so it is never overwritten.
+func readCompanion(fpath string) ([]byte, error) {
+	path := companionPath(fpath)
+	content, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %v", path, err)
+	}
+	if !bytes.HasPrefix(bytes.TrimPrefix(content, utf8BOM), []byte(companionHeader)) {
+		return nil, fmt.Errorf("%s exists but wasn't written by protoc-go-inject, remove or rename it to use --external-methods", path)
+	}
+	return content, nil
+}
+
+// buildConstraints returns the //go:build and // +build lines above a
+// file's package clause, so its companion is built along with it
+func buildConstraints(astFile *ast.File) []string {
+	var lines []string
+	for _, group := range astFile.Comments {
+		if group.Pos() >= astFile.Package {
+			break
+		}
+		for _, comment := range group.List {
+			if strings.HasPrefix(comment.Text, "//go:build ") || strings.HasPrefix(comment.Text, "// +build ") {
+				lines = append(lines, comment.Text)
+			}
+		}
+	}
+	return lines
+}
+
+// companionImports returns the imports the declarations moved to a
+// companion need: those the file has under the names they refer to, else
+// @goimport(used) ones, else standard library packages
+func companionImports(astFile *ast.File, imports []importEntry, decls []ast.Decl) []importEntry {
+	var needed []importEntry
+	for _, decl := range decls {
+	names:
+		for _, name := range referencedPackages(decl) {
+			if slices.ContainsFunc(needed, func(imp importEntry) bool { return importName(imp.Alias, imp.Path) == name }) {
+				continue
+			}
+			for _, impSpec := range astFile.Imports {
+				path, err := strconv.Unquote(impSpec.Path.Value)
+				if err != nil {
+					continue
+				}
+				alias := ""
+				if impSpec.Name != nil {
+					alias = impSpec.Name.Name
+				}
+				if importName(alias, path) == name {
+					needed = append(needed, importEntry{Path: path, Alias: alias})
+					continue names
+				}
+			}
+			for _, imp := range imports {
+				if imp.IfUsed && importName(imp.Alias, imp.Path) == name {
+					needed = append(needed, importEntry{Path: imp.Path, Alias: imp.Alias})
+					continue names
+				}
+			}
+			// Other names are usually receivers or parameters (x.Name)
+			if path, ok := stdlibPackages[name]; ok {
+				needed = append(needed, importEntry{Path: path})
+			}
+		}
+	}
+	return needed
+}
+
+// renderCompanion renders the companion of a file holding decls: the same
+// build constraints and package, and the imports the declarations use
+func renderCompanion(fset *token.FileSet, astFile *ast.File, imports []importEntry, decls []ast.Decl) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteString(companionHeader + "\n\n")
+	for _, line := range buildConstraints(astFile) {
+		buf.WriteString(line + "\n")
+	}
+	fmt.Fprintf(&buf, "\npackage %s\n", astFile.Name.Name)
+	if needed := companionImports(astFile, imports, decls); len(needed) > 0 {
+		buf.WriteString("\nimport (\n")
+		for _, imp := range needed {
+			if imp.Alias != "" {
+				buf.WriteString(imp.Alias + " ")
+			}
+			buf.WriteString(strconv.Quote(imp.Path) + "\n")
+		}
+		buf.WriteString(")\n")
+	}
+	for _, decl := range decls {
+		buf.WriteString("\n")
+		if err := format.Node(&buf, fset, decl); err != nil {
+			return nil, err
+		}
+		buf.WriteString("\n")
+	}
+	return format.Source(buf.Bytes())
+}
+
+// writeCompanion puts the companion processFile rendered for a file where
+// the file's result goes: in place, into the patch or under --out. Without
+// one, a companion left by an earlier run is removed, in place or in the
+// patch, as its methods are no longer wanted.
+func (p *Processor) writeCompanion(fpath string) error {
+	path := companionPath(fpath)
+	content, err := os.ReadFile(path + p.Suffix)
+	if err == nil {
+		os.Remove(path + p.Suffix)
+	} else if !os.IsNotExist(err) {
+		return fmt.Errorf("failed to read companion file: %v", err)
+	}
+	existing, err := readCompanion(fpath)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case p.PatchFile != "":
+		var diff string
+		switch {
+		case content == nil && existing != nil:
+			diff = deletedFileDiff(patchPath(path), existing)
+		case content != nil && existing == nil:
+			diff = newFileDiff(patchPath(path), content)
+		default:
+			diff = unifiedDiff(patchPath(path), existing, content)
+		}
+		if diff != "" {
+			p.patchMu.Lock()
+			p.patches[patchPath(path)] = diff
+			p.patchMu.Unlock()
+		}
+	case p.OutDir != "":
+		if content != nil {
+			return p.writeOutput(path, content)
+		}
+	case content != nil:
+		if err := os.WriteFile(path, content, 0644); err != nil {
+			return fmt.Errorf("failed to write companion file: %v", err)
+		}
+	case existing != nil:
+		if err := os.Remove(path); err != nil {
+			return fmt.Errorf("failed to remove companion file: %v", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCompanionPath(t *testing.T) {
+	for input, want := range map[string]string{
+		"user.pb.go":      "user_inject.go",
+		"api/user.pb.go":  "api/user_inject.go",
+		"models/order.go": "models/order_inject.go",
+	} {
+		if got := companionPath(input); got != want {
+			t.Errorf("companionPath(%q) = %q, want %q", input, got, want)
+		}
+	}
+}
+
+func TestExternalMethods(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "user.pb.go")
+	companion := filepath.Join(dir, "user_inject.go")
+	src := "//go:build linux\n\npackage pb\n\n" +
+		"import \"time\"\n\n" +
+		"// @gotype: User\n" +
+		"// @gomethod: Since() time.Duration { return time.Since(x.Created) }\n" +
+		"// @goconstructor\n\n" +
+		"type User struct {\n\tCreated time.Time\n}\n"
+	if err := os.WriteFile(path, []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	p := newTestProcessor()
+	p.ExternalMethods = true
+	captureStdout(t, func() { p.run(path) })
+	if len(p.failures) > 0 {
+		t.Fatalf("failures = %+v", p.failures)
+	}
+	// Only methods were injected, so the file is left as generated
+	if data, err := os.ReadFile(path); err != nil || string(data) != src {
+		t.Errorf("file changed: %v\n%s", err, data)
+	}
+	data, err := os.ReadFile(companion)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := string(data)
+	for _, want := range []string{
+		companionHeader + "\n\n//go:build linux\n\npackage pb\n\nimport (\n\t\"time\"\n)\n",
+		"func (x *User) Since() time.Duration { return time.Since(x.Created) }\n",
+		"func NewUser(created time.Time) *User {\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("companion missing %q:\n%s", want, got)
+		}
+	}
+	if _, err := os.Stat(path + p.Suffix); !os.IsNotExist(err) {
+		t.Errorf("intermediate file left behind: %v", err)
+	}
+
+	// Without the annotations the companion is no longer wanted
+	stripped := strings.Replace(src, "// @gomethod: Since() time.Duration { return time.Since(x.Created) }\n// @goconstructor\n", "", 1)
+	if err := os.WriteFile(path, []byte(stripped), 0644); err != nil {
+		t.Fatal(err)
+	}
+	captureStdout(t, func() { p.run(path) })
+	if _, err := os.Stat(companion); !os.IsNotExist(err) {
+		t.Errorf("companion not removed: %v", err)
+	}
+
+	// A file of that name the tool didn't write is left alone
+	if err := os.WriteFile(companion, []byte("package pb\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+	p = newTestProcessor()
+	p.ExternalMethods = true
+	captureStdout(t, func() { p.run(path) })
+	if len(p.failures) != 1 || !strings.Contains(p.failures[0].Message, "wasn't written by protoc-go-inject") {
+		t.Errorf("failures = %+v", p.failures)
+	}
+	if data, err := os.ReadFile(companion); err != nil || string(data) != "package pb\n" {
+		t.Errorf("companion overwritten: %v\n%s", err, data)
+	}
+}
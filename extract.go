@@ -0,0 +1,173 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// generatedTagKeys are the tag keys protoc-gen-go writes, which extraction
+// leaves out
+var generatedTagKeys = map[string]bool{
+	"protobuf":       true,
+	"protobuf_key":   true,
+	"protobuf_val":   true,
+	"protobuf_oneof": true,
+}
+
+// isGeneratedField reports whether protoc-gen-go declared a field: the ones
+// with a protobuf tag, and its internal state fields
+func isGeneratedField(field *ast.Field) bool {
+	tags, _ := fieldTags(field)
+	for _, tag := range tags {
+		if tag.Key == "protobuf" || tag.Key == "protobuf_oneof" {
+			return true
+		}
+	}
+	return strings.HasPrefix(types.ExprString(field.Type), "protoimpl.")
+}
+
+// extractAnnotations writes the annotations that would reproduce the
+// hand-made changes to the generated messages of a file: fields without a
+// protobuf tag become @gofield (with the default of a "// default:"
+// comment), tags protoc-gen-go doesn't write (and json tags other than the
+// ones it writes) @gotags, and the packages added fields use @goimport.
+// Structs without protobuf tags aren't messages and are skipped.
+func (p *Processor) extractAnnotations(fpath string) (string, error) {
+	fset := token.NewFileSet()
+	astFile, err := parser.ParseFile(fset, fpath, nil, parser.ParseComments)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse file: %v", err)
+	}
+
+	var imports []string
+	var blocks []string
+	for _, decl := range astFile.Decls {
+		genDecl, ok := decl.(*ast.GenDecl)
+		if !ok || genDecl.Tok != token.TYPE {
+			continue
+		}
+		for _, spec := range genDecl.Specs {
+			typeSpec, ok := spec.(*ast.TypeSpec)
+			if !ok {
+				continue
+			}
+			structType, ok := typeSpec.Type.(*ast.StructType)
+			if !ok {
+				continue
+			}
+			if lines := p.extractStruct(astFile, structType, &imports); lines != nil {
+				blocks = append(blocks, "// "+p.Prefix+"type: "+typeSpec.Name.Name+"\n"+strings.Join(lines, ""))
+			}
+		}
+	}
+
+	var sb strings.Builder
+	for _, imp := range imports {
+		sb.WriteString("// " + p.Prefix + "import: " + imp + "\n")
+	}
+	for i, block := range blocks {
+		if i > 0 || len(imports) > 0 {
+			sb.WriteString("\n")
+		}
+		sb.WriteString(block)
+	}
+	return sb.String(), nil
+}
+
+// extractStruct returns the annotation lines for the hand-made changes to a
+// message, or nil when it isn't one. Imports of the packages added fields
+// use are collected in imports.
+func (p *Processor) extractStruct(astFile *ast.File, structType *ast.StructType, imports *[]string) []string {
+	isMessage := false
+	for _, field := range structType.Fields.List {
+		if isGeneratedField(field) {
+			isMessage = true
+		}
+	}
+	if !isMessage {
+		return nil
+	}
+
+	var lines []string
+	list := structType.Fields.List
+	for i, field := range list {
+		label := fieldLabel(field)
+		if label == "" {
+			continue
+		}
+
+		tags, _ := fieldTags(field)
+		if isGeneratedField(field) {
+			var added []tagPair
+			for _, tag := range tags {
+				if generatedTagKeys[tag.Key] {
+					continue
+				}
+				// protoc-gen-go names fields in JSON by their proto name
+				if tag.Key == "json" && len(field.Names) > 0 && tag.Value == protoFieldName(field)+",omitempty" {
+					continue
+				}
+				added = append(added, tag)
+			}
+			tags = added
+		} else {
+			// Fields after the last generated one are appended, others are
+			// placed next to their neighbour
+			placement := ""
+			for _, next := range list[i+1:] {
+				if isGeneratedField(next) {
+					placement = "[0]"
+					if i > 0 {
+						placement = "[after:" + fieldLabel(list[i-1]) + "]"
+					}
+					break
+				}
+			}
+			// @gofield takes one name, so fields declared together (A, B int)
+			// are injected one by one
+			typeStr := types.ExprString(field.Type)
+			if field.Comment != nil {
+				if text := field.Comment.List[0].Text; strings.HasPrefix(text, defaultCommentPrefix) {
+					typeStr += " = " + strings.TrimPrefix(text, defaultCommentPrefix)
+				}
+			}
+			if len(field.Names) == 0 {
+				lines = append(lines, "// "+p.Prefix+"field"+placement+": "+typeStr+"\n")
+			}
+			for _, ident := range field.Names {
+				lines = append(lines, "// "+p.Prefix+"field"+placement+": "+ident.Name+" "+typeStr+"\n")
+				if placement != "" {
+					placement = "[after:" + ident.Name + "]"
+				}
+			}
+
+			for _, pkg := range referencedPackages(field.Type) {
+				path := importedPath(astFile, pkg)
+				if path == "" || stdlibPackages[pkg] == path {
+					// Standard library packages are imported automatically
+					continue
+				}
+				imp := strconv.Quote(path)
+				if importName("", path) != pkg {
+					imp = pkg + " " + imp
+				}
+				if !slices.Contains(*imports, imp) {
+					*imports = append(*imports, imp)
+				}
+			}
+		}
+		if len(tags) > 0 {
+			lines = append(lines, "// "+p.Prefix+"tags("+label+"): "+formatTags(tags)+"\n")
+		}
+	}
+	if len(lines) == 0 {
+		return nil
+	}
+	return lines
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestExtractAnnotations(t *testing.T) {
+	src := "package pb\n\nimport (\n\tuuid \"github.com/google/uuid\"\n\t\"time\"\n)\n\n" +
+		"type User struct {\n" +
+		"\tstate protoimpl.MessageState\n\n" +
+		"\tTenant uuid.UUID\n" +
+		"\tId   int64  `protobuf:\"varint,1,opt,name=id,proto3\" json:\"id,omitempty\" gorm:\"primaryKey\"`\n" +
+		"\tName string `protobuf:\"bytes,2,opt,name=name,proto3\" json:\"name,omitempty\"`\n" +
+		"\tA, B      int\n" +
+		"\tCreatedAt time.Time `json:\"created_at\"`\n" +
+		"}\n\n" +
+		"// Config isn't a message\ntype Config struct {\n\tPath string `json:\"path\"`\n}\n"
+	path := filepath.Join(t.TempDir(), "test.pb.go")
+	if err := os.WriteFile(path, []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := newTestProcessor().extractAnnotations(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "// @goimport: \"github.com/google/uuid\"\n\n" +
+		"// @gotype: User\n" +
+		"// @gofield[after:state]: Tenant uuid.UUID\n" +
+		"// @gotags(Id): gorm:\"primaryKey\"\n" +
+		"// @gofield: A int\n" +
+		"// @gofield: B int\n" +
+		"// @gofield: CreatedAt time.Time\n" +
+		"// @gotags(CreatedAt): json:\"created_at\"\n"
+	if got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestExtractRoundTrip(t *testing.T) {
+	generated := "type User struct {\n" +
+		"\tId   int64  `protobuf:\"varint,1,opt,name=id,proto3\" json:\"id,omitempty\"`\n" +
+		"\tName string `protobuf:\"bytes,2,opt,name=name,proto3\" json:\"name,omitempty\"`\n" +
+		"}\n"
+	edited := "package pb\n\ntype User struct {\n" +
+		"\tId       int64  `protobuf:\"varint,1,opt,name=id,proto3\" json:\"id,omitempty\" gorm:\"primaryKey\"`\n" +
+		"\tInternal string `json:\"-\"`\n" +
+		"\tName     string `protobuf:\"bytes,2,opt,name=name,proto3\" json:\"name\"`\n" +
+		"\tAge      int\n" +
+		"}\n"
+	path := filepath.Join(t.TempDir(), "test.pb.go")
+	if err := os.WriteFile(path, []byte(edited), 0644); err != nil {
+		t.Fatal(err)
+	}
+	annotations, err := newTestProcessor().extractAnnotations(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The annotations applied to the regenerated file give back the edits
+	out := process(t, "package pb\n\n"+annotations+"\n"+generated)
+	want := edited[strings.Index(edited, "type User"):]
+	if got := structDecl(out, "User") + "\n"; got != want {
+		t.Errorf("got:\n%s\nwant:\n%s\nfrom annotations:\n%s", got, want, annotations)
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"strings"
+)
+
+// gatewayTags gives a field of a @gogateway struct the names grpc-gateway
+// and gorm expect, derived from its proto name: a lowerCamelCase json name
+// (user_id -> userId) and a snake_case gorm column. The json name
+// protoc-gen-go writes, the proto name, is replaced, keeping its options
+// (,omitempty); any other json name was set on purpose and is kept, as is
+// an existing gorm tag. It reports whether the tag changed.
+func (p *Processor) gatewayTags(inputPath, name string, line int, field *ast.Field) bool {
+	protoName := protoFieldName(field)
+	jsonName := toLowerCamelCase(protoName)
+
+	changed := false
+	tags, _ := fieldTags(field)
+	jsonTag := fmt.Sprintf(`json:"%s"`, jsonName)
+	replace := false
+	for _, tag := range tags {
+		if tag.Key != "json" {
+			continue
+		}
+		current, options, hasOptions := strings.Cut(tag.Value, ",")
+		if current == protoName && current != jsonName {
+			replace = true
+			if hasOptions {
+				jsonTag = fmt.Sprintf(`json:"%s,%s"`, jsonName, options)
+			}
+		}
+	}
+	if p.applyTags(inputPath, name, line, field, jsonTag, replace) {
+		changed = true
+	}
+
+	gormTag := fmt.Sprintf(`gorm:"column:%s"`, toSnakeCase(protoName))
+	if p.applyTags(inputPath, name, line, field, gormTag, false) {
+		changed = true
+	}
+	return changed
+}
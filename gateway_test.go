@@ -0,0 +1,39 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestGateway(t *testing.T) {
+	src := "package pb\n\n// @gotype: User\n// @gogateway\n// @gotags(Nickname): json:\"nick\"\n\n" +
+		"type User struct {\n" +
+		"\tstate int\n" +
+		"\tUserId    int64  `protobuf:\"varint,1,opt,name=user_id,proto3\" json:\"user_id,omitempty\"`\n" +
+		"\tFirstName string `protobuf:\"bytes,2,opt,name=first_name,proto3\" json:\"first_name\"`\n" +
+		"\tEmail     string `protobuf:\"bytes,3,opt,name=email,proto3\" json:\"mail,omitempty\" gorm:\"uniqueIndex\"`\n" +
+		"\tNickname  string `protobuf:\"bytes,4,opt,name=nickname,proto3\" json:\"nickname,omitempty\"`\n" +
+		"}\n"
+	got := structDecl(process(t, src), "User")
+	for _, want := range []string{
+		// Unexported fields are left alone
+		"\tstate     int\n",
+		// The generated json name is replaced, keeping its options
+		"json:\"userId,omitempty\" gorm:\"column:user_id\"`",
+		"json:\"firstName\" gorm:\"column:first_name\"`",
+		// Json names set on purpose and existing gorm tags are kept
+		"json:\"mail,omitempty\" gorm:\"uniqueIndex\"`",
+		// @gotags still overrides
+		"json:\"nick\" gorm:\"column:nickname\"`",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("missing %q in:\n%s", want, got)
+		}
+	}
+
+	// The directive form works too
+	got = structDecl(process(t, strings.Replace(src, "// @gogateway", "//go:inject-gateway", 1)), "User")
+	if !strings.Contains(got, "json:\"userId,omitempty\" gorm:\"column:user_id\"`") {
+		t.Errorf("directive not applied:\n%s", got)
+	}
+}
@@ -0,0 +1,22 @@
+package main
+
+import (
+	"fmt"
+
+	"golang.org/x/tools/imports"
+)
+
+// runGoimports formats a processed file like goimports, which also adds
+// imports the file is missing, drops unused ones and groups them. Imports
+// are resolved as for a file in the directory of the input.
+func runGoimports(inputPath string, src []byte) ([]byte, error) {
+	out, err := imports.Process(inputPath, src, &imports.Options{
+		Comments:  true,
+		TabIndent: true,
+		TabWidth:  8,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("goimports failed: %v", err)
+	}
+	return out, nil
+}
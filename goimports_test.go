@@ -0,0 +1,53 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestGoImports(t *testing.T) {
+	src := `package pb
+
+import (
+	"os"
+	"strings"
+)
+
+// @gotype: User
+// @gofield: Created time.Time
+// @gomethod: Title() string { return strings.ToTitle(fmt.Sprint(x.Created)) }
+
+type User struct {
+	Name string
+}
+`
+	path := filepath.Join(t.TempDir(), "test.pb.go")
+	if err := os.WriteFile(path, []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+	p := newTestProcessor()
+	p.GoImports = true
+	captureStdout(t, func() { p.run(path) })
+	if len(p.failures) > 0 {
+		t.Fatalf("processing failed: %s", p.failures[0].Message)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := string(data)
+	// os is dropped, fmt added although nothing annotated imports it, and
+	// the imports are sorted
+	want := "import (\n\t\"fmt\"\n\t\"strings\"\n\t\"time\"\n)\n"
+	if !strings.Contains(out, want) {
+		t.Errorf("imports\n%s\nnot found in:\n%s", want, out)
+	}
+}
+
+func TestGoImportsInvalid(t *testing.T) {
+	if _, err := runGoimports("test.go", []byte("package pb\n\nfunc {\n")); err == nil {
+		t.Error("expected an error for invalid source")
+	}
+}
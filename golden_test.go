@@ -0,0 +1,64 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// goldenTests are the fixtures in testdata: each file is processed with the
+// options setup sets and compared with the .golden file of the same base
+// name, then processed again, which must change nothing
+var goldenTests = []struct {
+	file  string
+	setup func(p *Processor)
+}{
+	{"directives.pb.go", nil},
+	{"align_tags.pb.go", func(p *Processor) { p.AlignTags = true }},
+	{"gogoproto.pb.go", nil},
+	{"user_grpc.pb.go", nil},
+	{"struct_tags.pb.go", nil},
+	{"oneof.pb.go", nil},
+	{"json_names.pb.go", nil},
+}
+
+func TestGolden(t *testing.T) {
+	for _, tt := range goldenTests {
+		name, _, _ := strings.Cut(tt.file, ".")
+		t.Run(name, func(t *testing.T) {
+			src, err := os.ReadFile(filepath.Join("testdata", tt.file))
+			if err != nil {
+				t.Fatal(err)
+			}
+			run := func(src string) string {
+				p := newTestProcessor()
+				if tt.setup != nil {
+					tt.setup(p)
+				}
+				return processSource(t, p, tt.file, src)
+			}
+			got := run(string(src))
+
+			golden := filepath.Join("testdata", name+".golden")
+			if *update {
+				if err := os.WriteFile(golden, []byte(got), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != string(want) {
+				t.Errorf("output differs from %s:\n%s", golden, got)
+			}
+			if again := run(got); again != got {
+				t.Errorf("processing the output again changed it:\n%s", again)
+			}
+		})
+	}
+}
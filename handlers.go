@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// AnnotationHandler processes a custom annotation, for project-specific
+// injections the built-in annotations don't cover. A handler with type acl
+// handles @goacl comments (or @<prefix>acl with --prefix), written like the
+// built-in ones:
+//
+//	// @goacl: admin
+//	// @goacl(Owner): read-only
+//	// @goacl[sql]: admin
+//
+// Handlers are compiled into the tool: add a file to this package that
+// appends them to extraHandlers from an init function. The tool is a main
+// package, so this isn't an API other modules can import, and the built-in
+// annotations are applied by the Processor itself rather than as handlers.
+type AnnotationHandler interface {
+	// Type is the annotation's name without the prefix, e.g. acl
+	Type() string
+
+	// Apply makes the annotation's changes to the parsed file. It is called
+	// once per annotation, in file order, after the built-in annotations
+	// have been applied.
+	Apply(ctx *AnnotationContext, astFile *ast.File) error
+}
+
+// AnnotationContext describes a custom annotation to its handler
+type AnnotationContext struct {
+	File     string         // Path of the file being processed
+	Fset     *token.FileSet // File set of astFile, for positions of added nodes
+	Line     int            // Line of the annotation
+	TypeName string         // Type the annotation is in, "" outside of any
+	Target   string         // Name given in parentheses, "" if none
+	Content  string         // Text after the colon, "" if none
+}
+
+// handlerTypeRe matches the types handlers may have
+var handlerTypeRe = regexp.MustCompile(`^[a-z]\w*$`)
+
+// customAnnotationRe matches a custom annotation after the prefix, giving
+// its type, condition, target and content
+var customAnnotationRe = regexp.MustCompile(`^([a-z]\w*)(?:\[([A-Za-z_]\w*)\])?(?:\((.*?)\))?(?::[ \t]*(.*)|\s|$)`)
+
+// extraHandlers are registered with the Processor main creates
+var extraHandlers []AnnotationHandler
+
+// RegisterHandler adds a handler for a custom annotation type. Types must be
+// lowercase identifiers, and can't replace a built-in annotation or another
+// handler.
+func (p *Processor) RegisterHandler(h AnnotationHandler) error {
+	name := h.Type()
+	if !handlerTypeRe.MatchString(name) {
+		return fmt.Errorf("invalid annotation type %q", name)
+	}
+	if slices.ContainsFunc(SupportedAnnotations, func(spec AnnotationSpec) bool { return spec.Name == "go"+name }) {
+		return fmt.Errorf("@go%s is a built-in annotation", name)
+	}
+	if p.handlers[name] != nil {
+		return fmt.Errorf("@go%s already has a handler", name)
+	}
+	if p.handlers == nil {
+		p.handlers = make(map[string]AnnotationHandler)
+	}
+	p.handlers[name] = h
+	return nil
+}
+
+// customAnnotation is a custom annotation found in a file, applied once the
+// built-in annotations are
+type customAnnotation struct {
+	Handler AnnotationHandler
+	Context AnnotationContext
+}
+
+// parseCustomAnnotations extracts the annotations of registered handlers
+// from a comment. Their Type is the handler's type with a go prefix, like
+// the built-in ones.
+func (p *Processor) parseCustomAnnotations(comment string) []Annotation {
+	var annotations []Annotation
+	seen := make(map[string]bool)
+	for i := 0; i < len(comment); {
+		j := strings.Index(comment[i:], p.Prefix)
+		if j < 0 {
+			break
+		}
+		i += j + 1
+		match := customAnnotationRe.FindStringSubmatch(comment[i-1+len(p.Prefix):])
+		if match == nil || p.handlers[match[1]] == nil || seen[match[1]] {
+			continue
+		}
+		seen[match[1]] = true
+		annotations = append(annotations, Annotation{
+			Type:      "go" + match[1],
+			Content:   strings.TrimSpace(stripTrailingComment(match[4])),
+			Target:    strings.TrimSpace(match[3]),
+			Condition: match[2],
+		})
+	}
+	slices.SortFunc(annotations, func(a, b Annotation) int { return strings.Compare(a.Type, b.Type) })
+	return annotations
+}
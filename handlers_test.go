@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"strings"
+	"testing"
+)
+
+// aclHandler tags the targeted field of a struct with acl:"<content>"
+type aclHandler struct {
+	seen []string
+}
+
+func (h *aclHandler) Type() string { return "acl" }
+
+func (h *aclHandler) Apply(ctx *AnnotationContext, astFile *ast.File) error {
+	h.seen = append(h.seen, fmt.Sprintf("%d %s(%s): %s", ctx.Line, ctx.TypeName, ctx.Target, ctx.Content))
+	if ctx.Content == "fail" {
+		return fmt.Errorf("refused")
+	}
+	for _, decl := range astFile.Decls {
+		ast.Inspect(decl, func(n ast.Node) bool {
+			typeSpec, ok := n.(*ast.TypeSpec)
+			if !ok || typeSpec.Name.Name != ctx.TypeName {
+				return true
+			}
+			structType := typeSpec.Type.(*ast.StructType)
+			if i := findField(structType, ctx.Target, false); i >= 0 {
+				field := structType.Fields.List[i]
+				tags, remainder := fieldTags(field)
+				setFieldTag(field, append(tags, tagPair{"acl", ctx.Content}), remainder)
+			}
+			return false
+		})
+	}
+	return nil
+}
+
+func TestRegisterHandler(t *testing.T) {
+	p := newTestProcessor()
+	if err := p.RegisterHandler(&aclHandler{}); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		handler AnnotationHandler
+		err     string
+	}{
+		{&aclHandler{}, "@goacl already has a handler"},
+		{namedHandler("tags"), "@gotags is a built-in annotation"},
+		{namedHandler("Acl"), `invalid annotation type "Acl"`},
+		{namedHandler("a-b"), `invalid annotation type "a-b"`},
+	}
+	for _, tt := range tests {
+		if err := p.RegisterHandler(tt.handler); err == nil || err.Error() != tt.err {
+			t.Errorf("RegisterHandler(%s) = %v, want %q", tt.handler.Type(), err, tt.err)
+		}
+	}
+}
+
+// namedHandler is a handler that does nothing, of the given type
+type namedHandler string
+
+func (h namedHandler) Type() string { return string(h) }
+
+func (h namedHandler) Apply(*AnnotationContext, *ast.File) error { return nil }
+
+func TestParseCustomAnnotations(t *testing.T) {
+	p := newTestProcessor()
+	p.Prefix = "@inject_"
+	if err := p.RegisterHandler(&aclHandler{}); err != nil {
+		t.Fatal(err)
+	}
+	got := p.parseCustomAnnotations(`// @inject_acl[sql](Owner): admin // note @inject_tags: json:"x"`)
+	want := []Annotation{{Type: "goacl", Content: "admin", Target: "Owner", Condition: "sql"}}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+	if got := p.parseCustomAnnotations("// @inject_aclx: admin @goacl: admin"); len(got) != 0 {
+		t.Errorf("matched other names: %+v", got)
+	}
+}
+
+func TestCustomAnnotations(t *testing.T) {
+	src := `package pb
+
+// @gotype: User
+// @gofield: Owner string
+// @goacl(Owner): admin
+// @goacl[never](Name): skipped
+
+type User struct {
+	Name string // @goacl(Name): read
+}
+`
+	h := &aclHandler{}
+	p := newTestProcessor()
+	if err := p.RegisterHandler(h); err != nil {
+		t.Fatal(err)
+	}
+	out := processSource(t, p, "test.pb.go", src)
+
+	// The handler runs after the built-in annotations, so it finds the
+	// injected field
+	want := "type User struct {\n\tName  string `acl:\"read\"` // @goacl(Name): read\n\tOwner string `acl:\"admin\"`\n}"
+	if got := structDecl(out, "User"); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+	if got := strings.Join(h.seen, "; "); got != "5 User(Owner): admin; 9 User(Name): read" {
+		t.Errorf("handler calls: %s", got)
+	}
+}
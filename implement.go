@@ -0,0 +1,306 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// interfaceStubs holds what @goimplement injects for an interface: a stub for
+// each of its methods, and the path of the interface's package when known,
+// for a compile-time assertion
+type interfaceStubs struct {
+	Name    string   // Interface as written in Go, e.g. io.Closer
+	Path    string   // Import path of the interface's package, "" if unknown
+	Methods []string // Method signatures, e.g. Close() (_ error)
+	Imports []string // Import paths the signatures need
+}
+
+// resolveInterface finds the methods of the interface named by an
+// @goimplement annotation: "Name", "pkg.Name" or "path/to/pkg.Name",
+// optionally followed by the methods in braces, which is needed when the
+// interface can't be loaded, e.g. { Get(id string) (*User, error) }.
+// Interfaces declared in the file are read from it; others are type-checked
+// from source.
+func resolveInterface(inputPath string, astFile *ast.File, content string) (*interfaceStubs, error) {
+	name, methodList, hasList := strings.Cut(content, "{")
+	name = strings.TrimSpace(name)
+	stubs := &interfaceStubs{Name: name}
+
+	pkgName, typeName := "", name
+	if i := strings.LastIndex(name, "."); i >= 0 {
+		pkgName, typeName = name[:i], name[i+1:]
+		if strings.Contains(pkgName, "/") {
+			// A full import path, written in Go by its last element
+			stubs.Path = pkgName
+			pkgName = pkgName[strings.LastIndex(pkgName, "/")+1:]
+			stubs.Name = pkgName + "." + typeName
+		} else {
+			stubs.Path = importedPath(astFile, pkgName)
+			if stubs.Path == "" {
+				stubs.Path = stdlibPackages[pkgName]
+			}
+		}
+	}
+	if !token.IsIdentifier(typeName) || (pkgName != "" && !token.IsIdentifier(pkgName)) {
+		return nil, fmt.Errorf("%q is not an interface name", name)
+	}
+
+	var iface *ast.InterfaceType
+	switch {
+	case hasList:
+		expr, err := parser.ParseExpr("interface{" + methodList)
+		if err != nil {
+			return nil, fmt.Errorf("invalid method list: %v", err)
+		}
+		var ok bool
+		if iface, ok = expr.(*ast.InterfaceType); !ok {
+			return nil, fmt.Errorf("invalid method list")
+		}
+	case pkgName == "":
+		iface = localInterface(astFile, typeName)
+		if iface == nil {
+			return nil, fmt.Errorf("no interface %s in this file", typeName)
+		}
+	default:
+		if stubs.Path == "" {
+			return nil, fmt.Errorf("can't tell which package %s is, import it or list the methods", pkgName)
+		}
+		if err := stubs.loadMethods(inputPath, stubs.Path, typeName); err != nil {
+			return nil, err
+		}
+		return stubs, nil
+	}
+
+	if err := stubs.addMethods(inputPath, astFile, iface, map[string]bool{typeName: true}); err != nil {
+		return nil, err
+	}
+	return stubs, nil
+}
+
+// addMethods adds the methods of an interface written in the file, following
+// embedded interfaces into the file, the packages it imports and the
+// predeclared error. An embedded interface that can't be found is an error,
+// since stubs for only some of the methods wouldn't implement it. seen holds
+// the local interfaces already followed.
+func (s *interfaceStubs) addMethods(inputPath string, astFile *ast.File, iface *ast.InterfaceType, seen map[string]bool) error {
+	for _, method := range iface.Methods.List {
+		if funcType, ok := method.Type.(*ast.FuncType); ok && len(method.Names) > 0 {
+			var params, results []string
+			for _, field := range funcType.Params.List {
+				for range max(1, len(field.Names)) {
+					params = append(params, types.ExprString(field.Type))
+				}
+			}
+			if funcType.Results != nil {
+				for _, field := range funcType.Results.List {
+					for range max(1, len(field.Names)) {
+						results = append(results, types.ExprString(field.Type))
+					}
+				}
+			}
+			s.addStub(method.Names[0].Name, params, results)
+			continue
+		}
+
+		embedded := types.ExprString(method.Type)
+		switch typ := method.Type.(type) {
+		case *ast.Ident:
+			if local := localInterface(astFile, typ.Name); local != nil {
+				if seen[typ.Name] {
+					return fmt.Errorf("interface %s embeds itself", typ.Name)
+				}
+				seen[typ.Name] = true
+				if err := s.addMethods(inputPath, astFile, local, seen); err != nil {
+					return err
+				}
+				continue
+			}
+			if obj := types.Universe.Lookup(typ.Name); obj != nil {
+				if universal, ok := obj.Type().Underlying().(*types.Interface); ok && universal.IsMethodSet() {
+					s.addTypesMethods(universal)
+					continue
+				}
+			}
+		case *ast.SelectorExpr:
+			if pkg, ok := typ.X.(*ast.Ident); ok {
+				path := importedPath(astFile, pkg.Name)
+				if path == "" {
+					path = stdlibPackages[pkg.Name]
+				}
+				if path != "" {
+					if err := s.loadMethods(inputPath, path, typ.Sel.Name); err != nil {
+						return fmt.Errorf("embedded %s: %v", embedded, err)
+					}
+					continue
+				}
+			}
+		}
+		return fmt.Errorf("can't find the methods of embedded %s, list them instead", embedded)
+	}
+	return nil
+}
+
+// loadMethods type-checks the package at path from source and adds the
+// methods of its interface typeName, including embedded ones
+func (s *interfaceStubs) loadMethods(inputPath, path, typeName string) error {
+	fset := token.NewFileSet()
+	imp := importer.ForCompiler(fset, "source", nil).(types.ImporterFrom)
+	pkg, err := imp.ImportFrom(path, filepath.Dir(inputPath), 0)
+	if err != nil {
+		return fmt.Errorf("can't load %s, list the methods instead: %v", path, err)
+	}
+	obj := pkg.Scope().Lookup(typeName)
+	if obj == nil {
+		return fmt.Errorf("no %s in %s", typeName, path)
+	}
+	iface, ok := obj.Type().Underlying().(*types.Interface)
+	if !ok {
+		return fmt.Errorf("%s.%s is not an interface", pkg.Name(), typeName)
+	}
+	s.addTypesMethods(iface)
+	return nil
+}
+
+// addTypesMethods adds the methods of a type-checked interface, qualifying
+// types by package name and importing their packages
+func (s *interfaceStubs) addTypesMethods(iface *types.Interface) {
+	qualifier := func(p *types.Package) string {
+		if !slices.Contains(s.Imports, p.Path()) {
+			s.Imports = append(s.Imports, p.Path())
+		}
+		return p.Name()
+	}
+	for i := 0; i < iface.NumMethods(); i++ {
+		method := iface.Method(i)
+		sig := method.Type().(*types.Signature)
+		var params, results []string
+		for j := 0; j < sig.Params().Len(); j++ {
+			paramType := sig.Params().At(j).Type()
+			if sig.Variadic() && j == sig.Params().Len()-1 {
+				params = append(params, "..."+types.TypeString(paramType.(*types.Slice).Elem(), qualifier))
+			} else {
+				params = append(params, types.TypeString(paramType, qualifier))
+			}
+		}
+		for j := 0; j < sig.Results().Len(); j++ {
+			results = append(results, types.TypeString(sig.Results().At(j).Type(), qualifier))
+		}
+		s.addStub(method.Name(), params, results)
+	}
+}
+
+// addStub adds a stub method unless the interface already has one of that
+// name, as happens when embedded interfaces overlap
+func (s *interfaceStubs) addStub(name string, params, results []string) {
+	for _, existing := range s.Methods {
+		if strings.HasPrefix(existing, name+"(") {
+			return
+		}
+	}
+	s.Methods = append(s.Methods, stubSignature(name, params, results))
+}
+
+// stubSignature writes a stub method that returns the zero values of its
+// results. Parameters are left unnamed so they can't clash with the
+// receiver, and results are named _ so a bare return works for any type.
+func stubSignature(name string, params, results []string) string {
+	sig := name + "(" + strings.Join(params, ", ") + ")"
+	if len(results) > 0 {
+		named := make([]string, len(results))
+		for i, result := range results {
+			named[i] = "_ " + result
+		}
+		sig += " (" + strings.Join(named, ", ") + ")"
+	}
+	if len(results) > 0 {
+		return sig + " { return }"
+	}
+	return sig + " {}"
+}
+
+// localInterface returns the interface type declared in the file under
+// name, or nil
+func localInterface(astFile *ast.File, name string) *ast.InterfaceType {
+	for _, decl := range astFile.Decls {
+		genDecl, ok := decl.(*ast.GenDecl)
+		if !ok || genDecl.Tok != token.TYPE {
+			continue
+		}
+		for _, spec := range genDecl.Specs {
+			if typeSpec, ok := spec.(*ast.TypeSpec); ok && typeSpec.Name.Name == name {
+				iface, _ := typeSpec.Type.(*ast.InterfaceType)
+				return iface
+			}
+		}
+	}
+	return nil
+}
+
+// importedPath returns the path of the package the file imports under
+// name, or ""
+func importedPath(astFile *ast.File, name string) string {
+	for _, impSpec := range astFile.Imports {
+		path, err := strconv.Unquote(impSpec.Path.Value)
+		if err != nil {
+			continue
+		}
+		if (impSpec.Name != nil && impSpec.Name.Name == name) ||
+			(impSpec.Name == nil && path[strings.LastIndex(path, "/")+1:] == name) {
+			return path
+		}
+	}
+	return ""
+}
+
+// createAssertion parses a compile-time check that the pointer type recv
+// implements iface, as a file of its own in fset
+func createAssertion(fset *token.FileSet, recv, iface string) (*ast.GenDecl, error) {
+	src := fmt.Sprintf("package p\n\nvar _ %s = (%s)(nil)\n", iface, recv)
+	file, err := parser.ParseFile(fset, "", src, 0)
+	if err != nil {
+		return nil, err
+	}
+	return file.Decls[0].(*ast.GenDecl), nil
+}
+
+// hasDecl reports whether the file already has a declaration that prints
+// the same as decl, such as an assertion added by an earlier run
+func hasDecl(astFile *ast.File, decl *ast.GenDecl) bool {
+	want := declString(decl)
+	for _, existing := range astFile.Decls {
+		if genDecl, ok := existing.(*ast.GenDecl); ok && genDecl.Tok == decl.Tok && declString(genDecl) == want {
+			return true
+		}
+	}
+	return false
+}
+
+// declString renders the specs of a var declaration for comparison
+func declString(decl *ast.GenDecl) string {
+	var sb strings.Builder
+	for _, spec := range decl.Specs {
+		valueSpec, ok := spec.(*ast.ValueSpec)
+		if !ok {
+			continue
+		}
+		for _, name := range valueSpec.Names {
+			sb.WriteString(name.Name + " ")
+		}
+		if valueSpec.Type != nil {
+			sb.WriteString(types.ExprString(valueSpec.Type))
+		}
+		for _, value := range valueSpec.Values {
+			sb.WriteString(" = " + types.ExprString(value))
+		}
+		sb.WriteString(";")
+	}
+	return sb.String()
+}
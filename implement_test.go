@@ -0,0 +1,142 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestImplementLocalInterface(t *testing.T) {
+	src := `package pb
+
+type Getter interface {
+	Get(id string) (*User, error)
+	Name() string
+}
+
+// @goimplement: Getter
+type User struct {
+	Id string
+}
+
+func (x *User) Name() string { return x.Id }
+`
+	out := process(t, src)
+	for _, want := range []string{
+		"var _ Getter = (*User)(nil)",
+		"func (x *User) Get(string) (_ *User, _ error) { return }",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("missing %q:\n%s", want, out)
+		}
+	}
+	if strings.Count(out, ") Name()") != 1 {
+		t.Errorf("existing method stubbed again:\n%s", out)
+	}
+}
+
+func TestImplementEmbeddedInterfaces(t *testing.T) {
+	src := `package pb
+
+import "io"
+
+type RW interface {
+	io.Reader
+	Close() error
+}
+
+type Failer interface {
+	RW
+	error
+	io.Closer
+}
+
+// @goimplement: Failer
+type User struct {
+	Id string
+}
+`
+	out := process(t, src)
+	for _, want := range []string{
+		"var _ Failer = (*User)(nil)",
+		"func (x *User) Read([]byte) (_ int, _ error) { return }",
+		"func (x *User) Close() (_ error) { return }",
+		"func (x *User) Error() (_ string) { return }",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("missing %q:\n%s", want, out)
+		}
+	}
+	if strings.Count(out, ") Close()") != 1 {
+		t.Errorf("Close stubbed more than once:\n%s", out)
+	}
+}
+
+func TestImplementStdlibInterface(t *testing.T) {
+	src := "package pb\n\n// @goimplement: io.Closer\ntype User struct {\n\tId string\n}\n"
+	out := process(t, src)
+	for _, want := range []string{"\t\"io\"\n", "var _ io.Closer = (*User)(nil)", "func (x *User) Close() (_ error) { return }"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("missing %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestImplementMethodList(t *testing.T) {
+	src := "package pb\n\n// @goimplement: store.Getter { Get(id string) (*User, error) }\ntype User struct {\n\tId string\n}\n"
+	out := process(t, src)
+	if !strings.Contains(out, "func (x *User) Get(string) (_ *User, _ error) { return }") {
+		t.Errorf("stub missing:\n%s", out)
+	}
+	// The package isn't known, so it can't be checked
+	if strings.Contains(out, "var _") {
+		t.Errorf("assertion added for an unknown package:\n%s", out)
+	}
+}
+
+func TestImplementGenericType(t *testing.T) {
+	src := "package pb\n\n// @goimplement: io.Closer\ntype Box[T any] struct {\n\tV T\n}\n"
+	out := process(t, src)
+	if !strings.Contains(out, "func (x *Box[T]) Close() (_ error) { return }") {
+		t.Errorf("stub missing:\n%s", out)
+	}
+	if strings.Contains(out, "var _") {
+		t.Errorf("assertion added for a generic type:\n%s", out)
+	}
+}
+
+func TestImplementUnresolvedEmbedded(t *testing.T) {
+	src := `package pb
+
+type Store interface {
+	other.Getter
+	Close() error
+}
+
+// @goimplement: Store
+type User struct {
+	Id string
+}
+`
+	path := filepath.Join(t.TempDir(), "test.pb.go")
+	if err := os.WriteFile(path, []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+	p := newTestProcessor()
+	out := captureStdout(t, func() {
+		if _, err := p.processFile(path); err != nil {
+			t.Error(err)
+		}
+	})
+	if p.warnings != 1 || !strings.Contains(out, "can't find the methods of embedded other.Getter") {
+		t.Errorf("got %d warnings:\n%s", p.warnings, out)
+	}
+	data, err := os.ReadFile(path + p.Suffix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "var _") || strings.Contains(string(data), "Close() (_ error)") {
+		t.Errorf("partial implementation injected:\n%s", data)
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/token"
+	"go/types"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+// jsonMethods are the methods @gojson injects, by the name of the template
+// defining their body, with their signatures
+var jsonMethods = []struct {
+	Name      string
+	Signature string
+}{
+	{"MarshalJSON", "MarshalJSON() ([]byte, error)"},
+	{"UnmarshalJSON", "UnmarshalJSON(data []byte) error"},
+}
+
+// jsonTemplateData is what @gojson templates are executed with
+type jsonTemplateData struct {
+	Type     string              // Name of the type, e.g. User
+	Receiver string              // Type of the receiver x, e.g. *User
+	Fields   []jsonTemplateField // Fields encoding/json would encode, in order
+}
+
+// jsonTemplateField describes a struct field to @gojson templates
+type jsonTemplateField struct {
+	Name      string // Go name, e.g. CreatedAt
+	Type      string // Go type, e.g. *timestamppb.Timestamp
+	JSON      string // Name in JSON, from the json tag or the Go name
+	OmitEmpty bool   // Whether the json tag has omitempty
+}
+
+// loadJSONTemplate reads a @gojson template file, relative to the file
+// being processed, which must define the MarshalJSON and UnmarshalJSON
+// templates
+func loadJSONTemplate(inputPath, path string) (*template.Template, error) {
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(filepath.Dir(inputPath), path)
+	}
+	text, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	tmpl, err := template.New(filepath.Base(path)).Parse(string(text))
+	if err != nil {
+		return nil, err
+	}
+	for _, method := range jsonMethods {
+		if tmpl.Lookup(method.Name) == nil {
+			return nil, fmt.Errorf("%s doesn't define a %s template", path, method.Name)
+		}
+	}
+	return tmpl, nil
+}
+
+// jsonFields lists the fields of a struct encoding/json would encode: the
+// exported named fields not tagged json:"-"
+func jsonFields(structType *ast.StructType) []jsonTemplateField {
+	var fields []jsonTemplateField
+	for _, field := range structType.Fields.List {
+		var jsonTag string
+		if field.Tag != nil {
+			tags, _ := fieldTags(field)
+			for _, tag := range tags {
+				if tag.Key == "json" {
+					jsonTag = tag.Value
+				}
+			}
+		}
+		// Only a bare "-" skips the field, "-," names it "-"
+		if jsonTag == "-" {
+			continue
+		}
+		name, options, _ := strings.Cut(jsonTag, ",")
+		for _, ident := range field.Names {
+			if !ident.IsExported() {
+				continue
+			}
+			jsonName := name
+			if jsonName == "" {
+				jsonName = ident.Name
+			}
+			fields = append(fields, jsonTemplateField{
+				Name:      ident.Name,
+				Type:      types.ExprString(field.Type),
+				JSON:      jsonName,
+				OmitEmpty: strings.Contains(","+options+",", ",omitempty,"),
+			})
+		}
+	}
+	return fields
+}
+
+// createJSONMethods executes a @gojson template for a type and parses the
+// MarshalJSON and UnmarshalJSON methods it gives the bodies of. Unmarshaling
+// changes the value, so UnmarshalJSON always has a pointer receiver.
+func createJSONMethods(fset *token.FileSet, tmpl *template.Template, typeName, recv string, structType *ast.StructType) ([]*ast.FuncDecl, error) {
+	data := jsonTemplateData{Type: typeName, Receiver: recv}
+	if structType != nil {
+		data.Fields = jsonFields(structType)
+	}
+
+	var methods []*ast.FuncDecl
+	for _, method := range jsonMethods {
+		var body bytes.Buffer
+		if err := tmpl.ExecuteTemplate(&body, method.Name, data); err != nil {
+			return nil, err
+		}
+		methodRecv := recv
+		if method.Name == "UnmarshalJSON" && !strings.HasPrefix(recv, "*") {
+			methodRecv = "*" + recv
+		}
+		funcDecl, err := createMethod(fset, methodRecv, method.Signature+" {\n"+strings.TrimSpace(body.String())+"\n}")
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", method.Name, err)
+		}
+		methods = append(methods, funcDecl)
+	}
+	return methods, nil
+}
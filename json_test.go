@@ -0,0 +1,132 @@
+package main
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testJSONTemplate = `{{define "MarshalJSON"}}
+	m := map[string]any{}
+{{- range .Fields}}
+	m["{{.JSON}}"] = x.{{.Name}}
+{{- end}}
+	return json.Marshal(m)
+{{end}}
+{{define "UnmarshalJSON"}}
+	type plain {{.Type}}
+	return json.Unmarshal(data, (*plain)(x))
+{{end}}
+`
+
+// processWithJSONTemplate processes src next to the test template
+func processWithJSONTemplate(t *testing.T, src string) string {
+	t.Helper()
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "json.tmpl"), []byte(testJSONTemplate), 0644); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "test.pb.go")
+	if err := os.WriteFile(path, []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+	p := newTestProcessor()
+	var err error
+	captureStdout(t, func() { _, err = p.processFile(path) })
+	if err != nil {
+		t.Fatalf("processing failed: %v", err)
+	}
+	out, err := os.ReadFile(path + p.Suffix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(out)
+}
+
+func TestJSONMethods(t *testing.T) {
+	src := "package pb\n\n" +
+		"// @gojson: json.tmpl\n" +
+		"type User struct {\n" +
+		"\tId string `json:\"id,omitempty\"`\n" +
+		"\tName, Nick string\n" +
+		"\tSecret string `json:\"-\"`\n" +
+		"\tDash string `json:\"-,\"`\n" +
+		"\tstate int\n" +
+		"\tAge int // @gotags: json:\"age\"\n" +
+		"}\n"
+	out := processWithJSONTemplate(t, src)
+	for _, want := range []string{
+		"\t\"encoding/json\"\n",
+		"func (x *User) MarshalJSON() ([]byte, error) {",
+		"\tm[\"id\"] = x.Id\n",
+		"\tm[\"Name\"] = x.Name\n",
+		"\tm[\"Nick\"] = x.Nick\n",
+		"\tm[\"-\"] = x.Dash\n",
+		// Tags injected in the same run are seen by the template
+		"\tm[\"age\"] = x.Age\n",
+		"func (x *User) UnmarshalJSON(data []byte) error {\n\ttype plain User\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("missing %q:\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, "x.Secret") || strings.Contains(out, "x.state") {
+		t.Errorf("fields encoding/json skips were passed:\n%s", out)
+	}
+}
+
+func TestJSONFields(t *testing.T) {
+	src := "package pb\n\ntype User struct {\n" +
+		"\tCreated *timestamppb.Timestamp `json:\"created,omitempty\"`\n" +
+		"\tTags []string `json:\",omitempty\"`\n" +
+		"\tSkip int `json:\"-\"`\n" +
+		"}\n"
+	file, err := parser.ParseFile(token.NewFileSet(), "test.pb.go", src, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	structType := file.Decls[0].(*ast.GenDecl).Specs[0].(*ast.TypeSpec).Type.(*ast.StructType)
+	want := []jsonTemplateField{
+		{Name: "Created", Type: "*timestamppb.Timestamp", JSON: "created", OmitEmpty: true},
+		{Name: "Tags", Type: "[]string", JSON: "Tags", OmitEmpty: true},
+	}
+	if got := jsonFields(structType); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestJSONMethodReceivers(t *testing.T) {
+	src := "package pb\n\n" +
+		"// @gojson: json.tmpl\n" +
+		"type Box[K comparable, V any] struct {\n\tItems map[K]V\n}\n\n" +
+		"// @gojson: json.tmpl\n" +
+		"type Status int32\n"
+	out := processWithJSONTemplate(t, src)
+	for _, want := range []string{
+		"func (x *Box[K, V]) MarshalJSON() ([]byte, error) {",
+		"func (x *Box[K, V]) UnmarshalJSON(data []byte) error {",
+		"func (x Status) MarshalJSON() ([]byte, error) {",
+		"func (x *Status) UnmarshalJSON(data []byte) error {",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("missing %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestLoadJSONTemplateMissingMethod(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "json.tmpl")
+	if err := os.WriteFile(path, []byte(`{{define "MarshalJSON"}}return nil, nil{{end}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	_, err := loadJSONTemplate(filepath.Join(dir, "test.pb.go"), "json.tmpl")
+	if err == nil || !strings.Contains(err.Error(), "doesn't define a UnmarshalJSON template") {
+		t.Errorf("err = %v", err)
+	}
+}
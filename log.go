@@ -0,0 +1,231 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+)
+
+// logMu serializes output from parallel workers
+var logMu sync.Mutex
+
+// ANSI escape sequences used to color text output on terminals
+const (
+	colorRed    = "\x1b[31m"
+	colorYellow = "\x1b[33m"
+	colorGreen  = "\x1b[32m"
+	colorReset  = "\x1b[0m"
+)
+
+// isTerminal reports whether f is attached to a terminal
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// colorize wraps text in the given color when colored output is enabled
+func (p *Processor) colorize(color, text string) string {
+	if !p.Color {
+		return text
+	}
+	return color + text + colorReset
+}
+
+// fileStats counts the injections made into a single file
+type fileStats struct {
+	Imports int `json:"imports"`
+	Fields  int `json:"fields"`
+	Tags    int `json:"tags"`
+	Methods int `json:"methods"`
+
+	CleanedImports int `json:"cleaned_imports"` // Existing imports requoted or removed
+	Custom         int `json:"custom"`          // Custom annotations applied
+}
+
+// changed reports whether anything was injected or cleaned up
+func (s fileStats) changed() bool {
+	return s.Imports+s.Fields+s.Tags+s.Methods+s.CleanedImports+s.Custom > 0
+}
+
+// logEvent is a single processing event (start, change, skip, checked,
+// warning or error), printed as a text line or a JSON object depending on
+// --log-format
+type logEvent struct {
+	File    string     `json:"file"`
+	Line    int        `json:"line,omitempty"`
+	Status  string     `json:"status"`
+	Message string     `json:"message,omitempty"`
+	Counts  *fileStats `json:"counts,omitempty"`
+}
+
+// logEvent prints an event in the configured log format
+func (p *Processor) logEvent(ev logEvent) {
+	logMu.Lock()
+	defer logMu.Unlock()
+
+	switch ev.Status {
+	case "warning":
+		p.warnings++
+	case "error":
+		p.failures = append(p.failures, ev)
+	}
+
+	if p.LogFormat == "json" {
+		data, err := json.Marshal(ev)
+		if err != nil {
+			return
+		}
+		fmt.Println(string(data))
+		return
+	}
+
+	switch ev.Status {
+	case "start":
+		fmt.Printf("Processing %s...\n", ev.File)
+	case "change":
+		fmt.Println(p.colorize(colorGreen, "Successfully processed "+ev.File))
+	case "skip":
+		fmt.Printf("Successfully processed %s\n", ev.File)
+	case "checked":
+		fmt.Printf("Checked %s\n", ev.File)
+	case "warning":
+		if ev.Line > 0 {
+			// The file:line: prefix editors and quickfix lists understand
+			fmt.Println(p.colorize(colorYellow, fmt.Sprintf("%s:%d: warning: %s", ev.File, ev.Line, ev.Message)))
+		} else {
+			fmt.Println(p.colorize(colorYellow, fmt.Sprintf("Warning: %s: %s", ev.File, ev.Message)))
+		}
+	case "error":
+		// Errors are summarized at the end of the run
+		if p.Verbose {
+			fmt.Println(p.colorize(colorRed, fmt.Sprintf("Error processing %s: %s", ev.File, ev.Message)))
+		}
+	}
+}
+
+// printErrorSummary lists the errors of the run grouped by file
+func (p *Processor) printErrorSummary() {
+	logMu.Lock()
+	defer logMu.Unlock()
+
+	if len(p.failures) == 0 || p.LogFormat == "json" {
+		return
+	}
+
+	failures := append([]logEvent(nil), p.failures...)
+	sort.SliceStable(failures, func(i, j int) bool {
+		return failures[i].File < failures[j].File
+	})
+	fmt.Println()
+	fmt.Println(p.colorize(colorRed, fmt.Sprintf("Failed to process %d file(s):", len(failures))))
+	for _, ev := range failures {
+		fmt.Printf("  %s: %s\n", ev.File, ev.Message)
+	}
+}
+
+// printImportSummary lists the imports added during the run, with the number
+// of files each went into. It is only printed in verbose text mode.
+func (p *Processor) printImportSummary() {
+	logMu.Lock()
+	defer logMu.Unlock()
+
+	if len(p.imports) == 0 || !p.Verbose || p.LogFormat == "json" {
+		return
+	}
+
+	paths := make([]string, 0, len(p.imports))
+	for path := range p.imports {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	fmt.Printf("\nImports added:\n")
+	for _, path := range paths {
+		counts := make(map[string]int)
+		var aliases []string
+		for _, use := range p.imports[path] {
+			if counts[use.Alias] == 0 {
+				aliases = append(aliases, use.Alias)
+			}
+			counts[use.Alias]++
+		}
+		// Files finish in any order with -j, so aliases are sorted too
+		sort.Strings(aliases)
+		for _, alias := range aliases {
+			name := fmt.Sprintf("%q", path)
+			if alias != "" {
+				name = alias + " " + name
+			}
+			fmt.Printf("  %s in %d file(s)\n", name, counts[alias])
+		}
+	}
+}
+
+// warnf logs a warning about a file
+func (p *Processor) warnf(file, format string, args ...interface{}) {
+	p.warnAtf(file, 0, format, args...)
+}
+
+// warnAtf logs a warning about a line of a file, 0 for none
+func (p *Processor) warnAtf(file string, line int, format string, args ...interface{}) {
+	p.logEvent(logEvent{File: file, Line: line, Status: "warning", Message: fmt.Sprintf(format, args...)})
+}
+
+// errorf logs an error about a file
+func (p *Processor) errorf(file, format string, args ...interface{}) {
+	p.logEvent(logEvent{File: file, Status: "error", Message: fmt.Sprintf(format, args...)})
+}
+
+// debugDump prints the annotations collected from a file to stderr, showing
+// exactly what the scanner extracted and the field names tags target
+func (p *Processor) debugDump(file string, imports []importEntry, fields map[string][]fieldSpec, tags map[string]map[string][]tagSpec) {
+	logMu.Lock()
+	defer logMu.Unlock()
+
+	fmt.Fprintf(os.Stderr, "debug: %s\n", file)
+
+	for _, imp := range imports {
+		if imp.Alias != "" {
+			fmt.Fprintf(os.Stderr, "  import %s %q\n", imp.Alias, imp.Path)
+		} else {
+			fmt.Fprintf(os.Stderr, "  import %q\n", imp.Path)
+		}
+	}
+
+	structs := make(map[string]bool)
+	for name := range fields {
+		structs[name] = true
+	}
+	for name := range tags {
+		structs[name] = true
+	}
+	var names []string
+	for name := range structs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  struct %s\n", name)
+		for _, spec := range fields[name] {
+			if spec.Placement != "" {
+				fmt.Fprintf(os.Stderr, "    field[%s:%s] %s\n", spec.Placement, spec.Anchor, spec.Decl)
+			} else if spec.Index >= 0 {
+				fmt.Fprintf(os.Stderr, "    field[%d] %s\n", spec.Index, spec.Decl)
+			} else {
+				fmt.Fprintf(os.Stderr, "    field %s\n", spec.Decl)
+			}
+		}
+		var keys []string
+		for key := range tags[name] {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			for _, spec := range tags[name][key] {
+				fmt.Fprintf(os.Stderr, "    tags %s: %s\n", key, spec.Tags)
+			}
+		}
+	}
+}
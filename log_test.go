@@ -0,0 +1,314 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// captureStdout returns what f prints to standard output
+func captureStdout(t *testing.T, f func()) string {
+	t.Helper()
+	return capture(t, &os.Stdout, f)
+}
+
+// capture returns what f writes to *file, which is replaced by a pipe while
+// f runs
+func capture(t *testing.T, file **os.File, f func()) string {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := *file
+	*file = w
+	defer func() { *file = saved }()
+	done := make(chan string)
+	go func() {
+		data, _ := io.ReadAll(r)
+		done <- string(data)
+	}()
+	f()
+	w.Close()
+	return <-done
+}
+
+func TestLogEventJSON(t *testing.T) {
+	p := &Processor{LogFormat: "json"}
+	out := captureStdout(t, func() {
+		p.logEvent(logEvent{File: "a.pb.go", Status: "start"})
+		p.logEvent(logEvent{File: "a.pb.go", Status: "change", Counts: &fileStats{Fields: 2, Tags: 1}})
+		p.warnf("a.pb.go", "malformed tag %q", "x")
+	})
+	var events []logEvent
+	for _, line := range strings.Split(strings.TrimSpace(out), "\n") {
+		var ev logEvent
+		if err := json.Unmarshal([]byte(line), &ev); err != nil {
+			t.Fatalf("line %q isn't JSON: %v", line, err)
+		}
+		events = append(events, ev)
+	}
+	if len(events) != 3 {
+		t.Fatalf("got %d events, want 3:\n%s", len(events), out)
+	}
+	if ev := events[1]; ev.Status != "change" || ev.Counts == nil || *ev.Counts != (fileStats{Fields: 2, Tags: 1}) {
+		t.Errorf("change event = %+v", ev)
+	}
+	if ev := events[2]; ev.Status != "warning" || ev.Message != `malformed tag "x"` {
+		t.Errorf("warning event = %+v", ev)
+	}
+	// The start event has no counts and no message
+	if !strings.HasPrefix(out, `{"file":"a.pb.go","status":"start"}`+"\n") {
+		t.Errorf("start event = %q", strings.SplitN(out, "\n", 2)[0])
+	}
+}
+
+func TestLogEventText(t *testing.T) {
+	p := &Processor{LogFormat: "text", Verbose: true}
+	out := captureStdout(t, func() {
+		p.logEvent(logEvent{File: "a.pb.go", Status: "start"})
+		p.errorf("a.pb.go", "failed to parse file: %v", io.ErrUnexpectedEOF)
+	})
+	want := "Processing a.pb.go...\nError processing a.pb.go: failed to parse file: unexpected EOF\n"
+	if out != want {
+		t.Errorf("got %q, want %q", out, want)
+	}
+}
+
+func TestWarningLines(t *testing.T) {
+	src := "package pb\n\ntype User struct {\n\tName string\n}\n\n// @gotype: Missing\n"
+	path := filepath.Join(t.TempDir(), "test.pb.go")
+	if err := os.WriteFile(path, []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	p := newTestProcessor()
+	out := captureStdout(t, func() { p.processFile(path) })
+	if want := path + ":7: warning: no type matches @gotype Missing\n"; !strings.Contains(out, want) {
+		t.Errorf("got:\n%s\nwant a line containing %q", out, want)
+	}
+
+	p = newTestProcessor()
+	p.LogFormat = "json"
+	out = captureStdout(t, func() { p.processFile(path) })
+	var ev logEvent
+	if err := json.Unmarshal([]byte(strings.TrimSpace(out)), &ev); err != nil {
+		t.Fatalf("%q isn't a JSON event: %v", out, err)
+	}
+	if ev.Status != "warning" || ev.Line != 7 || ev.File != path {
+		t.Errorf("warning event = %+v", ev)
+	}
+}
+
+func TestLogEventColor(t *testing.T) {
+	p := &Processor{LogFormat: "text", Verbose: true, Color: true}
+	out := captureStdout(t, func() {
+		p.logEvent(logEvent{File: "a.pb.go", Status: "start"})
+		p.logEvent(logEvent{File: "a.pb.go", Status: "change"})
+		p.logEvent(logEvent{File: "b.pb.go", Status: "skip"})
+		p.warnf("a.pb.go", "unused")
+		p.errorf("c.pb.go", "failed")
+	})
+	want := "Processing a.pb.go...\n" +
+		colorGreen + "Successfully processed a.pb.go" + colorReset + "\n" +
+		"Successfully processed b.pb.go\n" +
+		colorYellow + "Warning: a.pb.go: unused" + colorReset + "\n" +
+		colorRed + "Error processing c.pb.go: failed" + colorReset + "\n"
+	if out != want {
+		t.Errorf("got %q, want %q", out, want)
+	}
+
+	// Colors are never written when disabled
+	p.Color = false
+	if got := p.colorize(colorRed, "x"); got != "x" {
+		t.Errorf("colorize = %q without color", got)
+	}
+}
+
+func TestFileStats(t *testing.T) {
+	src := "package pb\n\ntype User struct {\n" +
+		"\t// @goimport: \"time\"\n" +
+		"\t// @gofield: CreatedAt time.Time\n" +
+		"\tId string `protobuf:\"bytes,1,opt,name=id,proto3\"` // @gotags: json:\"id\"\n" +
+		"}\n"
+	path := filepath.Join(t.TempDir(), "test.pb.go")
+	if err := os.WriteFile(path, []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+	stats, err := newTestProcessor().processFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (fileStats{Imports: 1, Fields: 1, Tags: 1}); stats != want {
+		t.Errorf("stats = %+v, want %+v", stats, want)
+	}
+}
+
+func TestWarningCount(t *testing.T) {
+	dir := t.TempDir()
+	clean := filepath.Join(dir, "clean.pb.go")
+	unmatched := filepath.Join(dir, "unmatched.pb.go")
+	files := map[string]string{
+		clean:     "package pb\n\ntype User struct {\n\t// @gofield: Age int\n}\n",
+		unmatched: "package pb\n\n// @gotype: mypkg.Missing\n// @gofield: Age int\n\ntype User struct {\n}\n",
+	}
+	for path, src := range files {
+		if err := os.WriteFile(path, []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	p := newTestProcessor()
+	captureStdout(t, func() { p.run(clean) })
+	if p.warnings != 0 {
+		t.Errorf("clean file gave %d warnings", p.warnings)
+	}
+	out := captureStdout(t, func() { p.run(unmatched) })
+	if p.warnings != 1 {
+		t.Errorf("unmatched @gotype gave %d warnings, want 1:\n%s", p.warnings, out)
+	}
+}
+
+func TestDebugDump(t *testing.T) {
+	src := "package pb\n\n// @goimport: \"time\"\n\ntype User struct {\n" +
+		"\t// @gofield[0]: CreatedAt time.Time\n" +
+		"\t// @gofield: Age int\n" +
+		"\tUser_Id string `protobuf:\"bytes,1,opt,name=user_id,proto3\"` // @gotags: json:\"uid\"\n" +
+		"}\n"
+	p := newTestProcessor()
+	p.Debug = true
+	stderr := capture(t, &os.Stderr, func() { processSource(t, p, "test.pb.go", src) })
+	want := "  import \"time\"\n" +
+		"  struct User\n" +
+		"    field[0] CreatedAt time.Time\n" +
+		"    field Age int\n" +
+		"    tags user_id: json:\"uid\"\n"
+	if !strings.HasPrefix(stderr, "debug: ") || !strings.HasSuffix(stderr, want) {
+		t.Errorf("got:\n%s\nwant it to end with:\n%s", stderr, want)
+	}
+}
+
+func TestErrorSummary(t *testing.T) {
+	dir := t.TempDir()
+	good := filepath.Join(dir, "good.pb.go")
+	if err := os.WriteFile(good, []byte("package pb\n\ntype User struct{}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	bad := filepath.Join(dir, "bad.pb.go")
+	if err := os.WriteFile(bad, []byte("package pb\n\n// @gotype: User\ntype User struct {\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	missing := filepath.Join(dir, "a_missing.pb.go")
+
+	p := newTestProcessor()
+	p.LogFormat = "text"
+	out := captureStdout(t, func() {
+		for _, path := range []string{bad, good, missing} {
+			p.run(path)
+		}
+	})
+	// Errors only show up inline with -v
+	if strings.Contains(out, "Error processing") {
+		t.Errorf("inline error without -v:\n%s", out)
+	}
+	if len(p.failures) != 2 {
+		t.Fatalf("got %d failures, want 2", len(p.failures))
+	}
+
+	summary := captureStdout(t, p.printErrorSummary)
+	lines := strings.Split(strings.TrimSpace(summary), "\n")
+	if len(lines) != 3 || lines[0] != "Failed to process 2 file(s):" ||
+		!strings.HasPrefix(lines[1], "  "+missing+": ") || !strings.HasPrefix(lines[2], "  "+bad+": ") {
+		t.Errorf("summary isn't sorted by file:\n%s", summary)
+	}
+}
+
+func TestImportSummary(t *testing.T) {
+	dir := t.TempDir()
+	files := []string{filepath.Join(dir, "a.pb.go"), filepath.Join(dir, "b.pb.go"), filepath.Join(dir, "c.pb.go")}
+	imports := []string{`@goimport: "time"`, `@goimport: "time"`, `@goimport: stdtime "time"`}
+	for i, path := range files {
+		src := "package pb\n\n// " + imports[i] + "\n\ntype User struct {\n\tName string\n}\n"
+		if err := os.WriteFile(path, []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	p := newTestProcessor()
+	p.Verbose = true
+	out := captureStdout(t, func() {
+		for _, path := range files {
+			p.run(path)
+		}
+		p.printImportSummary()
+	})
+	want := files[2] + ":3: warning: import \"time\" added as alias stdtime, but as an unnamed import in " + files[0]
+	if !strings.Contains(out, want) {
+		t.Errorf("conflicting name not reported:\n%s", out)
+	}
+	if !strings.HasSuffix(out, "\nImports added:\n  \"time\" in 2 file(s)\n  stdtime \"time\" in 1 file(s)\n") {
+		t.Errorf("unexpected summary:\n%s", out)
+	}
+
+	// Nothing is listed without -v
+	p.Verbose = false
+	if out := captureStdout(t, p.printImportSummary); out != "" {
+		t.Errorf("summary printed without -v:\n%s", out)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	dir := t.TempDir()
+	good := filepath.Join(dir, "good.pb.go")
+	warned := filepath.Join(dir, "warned.pb.go")
+	files := map[string]string{
+		good:   "package pb\n\ntype User struct {\n\t// @gofield: Age int\n}\n",
+		warned: "package pb\n\ntype User struct {\n\tName string // @gotags: json:\"name\" yaml\n}\n",
+	}
+	for path, src := range files {
+		if err := os.WriteFile(path, []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	p := newTestProcessor()
+	p.Validate = true
+	out := captureStdout(t, func() {
+		p.run(good)
+		p.run(warned)
+	})
+	if !strings.Contains(out, "Checked "+good+"\n") {
+		t.Errorf("no checked event for %s:\n%s", good, out)
+	}
+	// Malformed tags are reported when validating
+	if p.warnings != 1 || !strings.Contains(out, `ignoring malformed @gotags content "yaml"`) {
+		t.Errorf("got %d warnings:\n%s", p.warnings, out)
+	}
+	for path, src := range files {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != src {
+			t.Errorf("%s modified:\n%s", path, data)
+		}
+		if _, err := os.Stat(path + p.Suffix); !os.IsNotExist(err) {
+			t.Errorf("%s written: %v", path+p.Suffix, err)
+		}
+	}
+}
+
+func TestImportSummarySorted(t *testing.T) {
+	// As recorded when files finish out of order with -j
+	p := newTestProcessor()
+	p.Verbose = true
+	p.imports = map[string][]importUse{
+		"time":         {{File: "c.pb.go", Alias: "stdtime"}, {File: "a.pb.go"}, {File: "b.pb.go", Alias: "stdtime"}},
+		"gorm.io/gorm": {{File: "b.pb.go"}},
+	}
+	want := "\nImports added:\n  \"gorm.io/gorm\" in 1 file(s)\n  \"time\" in 1 file(s)\n  stdtime \"time\" in 2 file(s)\n"
+	if out := captureStdout(t, p.printImportSummary); out != want {
+		t.Errorf("got:\n%s\nwant:\n%s", out, want)
+	}
+}
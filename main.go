@@ -2,357 +2,3068 @@ package main
 
 import (
 	"bufio"
+	"bytes"
+	"flag"
 	"fmt"
 	"go/ast"
 	"go/format"
 	"go/parser"
 	"go/token"
+	"go/types"
+	"maps"
 	"os"
 	"path/filepath"
+	"reflect"
 	"regexp"
+	"slices"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"text/template"
+	"unicode"
 )
 
 type Annotation struct {
-	Type    string // goimport, gofield, or gotags
-	Content string
+	Type      string // goimport, gofield, goprimarykey, goreplacefield, gotags, gotagopt, gorenametag, gotype, goenv, gogateway, govalidate, gomethod, goimplement, gojson or goconstructor
+	Content   string
+	Index     int    // Position to insert a gofield at, or -1 to append it
+	Placement string // "after" or "before" the gofield's Target, if given
+	Target    string // Field a gotags applies to, a gofield is placed next to, or goimport's option
+	Alias     string // Name of a goimport, if any
+	Condition string // Condition the annotation is gated by, if any
 }
 
-func parseAnnotations(line string) []Annotation {
+// importEntry is an import to inject
+type importEntry struct {
+	Path  string // Import path, unquoted
+	Alias string // Import name, empty for none
+	Line  int    // Line of the annotation, 0 for imports added automatically
+
+	// IfUsed only adds the import when injected fields or methods use the
+	// package, for @goimport(used)
+	IfUsed bool
+}
+
+// fieldSpec is a field to inject into a struct
+type fieldSpec struct {
+	Decl      string // Field declaration, e.g. "LastName string"
+	Index     int    // Position in the struct's field list, or -1 to append
+	Placement string // "after" or "before" Anchor, overriding Index
+	Anchor    string // Field to place the new field next to
+	Tag       string // Tag of the new field, if any
+	Line      int    // Line of the annotation, for warnings
+	Replace   bool   // Replace the type (and tag) of a field of the same name
+	Default   string // Default noted in a trailing comment, from "= expr"
+}
+
+// AnnotationSpec describes an annotation recognized in comments
+type AnnotationSpec struct {
+	Name        string   // Annotation name without the leading @ (e.g. goimport)
+	Syntax      string   // Comment syntax with placeholders
+	Description string   // One-line summary
+	Examples    []string // Example comments
+}
+
+// SupportedAnnotations lists every annotation the tool understands. Help
+// output is rendered from it, and integrators can use it for validation
+// or editor tooling.
+var SupportedAnnotations = []AnnotationSpec{
+	{
+		Name:        "goimport",
+		Syntax:      `// @goimport[(used)]: [name] "<import path>"`,
+		Description: "Add new package imports, with (used) only when injected fields or methods use them",
+		Examples:    []string{`// @goimport: "gorm.io/gorm"`, `// @goimport: pq "github.com/lib/pq"`, `// @goimport(used): "gorm.io/gorm"`},
+	},
+	{
+		Name:        "gofield",
+		Syntax:      "// @gofield[<index>|after:<Field>|before:<Field>]: [Name] <Type> [= <default>]",
+		Description: "Add new struct fields, appended unless an index or a neighbouring field is given",
+		Examples:    []string{"// @gofield: LastName string", "// @gofield[0]: gorm.Model", "// @gofield[after:FirstName]: LastName string", "// @gofield: Retries int = 5"},
+	},
+	{
+		Name:        "goprimarykey",
+		Syntax:      "// @goprimarykey[: <FieldName> <Type>]",
+		Description: "Add a gorm primary key field as the first field, ID uint by default",
+		Examples:    []string{"// @goprimarykey", "// @goprimarykey: UserID uint64"},
+	},
+	{
+		Name:        "goreplacefield",
+		Syntax:      "// @goreplacefield: <Name> <Type> [`<tag>`]",
+		Description: "Change the type (and tag, if given) of an existing field, or add the field when there is none",
+		Examples:    []string{"// @goreplacefield: CreatedAt time.Time", "// @goreplacefield: Status Status `gorm:\"type:text\"`"},
+	},
+	{
+		Name:        "gotags",
+		Syntax:      `// @gotags[(<field>)]: key:"value" ...`,
+		Description: "Append or modify tags of the field on the same line, or of the named field or embedded type",
+		Examples:    []string{`// @gotags: gorm:"column:id;primaryKey;AUTO_INCREMENT"`, `// @gotags(gorm.Model): gorm:"embedded;embeddedPrefix:base_"`},
+	},
+	{
+		Name:        "gotagopt",
+		Syntax:      "// @gotagopt[(<FieldName>)]: <key> +<option> -<option>...",
+		Description: "Add or remove options of a field's tag, leaving the rest of it alone",
+		Examples:    []string{"// @gotagopt: json +omitempty", "// @gotagopt: gorm -autoIncrement +index"},
+	},
+	{
+		Name:        "gorenametag",
+		Syntax:      "// @gorenametag[(<FieldName>)]: <oldKey> <newKey>",
+		Description: "Move the value of a field's tag to another key",
+		Examples:    []string{"// @gorenametag: bson db"},
+	},
+	{
+		Name:        "goenv",
+		Syntax:      "// @goenv[: <PREFIX>]",
+		Description: "Add env tags in UPPER_SNAKE case, derived from the proto field names, to every exported field",
+		Examples:    []string{"// @goenv", "// @goenv: APP_"},
+	},
+	{
+		Name:        "gogateway",
+		Syntax:      "// @gogateway",
+		Description: "Give every exported field a lowerCamelCase json name, as grpc-gateway expects, and a snake_case gorm column",
+		Examples:    []string{"// @gogateway"},
+	},
+	{
+		Name:        "govalidate",
+		Syntax:      "// @govalidate[: <statements>]",
+		Description: "Add a Validate() error method to the struct, returning nil unless a body is given",
+		Examples:    []string{"// @govalidate", "// @govalidate: return validateUser(x)"},
+	},
+	{
+		Name:        "gomethod",
+		Syntax:      "// @gomethod: <Name>(<params>) <results> { <statements> }",
+		Description: "Add a method to the struct or enum, with the value as x",
+		Examples:    []string{"// @gomethod: TableName() string { return \"users\" }"},
+	},
+	{
+		Name:        "goimplement",
+		Syntax:      "// @goimplement: <[pkg.]Interface> [{ <methods> }]",
+		Description: "Add stubs returning zero values for the methods of an interface the type lacks",
+		Examples:    []string{"// @goimplement: io.Closer", "// @goimplement: store.Getter { Get(id string) (*User, error) }"},
+	},
+	{
+		Name:        "gojson",
+		Syntax:      "// @gojson: <template file>",
+		Description: "Add MarshalJSON and UnmarshalJSON methods with the bodies a template gives for the type's fields",
+		Examples:    []string{"// @gojson: json.tmpl"},
+	},
+	{
+		Name:        "goconstructor",
+		Syntax:      "// @goconstructor[: <Field>, ...]",
+		Description: "Add a New<Type> function taking the given fields, or all exported ones, including injected fields",
+		Examples:    []string{"// @goconstructor", "// @goconstructor: Name, Email"},
+	},
+	{
+		Name:        "gotemplate",
+		Syntax:      "// @gotemplate: <name>",
+		Description: "Apply the annotations of a named template loaded with --templates, e.g. a field, its import and methods",
+		Examples:    []string{"// @gotemplate: logger"},
+	},
+	{
+		Name:        "gotype",
+		Syntax:      "// @gotype: <[proto.package.]Message|Enum>",
+		Description: "Select the struct or enum the following annotations apply to",
+		Examples:    []string{"// @gotype: mypkg.User"},
+	},
+}
+
+// defaultPrefix is the text annotation names start with, e.g. @go in @gotags
+const defaultPrefix = "@go"
+
+// Regular expressions for the different annotation types, matching the text
+// after the prefix (import: "fmt" in @goimport: "fmt")
+var (
+	goimportRe       = regexp.MustCompile(`^import(?:\((\w*)\))?:\s*(?:(\w+|\.)\s+)?"([^"]+)"`)
+	gofieldRe        = regexp.MustCompile(`^field(?:\[(?:(\d+)|(after|before):\s*(\w+))\])?:\s*(.+)`)
+	goreplacefieldRe = regexp.MustCompile(`^replacefield:\s*(.+)`)
+	gotagsRe         = regexp.MustCompile(`^tags(?:\((.*?)\))?:\s*(.+)`)
+	gotypeRe         = regexp.MustCompile(`^type:\s*([\w.]+)`)
+	goprimarykeyRe   = regexp.MustCompile(`^primarykey(?::[ \t]*(\w+[ \t]+\S+)?)?(?:\s|$)`)
+	goenvRe          = regexp.MustCompile(`^env(?::[ \t]*(\w*))?(?:\s|$)`)
+	gogatewayRe      = regexp.MustCompile(`^gateway:?(?:\s|$)`)
+	govalidateRe     = regexp.MustCompile(`^validate(?::[ \t]*(.*)|\s|$)`)
+	gomethodRe       = regexp.MustCompile(`^method:\s*(.+)`)
+	goimplementRe    = regexp.MustCompile(`^implement:\s*(.+)`)
+	gojsonRe         = regexp.MustCompile(`^json:\s*(\S+)`)
+	goconstructorRe  = regexp.MustCompile(`^constructor(?::[ \t]*(.*)|\s|$)`)
+	gotagoptRe       = regexp.MustCompile(`^tagopt(?:\((.*?)\))?:\s*(\w+)((?:\s+[+-][^\s+-][^\s]*)+)`)
+	gorenametagRe    = regexp.MustCompile(`^renametag(?:\((.*?)\))?:\s*(\w+)\s+(\w+)`)
+	gotemplateRe     = regexp.MustCompile(`^template:\s*([\w-]+)`)
+
+	// conditionRe matches an annotation name followed by the condition
+	// gating it, e.g. tags[gorm] in @gotags[gorm]: ...
+	conditionRe = regexp.MustCompile(`^(\w+)\[([A-Za-z_]\w*)\]`)
+
+	// directiveRe matches directive style comments (//go:inject-tags json:"id")
+	// for every supported annotation
+	directiveRe = regexp.MustCompile(`^//go:inject-(` + directiveNames() + `)(\S*)\s*(.*)$`)
+)
+
+// directiveNames returns the names of SupportedAnnotations as //go:inject-
+// directives spell them (tags for @gotags), joined into a regexp alternation
+func directiveNames() string {
+	var names []string
+	for _, spec := range SupportedAnnotations {
+		names = append(names, regexp.QuoteMeta(strings.TrimPrefix(spec.Name, "go")))
+	}
+	return strings.Join(names, "|")
+}
+
+// findAnnotationIndex returns the submatch indexes in comment of the first
+// annotation re matches right after prefix; the first pair spans the prefix
+// too. It returns nil if there is none.
+func findAnnotationIndex(re *regexp.Regexp, comment, prefix string) []int {
+	for i := 0; i < len(comment); {
+		j := strings.Index(comment[i:], prefix)
+		if j < 0 {
+			break
+		}
+		start := i + j + len(prefix)
+		if match := re.FindStringSubmatchIndex(comment[start:]); match != nil {
+			for k := range match {
+				if match[k] >= 0 {
+					match[k] += start
+				}
+			}
+			match[0] = i + j
+			return match
+		}
+		i += j + 1
+	}
+	return nil
+}
+
+// findAnnotation returns the submatches of the first annotation re matches
+// right after prefix, like regexp's FindStringSubmatch
+func findAnnotation(re *regexp.Regexp, comment, prefix string) []string {
+	index := findAnnotationIndex(re, comment, prefix)
+	if index == nil {
+		return nil
+	}
+	match := make([]string, len(index)/2)
+	for k := range match {
+		if index[2*k] >= 0 {
+			match[k] = comment[index[2*k]:index[2*k+1]]
+		}
+	}
+	return match
+}
+
+// parseAnnotations extracts the annotations from a comment. The prefix replaces
+// the leading @go of each annotation name, so with prefix @inject_ the tags
+// annotation is written @inject_tags. Annotations may also be written as
+// //go:inject-<name> directives, e.g. //go:inject-tags json:"id".
+func parseAnnotations(comment, prefix string) []Annotation {
 	var annotations []Annotation
 
-	// Regular expressions for different annotation types
-	goimportRe := regexp.MustCompile(`@goimport:\s*"([^"]+)"`)
-	gofieldRe := regexp.MustCompile(`@gofield:\s*(.+)`)
-	gotagsRe := regexp.MustCompile(`@gotags:\s*(.+)`)
-	gotypeRe := regexp.MustCompile(`type\s+(\w+)\s+struct`)
+	// Directive style comments map onto the same annotations as the @go
+	// comment form
+	if match := directiveRe.FindStringSubmatch(comment); match != nil {
+		comment = prefix + match[1] + match[2] + ": " + match[3]
+	}
+
+	// A condition in brackets right after the name (@gotags[gorm]: ...) gates
+	// the annotation; it is taken out so the name parses as usual
+	condition := ""
+	if match := findAnnotationIndex(conditionRe, comment, prefix); match != nil {
+		condition = comment[match[4]:match[5]]
+		comment = comment[:match[0]] + prefix + comment[match[2]:match[3]] + comment[match[1]:]
+	}
+
+	if match := findAnnotation(goimportRe, comment, prefix); len(match) > 3 {
+		annotations = append(annotations, Annotation{Type: "goimport", Content: match[3], Alias: match[2], Target: match[1]})
+	}
+	if match := findAnnotation(gofieldRe, comment, prefix); len(match) > 4 {
+		index := -1
+		if match[1] != "" {
+			index, _ = strconv.Atoi(match[1])
+		}
+		annotations = append(annotations, Annotation{Type: "gofield", Content: stripTrailingComment(match[4]), Index: index, Placement: match[2], Target: match[3]})
+	}
+	if match := findAnnotation(goreplacefieldRe, comment, prefix); len(match) > 1 {
+		annotations = append(annotations, Annotation{Type: "goreplacefield", Content: stripTrailingComment(match[1])})
+	}
+	if match := findAnnotation(gotagsRe, comment, prefix); len(match) > 2 {
+		annotations = append(annotations, Annotation{Type: "gotags", Content: stripTrailingComment(match[2]), Target: strings.TrimSpace(match[1])})
+	}
+	if match := findAnnotation(gotypeRe, comment, prefix); len(match) > 1 {
+		annotations = append(annotations, Annotation{Type: "gotype", Content: match[1]})
+	}
+	if match := findAnnotation(goprimarykeyRe, comment, prefix); len(match) > 1 {
+		annotations = append(annotations, Annotation{Type: "goprimarykey", Content: match[1]})
+	}
+	if match := findAnnotation(goenvRe, comment, prefix); len(match) > 1 {
+		annotations = append(annotations, Annotation{Type: "goenv", Content: match[1]})
+	}
+	if findAnnotation(gogatewayRe, comment, prefix) != nil {
+		annotations = append(annotations, Annotation{Type: "gogateway"})
+	}
+	if match := findAnnotation(govalidateRe, comment, prefix); len(match) > 1 {
+		annotations = append(annotations, Annotation{Type: "govalidate", Content: strings.TrimSpace(match[1])})
+	}
+	if match := findAnnotation(gomethodRe, comment, prefix); len(match) > 1 {
+		annotations = append(annotations, Annotation{Type: "gomethod", Content: strings.TrimSpace(match[1])})
+	}
+	if match := findAnnotation(goimplementRe, comment, prefix); len(match) > 1 {
+		annotations = append(annotations, Annotation{Type: "goimplement", Content: strings.TrimSpace(match[1])})
+	}
+	if match := findAnnotation(gojsonRe, comment, prefix); len(match) > 1 {
+		annotations = append(annotations, Annotation{Type: "gojson", Content: match[1]})
+	}
+	if match := findAnnotation(goconstructorRe, comment, prefix); match != nil {
+		annotations = append(annotations, Annotation{Type: "goconstructor", Content: strings.TrimSpace(stripTrailingComment(match[1]))})
+	}
+	if match := findAnnotation(gotagoptRe, comment, prefix); len(match) > 3 {
+		annotations = append(annotations, Annotation{Type: "gotagopt", Content: match[2] + match[3], Target: strings.TrimSpace(match[1])})
+	}
+	if match := findAnnotation(gorenametagRe, comment, prefix); len(match) > 3 {
+		annotations = append(annotations, Annotation{Type: "gorenametag", Content: match[2] + " " + match[3], Target: strings.TrimSpace(match[1])})
+	}
+	if match := findAnnotation(gotemplateRe, comment, prefix); len(match) > 1 {
+		annotations = append(annotations, Annotation{Type: "gotemplate", Content: match[1]})
+	}
+
+	for i := range annotations {
+		annotations[i].Condition = condition
+	}
+	return annotations
+}
+
+// stripTrailingComment removes a trailing // comment from an annotation value,
+// e.g. the "// primary key" in `json:"id" // primary key`. A // inside a
+// quoted value (json:"http://x") is kept.
+func stripTrailingComment(content string) string {
+	var quote byte
+	for i := 0; i < len(content); i++ {
+		c := content[i]
+		switch {
+		case quote != 0:
+			if c == '\\' && quote == '"' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '`':
+			quote = c
+		case c == '/' && i+1 < len(content) && content[i+1] == '/':
+			return strings.TrimSpace(content[:i])
+		}
+	}
+	return strings.TrimSpace(content)
+}
+
+func createFieldFromString(fieldStr string) *ast.Field {
+	name, typeStr := splitFieldDecl(strings.TrimSpace(fieldStr))
+	if typeStr == "" || (name != "" && !token.IsIdentifier(name)) {
+		return nil
+	}
+
+	// Parse the type as an expression so composite, generic, channel,
+	// function and inline struct types (e.g. map[K]V, *Box[K, V], <-chan int,
+	// func(string) error, struct{ K string }) are represented properly
+	typeExpr, err := parser.ParseExpr(typeStr)
+	if err != nil {
+		return nil
+	}
+	invalid := false
+	ast.Inspect(typeExpr, func(n ast.Node) bool {
+		// Arrays of a fixed length need it spelled out ([32]byte or
+		// [sha256.Size]byte); [...] only works for composite literals
+		if array, ok := n.(*ast.ArrayType); ok {
+			if _, ok := array.Len.(*ast.Ellipsis); ok {
+				invalid = true
+			}
+		}
+		return !invalid
+	})
+	if invalid {
+		return nil
+	}
+	if _, ok := typeExpr.(*ast.StructType); ok && name == "" {
+		// An inline struct needs a field name, it can't be embedded
+		return nil
+	}
+	setPositions(typeExpr, token.NoPos)
+
+	if name == "" { // Embedded type
+		return &ast.Field{
+			Type: typeExpr,
+		}
+	}
+	// Named field with type
+	return &ast.Field{
+		Names: []*ast.Ident{ast.NewIdent(name)},
+		Type:  typeExpr,
+	}
+}
+
+// splitFieldDecl splits a field declaration into its name and type.
+// Whitespace inside brackets belongs to the type (e.g. Box[K, V] or
+// func(a, b int)), and a declaration with no name is an embedded type.
+func splitFieldDecl(fieldStr string) (string, string) {
+	depth := 0
+	for i, r := range fieldStr {
+		switch r {
+		case '[', '(', '{':
+			depth++
+		case ']', ')', '}':
+			depth--
+		case ' ', '\t':
+			if depth == 0 {
+				return fieldStr[:i], strings.TrimSpace(fieldStr[i+1:])
+			}
+		}
+	}
+	return "", fieldStr
+}
+
+// setPositions moves every position in a node to pos. Nodes parsed on their
+// own carry offsets that mean nothing in the target file and would confuse
+// the printer's comment and line placement, so they are either cleared or
+// pinned to the spot where the node is inserted.
+func setPositions(node ast.Node, pos token.Pos) {
+	posType := reflect.TypeOf(token.NoPos)
+	ast.Inspect(node, func(n ast.Node) bool {
+		if n == nil {
+			return false
+		}
+		v := reflect.ValueOf(n)
+		if v.Kind() != reflect.Pointer || v.Elem().Kind() != reflect.Struct {
+			return true
+		}
+		v = v.Elem()
+		for i := 0; i < v.NumField(); i++ {
+			if f := v.Field(i); f.Type() == posType && f.CanSet() {
+				f.SetInt(int64(pos))
+			}
+		}
+		return true
+	})
+}
+
+// tagPair is a single key:"value" entry of a struct tag. Value holds the raw
+// text between the quotes, escapes included, so it round-trips unchanged.
+type tagPair struct {
+	Key   string
+	Value string
+}
+
+// parseTags parses a Go struct tag string into its key-value pairs, in the
+// order they appear. Any text that isn't a well-formed key:"value" pair is
+// returned as the remainder so callers can keep it instead of dropping it.
+func parseTags(tagStr string) ([]tagPair, string) {
+	var tags []tagPair
+	var remainder []string
+	tag := strings.Trim(tagStr, "`")
+
+	// Scan key:"value" pairs following the reflect.StructTag conventions
+	for {
+		tag = strings.TrimLeft(tag, " \t")
+		if tag == "" {
+			break
+		}
+
+		// Keys run up to the colon and may contain anything but spaces,
+		// quotes and control characters (e.g. x-key or mapstructure)
+		i := 0
+		for i < len(tag) && tag[i] > ' ' && tag[i] != ':' && tag[i] != '"' && tag[i] != 0x7f {
+			i++
+		}
+		if i == 0 || i+1 >= len(tag) || tag[i] != ':' || tag[i+1] != '"' {
+			// Malformed entry, keep it up to the next space
+			end := strings.IndexAny(tag, " \t")
+			if end < 0 {
+				end = len(tag)
+			}
+			remainder = append(remainder, tag[:end])
+			tag = tag[end:]
+			continue
+		}
+		key := tag[:i]
+		tag = tag[i+1:]
+
+		// Values are quoted and may contain escaped quotes
+		i = 1
+		for i < len(tag) && tag[i] != '"' {
+			if tag[i] == '\\' {
+				i++
+			}
+			i++
+		}
+		if i >= len(tag) {
+			// Unterminated value, keep the rest as is
+			remainder = append(remainder, key+":"+tag)
+			break
+		}
+		tags = append(tags, tagPair{Key: key, Value: tag[1:i]})
+		tag = tag[i+1:]
+	}
+	return tags, strings.Join(remainder, " ")
+}
+
+// mergeTags overlays the new tags onto the existing ones. Existing keys keep
+// their position and take the new value unless override is false, keys not
+// seen before are appended. The strategies select how the values of a key
+// are combined (see tagMergers), by default the new value replaces the old.
+func mergeTags(existing, newTags []tagPair, override bool, strategies map[string]string) []tagPair {
+	merged := append([]tagPair(nil), existing...)
+	for _, nt := range newTags {
+		replaced := false
+		for i := range merged {
+			if merged[i].Key == nt.Key {
+				if override {
+					merger := tagMergers["replace"]
+					if strategy, ok := strategies[nt.Key]; ok {
+						merger = tagMergers[strategy]
+					}
+					merged[i].Value = merger(merged[i].Value, nt.Value)
+				}
+				replaced = true
+				break
+			}
+		}
+		if !replaced {
+			merged = append(merged, nt)
+		}
+	}
+	return merged
+}
+
+// fieldTags parses the tag of a field, which may be written as a raw or an
+// interpreted string literal
+func fieldTags(field *ast.Field) ([]tagPair, string) {
+	if field.Tag == nil {
+		return nil, ""
+	}
+	tagStr, err := strconv.Unquote(field.Tag.Value)
+	if err != nil {
+		tagStr = field.Tag.Value
+	}
+	return parseTags(tagStr)
+}
+
+// formatTags converts tag pairs back to a tag string
+func formatTags(tags []tagPair) string {
+	var parts []string
+	for _, tag := range tags {
+		parts = append(parts, fmt.Sprintf(`%s:"%s"`, tag.Key, tag.Value))
+	}
+	return strings.Join(parts, " ")
+}
+
+// Regular expressions finding a field's names on its source line: the name=
+// of its protobuf tag, the name in its json tag (only in the field's tag, not
+// in the annotation after it) and its Go name
+var (
+	protobufNameRe = regexp.MustCompile(`protobuf:"[^"]*\bname=(\w+)`)
+	lineJSONNameRe = regexp.MustCompile("`[^`]*\\bjson:\"([^\",]+)")
+	lineGoNameRe   = regexp.MustCompile(`^\s*(\w+)\s`)
+)
+
+// protoTagNameRe finds the name= in the value of a protobuf tag
+var protoTagNameRe = regexp.MustCompile(`\bname=(\w+)`)
+
+// defaultNameFallback is the order of the names fields without a protobuf
+// name are targeted by from their line
+var defaultNameFallback = []string{"json", "go"}
+
+// lineFieldName returns the name of the field declared on a source line, for
+// annotations in its trailing comment. The protobuf field name is preferred,
+// falling back for other files to the names in fallback, in order: "json"
+// for the name in the json tag and "go" for the Go field name. With goNames
+// only the Go name is used. Only the protobuf tag is searched, since map
+// fields also carry protobuf_key and protobuf_val tags with names of their
+// own.
+func lineFieldName(line string, goNames bool, fallback []string) string {
+	if fallback == nil {
+		fallback = defaultNameFallback
+	}
+	if goNames {
+		fallback = []string{"go"}
+	} else if match := protobufNameRe.FindStringSubmatch(line); len(match) > 1 {
+		return match[1]
+	}
+	for _, source := range fallback {
+		var match []string
+		switch source {
+		case "json":
+			match = lineJSONNameRe.FindStringSubmatch(line)
+			if len(match) > 1 && match[1] == "-" {
+				match = nil
+			}
+		case "go":
+			match = lineGoNameRe.FindStringSubmatch(line)
+		}
+		if len(match) > 1 {
+			return match[1]
+		}
+	}
+	return ""
+}
+
+// protoFieldName returns the proto name of a field from its protobuf tag,
+// or its Go name when it has none
+func protoFieldName(field *ast.Field) string {
+	if name := protoTagName(field); name != "" {
+		return name
+	}
+	return field.Names[0].Name
+}
+
+// protoTagName returns the name= of a field's protobuf tag, or "" when it
+// has none
+func protoTagName(field *ast.Field) string {
+	tags, _ := fieldTags(field)
+	for _, tag := range tags {
+		if tag.Key != "protobuf" {
+			continue
+		}
+		if match := protoTagNameRe.FindStringSubmatch(tag.Value); len(match) > 1 {
+			return match[1]
+		}
+	}
+	return ""
+}
+
+// jsonFieldName returns the name in a field's json tag, or "" when it has
+// none or is left out of JSON (json:"-")
+func jsonFieldName(field *ast.Field) string {
+	tags, _ := fieldTags(field)
+	for _, tag := range tags {
+		if tag.Key == "json" {
+			if name, _, _ := strings.Cut(tag.Value, ","); name != "-" {
+				return name
+			}
+		}
+	}
+	return ""
+}
+
+// protoFieldNumber returns the proto field number of a field from its
+// protobuf tag (protobuf:"bytes,3,opt,name=email"), or 0 when it has none
+func protoFieldNumber(field *ast.Field) int {
+	if field.Tag == nil {
+		return 0
+	}
+	tags, _ := fieldTags(field)
+	for _, tag := range tags {
+		if tag.Key != "protobuf" {
+			continue
+		}
+		if parts := strings.Split(tag.Value, ","); len(parts) > 1 {
+			number, _ := strconv.Atoi(parts[1])
+			return number
+		}
+	}
+	return 0
+}
+
+// toSnakeCase converts a Go or proto name to snake_case, keeping acronyms
+// together (UserID -> user_id, HTTPServer -> http_server)
+func toSnakeCase(name string) string {
+	runes := []rune(name)
+	var sb strings.Builder
+	for i, r := range runes {
+		if unicode.IsUpper(r) && i > 0 {
+			prev := runes[i-1]
+			nextLower := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			if prev != '_' && (unicode.IsLower(prev) || unicode.IsDigit(prev) || (unicode.IsUpper(prev) && nextLower)) {
+				sb.WriteByte('_')
+			}
+		}
+		sb.WriteRune(unicode.ToLower(r))
+	}
+	return sb.String()
+}
+
+// normalizeFieldName folds a proto or Go field name into the key used to
+// match tags to fields, so user_id, UserId and User_ID all match
+func normalizeFieldName(name string) string {
+	return strings.ToLower(strings.ReplaceAll(name, "_", ""))
+}
+
+// getEmbeddedStructName gets the name of an embedded struct or interface
+func getEmbeddedStructName(field *ast.Field) string {
+	// If field has names, it's not an embedded struct
+	if len(field.Names) > 0 {
+		return ""
+	}
+
+	// Handle different types of embedded structs
+	switch t := field.Type.(type) {
+	case *ast.Ident:
+		// Simple embedded struct (e.g., Model)
+		return t.Name
+	case *ast.SelectorExpr:
+		// Qualified embedded struct (e.g., gorm.Model)
+		if x, ok := t.X.(*ast.Ident); ok {
+			return x.Name + "." + t.Sel.Name
+		}
+	case *ast.StarExpr:
+		// Embedded pointer (e.g., *gorm.Model)
+		return getEmbeddedStructName(&ast.Field{Type: t.X})
+	case *ast.IndexExpr:
+		// Generic embedded struct with one type argument (e.g., Base[T])
+		return getEmbeddedStructName(&ast.Field{Type: t.X})
+	case *ast.IndexListExpr:
+		// Generic embedded struct with several type arguments (e.g., Pair[K, V])
+		return getEmbeddedStructName(&ast.Field{Type: t.X})
+	}
+	return ""
+}
+
+// enhancedMarker is written at the top of every file that received injections
+const enhancedMarker = "// Code enhanced by protoc-go-inject."
+
+// generatedHeaderRe matches the standard "Code generated ... DO NOT EDIT."
+// line that protoc and other generators put above the package clause
+var generatedHeaderRe = regexp.MustCompile(`(?m)^// Code generated .* DO NOT EDIT\.$|^package `)
+
+// addEnhancedMarker adds enhancedMarker right below the generated header, so
+// the header stays the first line of the file, or at the top of files
+// without one
+func addEnhancedMarker(src []byte) []byte {
+	loc := generatedHeaderRe.FindIndex(src)
+	if loc == nil || bytes.HasPrefix(src[loc[0]:], []byte("package ")) {
+		return append([]byte(enhancedMarker+"\n\n"), src...)
+	}
+	var out []byte
+	out = append(out, src[:loc[1]]...)
+	out = append(out, "\n"+enhancedMarker...)
+	return append(out, src[loc[1]:]...)
+}
+
+// utf8BOM is the byte order mark some editors put at the start of a file
+var utf8BOM = []byte("\xef\xbb\xbf")
+
+// formatNode prints the processed file and its injected methods. Tests
+// replace it to make printing fail.
+var formatNode = format.Node
+
+// Processor holds the options for a run and applies annotations to files
+type Processor struct {
+	Verbose   bool   // Print warnings and extra details
+	LogFormat string // Output format for processing events, text or json
+	Prefix    string // Annotation prefix replacing @go (e.g. @inject_)
+	Debug     bool   // Dump the collected annotations to stderr
+	Suffix    string // Suffix of the intermediate file written next to each input
+	PatchFile string // Write a unified diff here instead of modifying files
+	Color     bool   // Color warnings, errors and changes in text output
+	AlignTags bool   // Line up all tags of a struct on one column
+	YAMLCase  string // Casing of yaml tags mirrored from json tags, "" for none
+
+	// PreserveFormatting keeps the original text of lines injection didn't
+	// change instead of writing the whole file as the printer formats it
+	PreserveFormatting bool
+
+	// MergeStrategies selects how the values of a tag key are merged, by key
+	// (e.g. gorm -> gorm); keys without one have their value replaced
+	MergeStrategies map[string]string
+
+	// WKTTags maps well-known proto types (e.g. timestamppb.Timestamp) to
+	// tags injected into every field of that type, nil when disabled
+	WKTTags map[string]string
+
+	// handlers process custom annotations, by type
+	handlers map[string]AnnotationHandler
+
+	// OutDir receives the processed files instead of the inputs being
+	// modified, under their names with RenamePattern replaced by
+	// RenameReplacement in them
+	OutDir            string
+	RenamePattern     *regexp.Regexp
+	RenameReplacement string
+
+	// WarnImportCycles warns about added imports of packages in the file's
+	// own module, which may import the file's package
+	WarnImportCycles bool
+
+	// GoImports formats processed files with goimports instead of only
+	// go/format
+	GoImports bool
+
+	// NameFallback orders the names, "json" and "go", a trailing annotation
+	// targets its line's field by when it has no protobuf name; nil for
+	// defaultNameFallback
+	NameFallback []string
+
+	// GoNames matches annotation targets by Go field names only, for files
+	// whose protobuf tags (if any) shouldn't be taken into account
+	GoNames bool
+
+	// Validate only checks the annotations of each file: they are parsed and
+	// applied in memory, but nothing is written
+	Validate bool
+
+	// RequireAnnotations fails files that have no annotations and get
+	// nothing from options like --wkt-tags either
+	RequireAnnotations bool
+
+	// StrictTypes fails files where a @gotype matches several types instead
+	// of picking one
+	StrictTypes bool
+
+	// NormalizeImports requotes and dedupes the file's existing imports
+	NormalizeImports bool
+
+	// TypeTags maps Go types (e.g. time.Time) to default tags added to every
+	// field of that type that doesn't have them yet
+	TypeTags map[string]string
+
+	// Conditions enabled with --conditions; annotations gated by any other
+	// condition are skipped
+	Conditions map[string]bool
+
+	// Templates holds the annotation lines of each template @gotemplate
+	// expands, loaded with --templates
+	Templates map[string][]string
+
+	// Only holds the types selected with --only, nil for all; annotations
+	// of other types, imports among them, are skipped
+	Only map[string]bool
+
+	// TagOrder lists tag keys in the order tags are written in, from
+	// --tag-order; other keys follow alphabetically. Nil keeps the order
+	// of the existing tag, with new keys appended.
+	TagOrder []string
+
+	// AllowedTags holds the tag keys annotations may set, from
+	// --allowed-tags, nil for any
+	AllowedTags map[string]bool
+
+	// ReportUnused is where --report-unused lists the annotation entries
+	// that no longer apply to anything, "-" for stdout, "" for no report
+	ReportUnused string
+
+	// ExternalMethods writes injected methods, constructors and interface
+	// assertions to a companion file next to each file instead of into it
+	ExternalMethods bool
+
+	warnings int        // Number of warnings emitted so far
+	failures []logEvent // Errors logged so far, for the summary
+
+	patchMu sync.Mutex
+	patches map[string]string // Diff of each changed file, by patch path
+
+	unusedMu sync.Mutex
+	unused   []unusedEntry // Entries found for ReportUnused so far
+
+	outputsMu sync.Mutex
+	outputs   map[string]string // Input written to each output path
+
+	importsMu sync.Mutex
+	imports   map[string][]importUse // Files each import path was added to
+
+	// parseSlots limits how many files are parsed at once, nil for no limit
+	// beyond the number of workers
+	parseSlots chan struct{}
+}
+
+// importUse records an import added to a file during the run
+type importUse struct {
+	File  string
+	Alias string
+}
+
+// recordImport remembers an import added to a file, warning when another
+// file of the run got the same path under a different name
+func (p *Processor) recordImport(inputPath string, imp importEntry) {
+	p.importsMu.Lock()
+	if p.imports == nil {
+		p.imports = make(map[string][]importUse)
+	}
+	var conflict *importUse
+	for _, use := range p.imports[imp.Path] {
+		if importName(use.Alias, imp.Path) != importName(imp.Alias, imp.Path) {
+			conflict = &use
+			break
+		}
+	}
+	p.imports[imp.Path] = append(p.imports[imp.Path], importUse{File: inputPath, Alias: imp.Alias})
+	p.importsMu.Unlock()
+
+	if conflict != nil {
+		p.warnAtf(inputPath, imp.Line, "import %q added as %s, but as %s in %s",
+			imp.Path, describeImportName(imp.Alias), describeImportName(conflict.Alias), conflict.File)
+	}
+}
+
+// addImport adds an import to the file unless its path is already imported,
+// in any form, and reports whether it was added. An existing import is kept
+// as is, with a warning when its name differs from the requested one.
+func (p *Processor) addImport(inputPath string, fset *token.FileSet, astFile *ast.File, imp importEntry) bool {
+	// Check for duplicate imports across all import declarations, comparing
+	// unquoted paths so aliased or differently quoted imports match too
+	var importDecl *ast.GenDecl
+	for _, decl := range astFile.Decls {
+		genDecl, ok := decl.(*ast.GenDecl)
+		if !ok || genDecl.Tok != token.IMPORT {
+			continue
+		}
+		// import "C" must stay on its own, right after its cgo preamble
+		if importDecl == nil && !importsCgo(genDecl) {
+			importDecl = genDecl
+		}
+		for _, spec := range genDecl.Specs {
+			impSpec, ok := spec.(*ast.ImportSpec)
+			if !ok {
+				continue
+			}
+			if path, err := strconv.Unquote(impSpec.Path.Value); err != nil || path != imp.Path {
+				continue
+			}
+			existingAlias := ""
+			if impSpec.Name != nil {
+				existingAlias = impSpec.Name.Name
+			}
+			if existingAlias == "_" && imp.Alias != "_" {
+				// A blank import only runs the package's init, the name still
+				// has to be imported
+				continue
+			}
+			// protoc-gen-go names its imports even when the name is the
+			// package's own (protoimpl "google.golang.org/.../protoimpl")
+			if importName(existingAlias, imp.Path) != importName(imp.Alias, imp.Path) {
+				p.warnAtf(inputPath, imp.Line, "import %q is already present as %s, keeping it instead of %s",
+					imp.Path, describeImportName(existingAlias), describeImportName(imp.Alias))
+			}
+			return false
+		}
+	}
+
+	// Create an import declaration if the file has none
+	if importDecl == nil {
+		// Placed right after the package clause, so the printer keeps the
+		// comments that follow outside of the block. A comment on the
+		// package line (package pb // @goimport: ...) stays there, the
+		// block going right below it, also when the file declares nothing
+		// else; placed before it, the printer would move it after the
+		// block. The printer leaves no blank line between the comment and
+		// the block, which separateImports adds.
+		pos := astFile.Name.End()
+		line := fset.Position(pos).Line
+		for _, group := range astFile.Comments {
+			if group.Pos() >= pos && fset.Position(group.Pos()).Line == line {
+				pos = group.End()
+				break
+			}
+		}
+		importDecl = &ast.GenDecl{
+			TokPos: pos,
+			Tok:    token.IMPORT,
+			Lparen: pos, // Multi-line import block
+			Rparen: pos,
+		}
+		astFile.Decls = append([]ast.Decl{importDecl}, astFile.Decls...)
+	}
+
+	// Turn a single import (import "io") into a block so it can hold more
+	if !importDecl.Lparen.IsValid() && len(importDecl.Specs) > 0 {
+		last := importDecl.Specs[len(importDecl.Specs)-1].(*ast.ImportSpec)
+		importDecl.Lparen = importDecl.Specs[0].Pos()
+		importDecl.Rparen = last.End()
+		if last.Comment != nil {
+			// Keep a trailing comment (import "io" // for Reader) on its line
+			importDecl.Rparen = last.Comment.End()
+		}
+	}
+
+	// Place the import before the closing parenthesis so comments after the
+	// block stay where they are
+	importSpec := &ast.ImportSpec{
+		Path: &ast.BasicLit{
+			ValuePos: importDecl.Rparen,
+			Kind:     token.STRING,
+			Value:    strconv.Quote(imp.Path),
+		},
+		EndPos: importDecl.Rparen,
+	}
+	if imp.Alias != "" {
+		importSpec.Name = &ast.Ident{NamePos: importDecl.Rparen, Name: imp.Alias}
+	}
+	importDecl.Specs = append(importDecl.Specs, importSpec)
+	p.recordImport(inputPath, imp)
+	astFile.Imports = append(astFile.Imports, importSpec)
+	return true
+}
+
+// packageCommentImportRe matches a package clause with a line comment
+// directly followed by an import declaration
+var packageCommentImportRe = regexp.MustCompile(`(?m)^package \w+[ \t]+//.*\n()import\b`)
+
+// separateImports puts a blank line between a package clause with a line
+// comment and an import declaration added right below it. The printer
+// drops the blank line after a line comment when the next declaration has
+// no line of its own in the source, as new ones don't.
+func separateImports(src []byte) []byte {
+	match := packageCommentImportRe.FindSubmatchIndex(src)
+	if match == nil {
+		return src
+	}
+	return slices.Concat(src[:match[2]], []byte("\n"), src[match[2]:])
+}
+
+// normalizeImports cleans up the file's existing imports: paths quoted with
+// backquotes are requoted with double quotes, and imports repeating an
+// earlier one (same path and name) are removed, along with their comments.
+// An import of the same path under another name is kept with a warning,
+// since the code may refer to it by either name. It returns the number of
+// imports changed or removed.
+func (p *Processor) normalizeImports(inputPath string, astFile *ast.File) int {
+	count := 0
+	seen := make(map[string][]string) // Names each path was imported under
+	removed := make(map[*ast.ImportSpec]bool)
+	for _, decl := range astFile.Decls {
+		genDecl, ok := decl.(*ast.GenDecl)
+		if !ok || genDecl.Tok != token.IMPORT {
+			continue
+		}
+		specs := genDecl.Specs[:0]
+		for _, spec := range genDecl.Specs {
+			impSpec := spec.(*ast.ImportSpec)
+			path, err := strconv.Unquote(impSpec.Path.Value)
+			if err != nil {
+				specs = append(specs, spec)
+				continue
+			}
+			if quoted := strconv.Quote(path); impSpec.Path.Value != quoted {
+				impSpec.Path.Value = quoted
+				count++
+			}
+
+			name := ""
+			if impSpec.Name != nil {
+				name = impSpec.Name.Name
+			}
+			if slices.Contains(seen[path], name) {
+				removed[impSpec] = true
+				count++
+				continue
+			}
+			if len(seen[path]) > 0 {
+				p.warnf(inputPath, "import %q is present both as %s and as %s",
+					path, describeImportName(seen[path][0]), describeImportName(name))
+			}
+			seen[path] = append(seen[path], name)
+			specs = append(specs, spec)
+		}
+		genDecl.Specs = specs
+	}
+	if len(removed) == 0 {
+		return count
+	}
+
+	// Drop the comments of removed imports and declarations left empty
+	astFile.Imports = slices.DeleteFunc(astFile.Imports, func(impSpec *ast.ImportSpec) bool {
+		return removed[impSpec]
+	})
+	astFile.Comments = slices.DeleteFunc(astFile.Comments, func(group *ast.CommentGroup) bool {
+		for impSpec := range removed {
+			if group == impSpec.Doc || group == impSpec.Comment {
+				return true
+			}
+		}
+		return false
+	})
+	astFile.Decls = slices.DeleteFunc(astFile.Decls, func(decl ast.Decl) bool {
+		genDecl, ok := decl.(*ast.GenDecl)
+		return ok && genDecl.Tok == token.IMPORT && len(genDecl.Specs) == 0 && !genDecl.Lparen.IsValid()
+	})
+	return count
+}
+
+// stdlibPackages maps the names of standard library packages commonly used
+// in injected fields and methods to their import paths, so they can be
+// imported automatically
+var stdlibPackages = map[string]string{
+	"atomic":  "sync/atomic",
+	"big":     "math/big",
+	"bytes":   "bytes",
+	"context": "context",
+	"errors":  "errors",
+	"fmt":     "fmt",
+	"http":    "net/http",
+	"io":      "io",
+	"json":    "encoding/json",
+	"netip":   "net/netip",
+	"regexp":  "regexp",
+	"sha256":  "crypto/sha256",
+	"sql":     "database/sql",
+	"strconv": "strconv",
+	"strings": "strings",
+	"sync":    "sync",
+	"time":    "time",
+	"url":     "net/url",
+}
+
+// referencedPackages returns the package names a type expression or an
+// injected method qualifies identifiers with (e.g. sync in sync.Mutex), in
+// order of appearance
+func referencedPackages(node ast.Node) []string {
+	var pkgs []string
+	ast.Inspect(node, func(n ast.Node) bool {
+		if sel, ok := n.(*ast.SelectorExpr); ok {
+			if x, ok := sel.X.(*ast.Ident); ok && !slices.Contains(pkgs, x.Name) {
+				pkgs = append(pkgs, x.Name)
+			}
+		}
+		return true
+	})
+	return pkgs
+}
+
+// importsName reports whether the file imports a package under the given
+// name, either explicitly or through the last element of its path
+func importsName(astFile *ast.File, name string) bool {
+	for _, impSpec := range astFile.Imports {
+		if impSpec.Name != nil {
+			if impSpec.Name.Name == name {
+				return true
+			}
+			continue
+		}
+		if path, err := strconv.Unquote(impSpec.Path.Value); err == nil && path[strings.LastIndex(path, "/")+1:] == name {
+			return true
+		}
+	}
+	return false
+}
+
+// importName returns the name an import is referred to by: its alias, or
+// else the last element of its path
+func importName(alias, path string) string {
+	if alias != "" {
+		return alias
+	}
+	return path[strings.LastIndex(path, "/")+1:]
+}
+
+// importsCgo reports whether an import declaration imports "C"
+func importsCgo(decl *ast.GenDecl) bool {
+	return slices.ContainsFunc(decl.Specs, func(spec ast.Spec) bool {
+		impSpec, ok := spec.(*ast.ImportSpec)
+		return ok && impSpec.Path.Value == `"C"`
+	})
+}
+
+// normalizeImportPath cleans up an import path copied from elsewhere, e.g.
+// from a URL: surrounding whitespace and trailing slashes are dropped
+func normalizeImportPath(path string) string {
+	return strings.TrimRight(strings.TrimSpace(path), "/")
+}
+
+// validateImport checks that an import to inject can compile
+func validateImport(imp importEntry) error {
+	if imp.Alias != "" && imp.Alias != "." && imp.Alias != "_" && !token.IsIdentifier(imp.Alias) {
+		return fmt.Errorf("%q is not a valid import name", imp.Alias)
+	}
+	if imp.Path == "" {
+		return fmt.Errorf("empty import path")
+	}
+	// Go modules don't support relative imports
+	if imp.Path == "." || imp.Path == ".." || strings.HasPrefix(imp.Path, "./") || strings.HasPrefix(imp.Path, "../") {
+		return fmt.Errorf("relative import paths are not supported")
+	}
+	if strings.HasPrefix(imp.Path, "/") {
+		return fmt.Errorf("absolute import paths are not supported")
+	}
+	if strings.Contains(imp.Path, "://") {
+		return fmt.Errorf("import paths have no scheme, e.g. example.com/pkg rather than https://example.com/pkg")
+	}
+	// The characters the Go spec lets compilers reject, which gc does
+	for _, r := range imp.Path {
+		if !unicode.IsGraphic(r) || unicode.IsSpace(r) || r == unicode.ReplacementChar || strings.ContainsRune("!\"#$%&'()*,:;<=>?[\\]^`{|}", r) {
+			return fmt.Errorf("invalid character %q in import path", r)
+		}
+	}
+	if strings.Contains(imp.Path, "//") {
+		return fmt.Errorf("empty element in import path")
+	}
+	return nil
+}
+
+// describeImportName describes an import's name for messages
+func describeImportName(alias string) string {
+	if alias == "" {
+		return "an unnamed import"
+	}
+	return "alias " + alias
+}
+
+// insertField adds a field to a struct at the given index, or appends it when
+// the index is negative or past the end. The field is positioned so the
+// printer keeps surrounding comments in place: when appending, after any
+// comment on the last field but before comments that close the struct, so
+// they stay at its end instead of turning into the new field's doc; when
+// inserting, at the end of the line before the displaced field (and its doc
+// comment), which keeps the previous field's trailing comment, such as a
+// //nolint directive, on that field. A field injected in front of another
+// injected one shares its position, since it is in the same gap between
+// original lines. Structs written on one line get one field per line.
+func insertField(fset *token.FileSet, comments []*ast.CommentGroup, structType *ast.StructType, field *ast.Field, index int) {
+	list := structType.Fields.List
+	if index < 0 || index >= len(list) {
+		pos := structType.Fields.Closing
+		// Fields injected before sit at the end of a line and don't have real
+		// extents, so look for comments after the last original field
+		file := fset.File(pos)
+		lastLine := file.Line(structType.Struct)
+		for i := len(list) - 1; i >= 0; i-- {
+			if !injectedField(file, list[i]) {
+				lastLine = file.Line(list[i].End())
+				break
+			}
+		}
+		for _, group := range comments {
+			if group.Pos() > structType.Struct && group.Pos() < pos && file.Line(group.Pos()) > lastLine {
+				pos = file.LineStart(file.Line(group.Pos())) - 1
+				break
+			}
+		}
+		setPositions(field, pos)
+		structType.Fields.List = append(list, field)
+		// The printer keeps a struct written on one line (struct{}) on one
+		// line if it has a single small field. Without the position of its
+		// opening brace, it lays the fields out one per line as for any other.
+		if file.Line(structType.Struct) == file.Line(structType.Fields.Closing) {
+			structType.Fields.Opening = token.NoPos
+		}
+		return
+	}
+
+	pos := list[index].Pos()
+	if list[index].Doc != nil {
+		pos = list[index].Doc.Pos()
+	}
+	if file := fset.File(pos); !injectedField(file, list[index]) {
+		pos = file.LineStart(file.Line(pos)) - 1
+	}
+	setPositions(field, pos)
+	structType.Fields.List = append(list[:index], append([]*ast.Field{field}, list[index:]...)...)
+}
+
+// injectedField reports whether a field was inserted by insertField at the
+// end of a line, where no field of the source starts
+func injectedField(file *token.File, field *ast.Field) bool {
+	start := field.Pos()
+	return file.Line(start+1) != file.Line(start)
+}
+
+// replaceFieldSpec returns the field given to @goreplacefield: a declaration
+// as for @gofield, optionally followed by a tag in backquotes
+func replaceFieldSpec(content string) fieldSpec {
+	decl, tag := content, ""
+	if strings.HasSuffix(content, "`") {
+		if start := strings.LastIndex(content[:len(content)-1], "`"); start >= 0 {
+			decl, tag = strings.TrimSpace(content[:start]), content[start+1:len(content)-1]
+		}
+	}
+	return fieldSpec{Decl: decl, Index: -1, Tag: tag, Replace: true}
+}
+
+// replaceField gives the struct field named like field the type of field.
+// It returns the field, nil when there is none, and whether its type
+// changed. A field declared together with others (A, B int) is split off
+// from them, and field takes its place. The tag is left to the caller.
+func replaceField(fset *token.FileSet, comments []*ast.CommentGroup, structType *ast.StructType, field *ast.Field) (*ast.Field, bool) {
+	name := fieldLabel(field)
+	for i, existing := range structType.Fields.List {
+		// Named fields replace named ones, and embedded types embedded ones
+		index := slices.IndexFunc(existing.Names, func(ident *ast.Ident) bool { return ident.Name == name })
+		if len(field.Names) == 0 {
+			if len(existing.Names) > 0 || embeddedFieldName(fieldLabel(existing)) != embeddedFieldName(name) {
+				continue
+			}
+		} else if index < 0 {
+			continue
+		}
+		if types.ExprString(existing.Type) == types.ExprString(field.Type) {
+			return existing, false
+		}
+		if len(existing.Names) > 1 {
+			field.Tag = existing.Tag
+			existing.Names = slices.Delete(existing.Names, index, index+1)
+			insertField(fset, comments, structType, field, i+1)
+			return field, true
+		}
+		setPositions(field.Type, existing.Type.Pos())
+		existing.Type = field.Type
+		return existing, true
+	}
+	return nil, false
+}
+
+// conflictingField returns the field of a struct that keeps field from being
+// injected: one with the same name but a different declaration, e.g. a
+// Context string field for an embedded context.Context. It returns nil when
+// the struct has field as is, which is what reruns find.
+func conflictingField(structType *ast.StructType, field *ast.Field) *ast.Field {
+	name := embeddedFieldName(fieldLabel(field))
+	var conflict *ast.Field
+	for _, existing := range structType.Fields.List {
+		names := []string{embeddedFieldName(fieldLabel(existing))}
+		if len(existing.Names) > 0 {
+			names = nil
+			for _, ident := range existing.Names {
+				names = append(names, ident.Name)
+			}
+		}
+		if !slices.Contains(names, name) {
+			continue
+		}
+		if len(existing.Names) > 0 == (len(field.Names) > 0) && types.ExprString(existing.Type) == types.ExprString(field.Type) {
+			return nil
+		}
+		conflict = existing
+	}
+	return conflict
+}
+
+// primaryKeySpec returns the field injected by @goprimarykey: the given
+// "Name Type" (ID uint by default) as the first field, tagged as gorm's
+// primary key. Only integer keys are auto-incremented.
+func primaryKeySpec(decl string) fieldSpec {
+	if decl == "" {
+		decl = "ID uint"
+	}
+	name, typeName := splitFieldDecl(decl)
+	tag := `gorm:"primaryKey"`
+	switch typeName {
+	case "int", "int8", "int16", "int32", "int64", "uint", "uint8", "uint16", "uint32", "uint64":
+		tag = `gorm:"primaryKey;autoIncrement"`
+	}
+	return fieldSpec{Decl: name + " " + typeName, Index: 0, Tag: tag}
+}
+
+// findField returns the index of the struct field with the given name, matched
+// like @gotags targets, or -1 when no single field matches
+func findField(structType *ast.StructType, name string, goNames bool) int {
+	matches := matchFields(structType, name, goNames)
+	if len(matches) != 1 {
+		return -1
+	}
+	return slices.Index(structType.Fields.List, matches[0])
+}
+
+// fieldKeys returns the names annotations can target a field by: its Go
+// names and its proto name, which differ for fields renamed with gogoproto's
+// customname, or for embedded fields their type (gorm.Model) and field name
+// (Model). Fields of other generators, without a proto name, can be
+// targeted by their json name instead. With goNames both are left out.
+func fieldKeys(field *ast.Field, goNames bool) []string {
+	var keys []string
+	add := func(name string) {
+		if !slices.Contains(keys, name) {
+			keys = append(keys, name)
+		}
+	}
+	if len(field.Names) > 0 {
+		for _, ident := range field.Names {
+			add(ident.Name)
+		}
+		if !goNames {
+			if protoName := protoTagName(field); protoName != "" {
+				add(protoName)
+			} else if jsonName := jsonFieldName(field); jsonName != "" {
+				add(jsonName)
+			}
+		}
+	} else if embeddedName := getEmbeddedStructName(field); embeddedName != "" {
+		add(embeddedName)
+		add(embeddedFieldName(embeddedName))
+	}
+	return keys
+}
+
+// matchFields returns the fields of a struct an annotation targeting name
+// applies to. A field named exactly so wins; otherwise names are compared
+// normalized, which can match several fields, e.g. ID and Id, or UserId and
+// UserID. A name in slashes (/_at$/) is a regular expression matching the
+// Go or proto names of any number of fields, #N matches the field with proto
+// field number N, which survives renames in the proto, and allFields
+// matches every exported field. With goNames, proto names aren't matched.
+func matchFields(structType *ast.StructType, name string, goNames bool) []*ast.Field {
+	if number, ok := targetNumber(name); ok {
+		var matches []*ast.Field
+		for _, field := range structType.Fields.List {
+			if protoFieldNumber(field) == number {
+				matches = append(matches, field)
+			}
+		}
+		return matches
+	}
+	if name == allFields {
+		var matches []*ast.Field
+		for _, field := range structType.Fields.List {
+			if label := fieldLabel(field); label != "" && ast.IsExported(embeddedFieldName(label)) {
+				matches = append(matches, field)
+			}
+		}
+		return matches
+	}
+	if pattern, ok := targetPattern(name); ok {
+		var matches []*ast.Field
+		for _, field := range structType.Fields.List {
+			if slices.ContainsFunc(fieldKeys(field, goNames), pattern.MatchString) {
+				matches = append(matches, field)
+			}
+		}
+		return matches
+	}
+
+	var exact, folded []*ast.Field
+	for _, field := range structType.Fields.List {
+		for _, key := range fieldKeys(field, goNames) {
+			if key == name {
+				exact = append(exact, field)
+				break
+			}
+		}
+		for _, key := range fieldKeys(field, goNames) {
+			if normalizeFieldName(key) == normalizeFieldName(name) {
+				folded = append(folded, field)
+				break
+			}
+		}
+	}
+	if len(exact) > 0 {
+		return exact
+	}
+	return folded
+}
+
+// allFields is the target of struct-wide annotations, @gotags(*) or those in
+// the struct's doc comment. It sorts before patterns and names, so their
+// annotations are applied later and win.
+const allFields = "*"
+
+// targetPattern returns the regular expression of a target written in
+// slashes, e.g. /_at$/
+func targetPattern(name string) (*regexp.Regexp, bool) {
+	if len(name) < 2 || !strings.HasPrefix(name, "/") || !strings.HasSuffix(name, "/") {
+		return nil, false
+	}
+	pattern, err := regexp.Compile(name[1 : len(name)-1])
+	return pattern, err == nil
+}
+
+// targetNumber returns the proto field number of a target written #N
+func targetNumber(name string) (int, bool) {
+	digits, ok := strings.CutPrefix(name, "#")
+	if !ok {
+		return 0, false
+	}
+	number, err := strconv.Atoi(digits)
+	return number, err == nil && number > 0 && digits[0] != '+'
+}
+
+// targetRank orders targets from the broadest to the most specific:
+// allFields, then patterns, then field numbers and names
+func targetRank(name string) int {
+	if name == allFields {
+		return 0
+	}
+	if _, ok := targetPattern(name); ok {
+		return 1
+	}
+	return 2
+}
+
+// resolveTargets assigns the values annotations stored by field name in m to
+// the fields of a struct, broadest targets first (see targetRank) and then
+// in name order, so a more specific annotation is applied later and wins. A
+// name that matches more than one field is an error, since the annotation
+// would otherwise silently go to one of them. Patterns, field numbers and
+// allFields are returned when they match no field.
+func resolveTargets[V any](structType *ast.StructType, m map[string]V, goNames bool) (map[*ast.Field][]V, []string, error) {
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	slices.SortFunc(names, func(a, b string) int {
+		if rank := targetRank(a) - targetRank(b); rank != 0 {
+			return rank
+		}
+		return strings.Compare(a, b)
+	})
+
+	targets := make(map[*ast.Field][]V)
+	var unmatched []string
+	for _, name := range names {
+		matches := matchFields(structType, name, goNames)
+		if _, ok := targetNumber(name); ok || targetRank(name) < 2 {
+			if len(matches) == 0 {
+				unmatched = append(unmatched, name)
+			}
+		} else if len(matches) > 1 {
+			return nil, nil, fmt.Errorf("%q matches both %s and %s, target one of them by its exact name", name, fieldLabel(matches[0]), fieldLabel(matches[1]))
+		}
+		for _, field := range matches {
+			targets[field] = append(targets[field], m[name])
+		}
+	}
+	return targets, unmatched, nil
+}
+
+// fieldLabel names a field in messages: its Go name, or its type when
+// embedded
+func fieldLabel(field *ast.Field) string {
+	if len(field.Names) > 0 {
+		return field.Names[0].Name
+	}
+	return getEmbeddedStructName(field)
+}
+
+// tagRename moves the value of a field's tag from one key to another
+type tagRename struct {
+	Old  string
+	New  string
+	Line int // Line of the annotation, for warnings
+}
+
+// tagSpec holds the tags a @gotags annotation sets on a field
+type tagSpec struct {
+	Tags       string
+	Line       int  // Line of the annotation, for warnings
+	StructWide bool // Set for every field, see applyStructTags
+}
+
+// renameTag applies a tag rename to a field and reports whether its tag
+// changed. A value already under the new key is replaced. It warns when the
+// field has neither key, but not when only the new one is there, which is
+// what a rerun finds.
+func (p *Processor) renameTag(inputPath, name string, field *ast.Field, rename tagRename) bool {
+	existing, remainder := fieldTags(field)
+
+	oldIndex, newIndex := -1, -1
+	for i, tag := range existing {
+		switch tag.Key {
+		case rename.Old:
+			oldIndex = i
+		case rename.New:
+			newIndex = i
+		}
+	}
+	if oldIndex < 0 {
+		if newIndex < 0 {
+			p.warnAtf(inputPath, rename.Line, "%s: no %s tag to rename to %s", name, rename.Old, rename.New)
+		}
+		return false
+	}
+
+	var renamed []tagPair
+	for i, tag := range existing {
+		switch i {
+		case oldIndex:
+			renamed = append(renamed, tagPair{Key: rename.New, Value: tag.Value})
+		case newIndex:
+		default:
+			renamed = append(renamed, tag)
+		}
+	}
+	return setFieldTag(field, p.orderTags(renamed), remainder)
+}
+
+// tagOptionEdit adds (+name) or removes (-name) options of a field's tag
+type tagOptionEdit struct {
+	Key  string
+	Ops  []string
+	Line int // Line of the annotation, for warnings
+}
+
+// editTagOptions applies an option edit to a field and reports whether its
+// tag changed. Adding an option to a key the field doesn't have creates it.
+// A json-style "-" value, which drops the field, is left alone: "-,omitempty"
+// would name it "-" instead. The name is only used in warnings.
+func (p *Processor) editTagOptions(inputPath, name string, field *ast.Field, edit tagOptionEdit) bool {
+	existing, remainder := fieldTags(field)
+
+	gormStyle := edit.Key == "gorm" || p.MergeStrategies[edit.Key] == "gorm"
+	found := false
+	for i := range existing {
+		if existing[i].Key == edit.Key {
+			if !gormStyle && existing[i].Value == "-" {
+				p.warnAtf(inputPath, edit.Line, "%s: %s tag is \"-\", not editing its options", name, edit.Key)
+				return false
+			}
+			existing[i].Value = editOptions(existing[i].Value, gormStyle, edit.Ops)
+			found = true
+			break
+		}
+	}
+	if !found {
+		value := editOptions("", gormStyle, edit.Ops)
+		if value == "" {
+			return false
+		}
+		existing = append(existing, tagPair{Key: edit.Key, Value: value})
+	}
+	return setFieldTag(field, p.orderTags(existing), remainder)
+}
+
+// tagLiteral returns the literal a tag is written as: a raw string, unless
+// it contains a backquote, which only an interpreted string can hold
+// ("json:\"name\" doc:\"see `name`\"")
+func tagLiteral(tagValue string) string {
+	if strings.Contains(tagValue, "`") {
+		return strconv.Quote(tagValue)
+	}
+	return "`" + tagValue + "`"
+}
+
+// setFieldTag sets a field's tag to the given pairs followed by any
+// unparseable remainder of the old tag, and reports whether it changed
+func setFieldTag(field *ast.Field, tags []tagPair, remainder string) bool {
+	tagValue := formatTags(tags)
+	if remainder != "" {
+		tagValue = strings.TrimSpace(tagValue + " " + remainder)
+	}
+	literal := tagLiteral(tagValue)
+	changed := field.Tag == nil || field.Tag.Value != literal
+	field.Tag = &ast.BasicLit{
+		Kind:  token.STRING,
+		Value: literal,
+	}
+	return changed
+}
+
+// applyTags merges the tags of an annotation into a field's existing tag and
+// reports whether the tag changed. With override false, keys the field
+// already has are left alone. The name and the line of the annotation or
+// field the tags come from are only used in warnings.
+func (p *Processor) applyTags(inputPath, name string, line int, field *ast.Field, newTagStr string, override bool) bool {
+	// Parse existing and new tags
+	// Malformed tags are only reported when asked for, or when validating,
+	// where the annotation's content is the point
+	existingTags, remainder := fieldTags(field)
+	if remainder != "" && (p.Verbose || p.Validate) {
+		p.warnAtf(inputPath, line, "%s: existing tag has malformed content %q, keeping it as is", name, remainder)
+	}
+
+	newTags, malformed := parseTags(newTagStr)
+	if malformed != "" && (p.Verbose || p.Validate) {
+		p.warnAtf(inputPath, line, "%s: ignoring malformed @gotags content %q", name, malformed)
+	}
+
+	// Merge tags, new tags take precedence when overriding
+	mergedTags := mergeTags(existingTags, newTags, override, p.MergeStrategies)
+
+	// Set the combined tags, keeping any unparseable remainder
+	return setFieldTag(field, p.orderTags(mergedTags), remainder)
+}
+
+// applyStructTags merges tags set for every field of a struct, with
+// @gotags(*) or in its doc comment, into a field's tag and reports whether
+// it changed. Rather than replacing values, they add to them the way
+// @gotagopt does: json-style values keep the field's name, so
+// json:",omitempty" only adds the option, and gorm values are merged option
+// by option. A --merge strategy set for a key is used instead, and fields
+// whose json-style value is "-" are left alone.
+func (p *Processor) applyStructTags(inputPath, name string, line int, field *ast.Field, newTagStr string) bool {
+	existingTags, remainder := fieldTags(field)
+	newTags, malformed := parseTags(newTagStr)
+	if malformed != "" && (p.Verbose || p.Validate) {
+		p.warnAtf(inputPath, line, "%s: ignoring malformed @gotags content %q", name, malformed)
+	}
+
+	strategies := make(map[string]string)
+	var kept []tagPair
+	for _, tag := range newTags {
+		strategy, ok := p.MergeStrategies[tag.Key]
+		if !ok {
+			strategy = "json"
+			if tag.Key == "gorm" {
+				strategy = "gorm"
+			}
+		}
+		if strategy == "json" && slices.Contains(existingTags, tagPair{Key: tag.Key, Value: "-"}) {
+			continue
+		}
+		strategies[tag.Key] = strategy
+		kept = append(kept, tag)
+	}
+	return setFieldTag(field, p.orderTags(mergeTags(existingTags, kept, true, strategies)), remainder)
+}
+
+// embeddedFieldName returns the field name an embedded type is known by,
+// which is its unqualified type name (e.g. Reader for io.Reader)
+func embeddedFieldName(typeName string) string {
+	return typeName[strings.LastIndex(typeName, ".")+1:]
+}
+
+// resolveTypeName maps a message or enum name to a type declared in the
+// file. The name may be qualified with its proto package (mypkg.User), and
+// nested types (mypkg.Outer.Inner) map to their generated Go name
+// (Outer_Inner). Proto names are looked up in the file's registration
+// metadata first (registry, from protoTypeNames), which also finds types
+// whose Go name was mangled further, e.g. with a trailing _ to avoid a
+// conflict; files without it fall back to deriving the Go name. Type
+// aliases resolve to the type they name when it's declared in the file;
+// aliases of anything else only produce a warning. A name matching several
+// types is an error with StrictTypes, otherwise the most qualified match is
+// used.
+func (p *Processor) resolveTypeName(inputPath string, line int, name string, typeNames map[string]bool, aliases map[string]string, registry map[string]string) (string, error) {
+	if typeNames[name] {
+		return name, nil
+	}
+
+	if target, ok := aliases[name]; ok {
+		// Follow chains of aliases (type A = B; type B = C)
+		for i := 0; i < len(aliases); i++ {
+			next, ok := aliases[target]
+			if !ok {
+				break
+			}
+			target = next
+		}
+		if !typeNames[target] {
+			p.warnAtf(inputPath, line, "@gotype %s is an alias of %s, which is not a type declared in this file, so it can't receive injections", name, target)
+		}
+		return target, nil
+	}
+
+	// Otherwise try the name without each leading package segment in turn
+	candidates := registryCandidates(name, registry, typeNames)
+	if len(candidates) == 0 {
+		parts := strings.Split(strings.TrimPrefix(name, "."), ".")
+		for i := range parts {
+			if candidate := strings.Join(parts[i:], "_"); typeNames[candidate] {
+				candidates = append(candidates, candidate)
+			}
+		}
+	}
+
+	switch len(candidates) {
+	case 0:
+		p.warnAtf(inputPath, line, "no type matches @gotype %s", name)
+		return name, nil
+	case 1:
+		return candidates[0], nil
+	default:
+		// Prefer the most qualified match, unless --strict-types asks to
+		// stop instead of picking one
+		if p.StrictTypes {
+			return "", fmt.Errorf("@gotype %s is ambiguous between %s", name, strings.Join(candidates, ", "))
+		}
+		p.warnAtf(inputPath, line, "@gotype %s is ambiguous between %s, using %s",
+			name, strings.Join(candidates, ", "), candidates[0])
+		return candidates[0], nil
+	}
+}
+
+func (p *Processor) processFile(inputPath string) (fileStats, error) {
+	var stats fileStats
+
+	// Read the input file
+	src, err := os.ReadFile(inputPath)
+	if err != nil {
+		return stats, fmt.Errorf("failed to open file: %v", err)
+	}
+
+	// Parse the Go file
+	fset := token.NewFileSet()
+	astFile, err := parser.ParseFile(fset, inputPath, src, parser.ParseComments)
+	if err != nil {
+		return stats, fmt.Errorf("failed to parse file: %v", err)
+	}
+
+	// Collect the types declared in the file, which structs among them, and
+	// the lines they are declared on
+	typeNames := make(map[string]bool)
+	structNames := make(map[string]bool)
+	typeLines := make(map[int]string)
+	docLines := make(map[int]string) // Lines of the types' doc comments
+	aliases := make(map[string]string)
+	typeArgs := make(map[string]string) // Type parameters of generic types
+	for _, decl := range astFile.Decls {
+		if genDecl, ok := decl.(*ast.GenDecl); ok && genDecl.Tok == token.TYPE {
+			for _, spec := range genDecl.Specs {
+				if typeSpec, ok := spec.(*ast.TypeSpec); ok {
+					if typeSpec.Assign.IsValid() {
+						// Type alias (type Foo = Bar)
+						aliases[typeSpec.Name.Name] = types.ExprString(typeSpec.Type)
+					} else {
+						// Named types other than structs, like the int32 types of
+						// enums, can receive methods
+						typeNames[typeSpec.Name.Name] = true
+						if params := typeParamNames(typeSpec); params != "" {
+							typeArgs[typeSpec.Name.Name] = params
+						}
+						if _, ok := typeSpec.Type.(*ast.StructType); ok {
+							structNames[typeSpec.Name.Name] = true
+						}
+						typeLines[fset.Position(typeSpec.Pos()).Line] = typeSpec.Name.Name
+
+						// Annotations in the doc comment belong to the type too,
+						// which is the only place they fit for messages with no
+						// fields and for enums
+						doc := typeSpec.Doc
+						if doc == nil && len(genDecl.Specs) == 1 {
+							doc = genDecl.Doc
+						}
+						if doc != nil {
+							typeLines[fset.Position(doc.Pos()).Line] = typeSpec.Name.Name
+							for line := fset.Position(doc.Pos()).Line; line <= fset.Position(doc.End()).Line; line++ {
+								docLines[line] = typeSpec.Name.Name
+							}
+						}
+					}
+				}
+			}
+		}
+	}
+
+	// Collect comment text by line, so annotations are only read from real
+	// comments and never from string literals that merely look like them
+	commentLines := make(map[int][]string)
+	for _, group := range astFile.Comments {
+		for _, comment := range group.List {
+			line := fset.Position(comment.Pos()).Line
+			for i, text := range strings.Split(comment.Text, "\n") {
+				commentLines[line+i] = append(commentLines[line+i], text)
+			}
+		}
+	}
+
+	// Proto names of the file's types, for @gotype
+	registry := protoTypeNames(fset, astFile)
+
+	// Create maps to store unique imports and fields
+	var imports []importEntry
+	fields := make(map[string][]fieldSpec)
+	tags := make(map[string]map[string][]tagSpec)
+	envPrefixes := make(map[string]string)
+	gatewayTypes := make(map[string]bool)
+	methods := make(map[string][]*ast.FuncDecl)
+	assertions := make(map[string][]*ast.GenDecl)
+	jsonTemplates := make(map[string]*template.Template)
+	constructors := make(map[string]constructorSpec)
+	var customs []customAnnotation
+	var typeAnnotations []unusedEntry // For --report-unused
+	renames := make(map[string]map[string][]tagRename)
+	optionEdits := make(map[string]map[string][]tagOptionEdit)
+
+	// Process annotations
+	goTypeStr := ""
+	injected := false
+	lineNum := 0
+	scanner := bufio.NewScanner(bytes.NewReader(src))
+	for scanner.Scan() {
+		line := scanner.Text()
+		lineNum++
+
+		var annotations []Annotation
+		if typeName, ok := typeLines[lineNum]; ok {
+			annotations = append(annotations, Annotation{Type: "gotype", Content: typeName})
+		}
+		for _, comment := range commentLines[lineNum] {
+			annotations = append(annotations, parseAnnotations(comment, p.Prefix)...)
+			annotations = append(annotations, p.parseCustomAnnotations(comment)...)
+		}
+		if len(annotations) == 0 {
+			continue
+		}
+		annotations, err = p.expandTemplates(annotations)
+		if err != nil {
+			return stats, fmt.Errorf("line %d: %v", lineNum, err)
+		}
+		for _, ann := range annotations {
+			if ann.Condition != "" && !p.Conditions[ann.Condition] {
+				continue
+			}
+			if p.Only != nil && ann.Type != "gotype" && goTypeStr != "" && !p.Only[goTypeStr] {
+				continue
+			}
+			if ann.Type != "gotype" {
+				injected = true
+			}
+			if p.ReportUnused != "" && goTypeStr != "" && ann.Type != "gotype" && ann.Type != "goimport" && ann.Type != "gotemplate" {
+				// Annotations of types are checked for --report-unused once
+				// they're applied; those skipped above aren't, since whether
+				// they'd apply isn't known
+				entry := ann.Content
+				if ann.Target != "" {
+					entry = strings.TrimSpace("(" + ann.Target + ") " + entry)
+				}
+				typeAnnotations = append(typeAnnotations, unusedEntry{File: inputPath, Struct: goTypeStr, Line: lineNum, Kind: strings.TrimPrefix(ann.Type, "go"), Entry: entry})
+			}
+			if h, ok := p.handlers[strings.TrimPrefix(ann.Type, "go")]; ok {
+				customs = append(customs, customAnnotation{Handler: h, Context: AnnotationContext{
+					File:     inputPath,
+					Fset:     fset,
+					Line:     lineNum,
+					TypeName: goTypeStr,
+					Target:   ann.Target,
+					Content:  ann.Content,
+				}})
+				continue
+			}
+			switch ann.Type {
+			case "goimport":
+				imp, err := importAnnotation(ann, lineNum)
+				if err != nil {
+					return stats, fmt.Errorf("line %d: %v", lineNum, err)
+				}
+				imports = addImportEntry(imports, imp)
+			case "gotype":
+				typeName, err := p.resolveTypeName(inputPath, lineNum, ann.Content, typeNames, aliases, registry)
+				if err != nil {
+					return stats, fmt.Errorf("line %d: %v", lineNum, err)
+				}
+				goTypeStr = typeName
+				// Keep what an earlier @gotype for the same struct collected
+				if tags[goTypeStr] == nil {
+					tags[goTypeStr] = make(map[string][]tagSpec)
+				}
+			case "gofield", "goprimarykey", "goreplacefield", "gotags", "gotagopt", "gorenametag", "goenv", "gogateway", "govalidate", "gomethod", "goimplement", "gojson", "goconstructor":
+				if goTypeStr == "" {
+					p.warnAtf(inputPath, lineNum, "ignoring @%s: %s outside of any struct", ann.Type, ann.Content)
+					continue
+				}
+				if ann.Type != "govalidate" && ann.Type != "gomethod" && ann.Type != "goimplement" && ann.Type != "gojson" && typeNames[goTypeStr] && !structNames[goTypeStr] {
+					p.warnAtf(inputPath, lineNum, "ignoring @%s: %s, %s is not a struct", ann.Type, ann.Content, goTypeStr)
+					continue
+				}
+			}
+			switch ann.Type {
+			case "goenv":
+				envPrefixes[goTypeStr] = ann.Content
+			case "gogateway":
+				gatewayTypes[goTypeStr] = true
+			case "goconstructor":
+				// Created with the methods, once fields are injected; the first
+				// annotation wins
+				if _, ok := constructors[goTypeStr]; !ok {
+					constructors[goTypeStr] = constructorAnnotation(ann, lineNum)
+				}
+			case "gojson":
+				// The methods are created once fields and tags are injected, so
+				// the template sees the final fields; the first template wins
+				if jsonTemplates[goTypeStr] != nil {
+					break
+				}
+				tmpl, err := loadJSONTemplate(inputPath, ann.Content)
+				if err != nil {
+					return stats, fmt.Errorf("line %d: invalid @gojson %q: %v", lineNum, ann.Content, err)
+				}
+				jsonTemplates[goTypeStr] = tmpl
+			case "govalidate", "gomethod", "goimplement":
+				// A generic type has no single instantiation to check
+				// interfaces with
+				recv := receiverType(goTypeStr, structNames, typeArgs)
+				check := structNames[goTypeStr] && typeArgs[goTypeStr] == ""
+				typeMethods, assertion, paths, err := p.methodAnnotation(inputPath, fset, astFile, ann, lineNum, recv, check)
+				if err != nil {
+					return stats, fmt.Errorf("line %d: %v", lineNum, err)
+				}
+				for _, path := range paths {
+					if !slices.ContainsFunc(imports, func(imp importEntry) bool { return imp.Path == path }) {
+						imports = append(imports, importEntry{Path: path, Line: lineNum})
+					}
+				}
+				if assertion != nil {
+					assertions[goTypeStr] = append(assertions[goTypeStr], assertion)
+				}
+				for _, method := range typeMethods {
+					methods[goTypeStr] = addMethod(methods[goTypeStr], method)
+				}
+			case "gofield", "goprimarykey", "goreplacefield":
+				spec, err := p.fieldAnnotation(ann, lineNum)
+				if err != nil {
+					return stats, fmt.Errorf("line %d: %v", lineNum, err)
+				}
+				fields[goTypeStr] = addFieldSpec(fields[goTypeStr], spec)
+			case "gotags", "gotagopt", "gorenametag":
+				fieldName, err := p.tagAnnotationTarget(ann, line, lineNum, goTypeStr, docLines)
+				if err != nil {
+					return stats, fmt.Errorf("line %d: %v", lineNum, err)
+				}
+				if fieldName == "" {
+					break
+				}
+				switch ann.Type {
+				case "gotags":
+					if key := p.disallowedTagKey(ann.Content); key != "" {
+						return stats, fmt.Errorf("line %d: @gotags for %s.%s sets tag key %q, which is not in --allowed-tags", lineNum, goTypeStr, fieldName, key)
+					}
+					// Every annotation for the field is kept, from all blocks of
+					// the struct; later ones are applied later and win
+					tags[goTypeStr][fieldName] = append(tags[goTypeStr][fieldName], tagSpec{Tags: strings.TrimSpace(ann.Content), Line: lineNum, StructWide: fieldName == allFields})
+				case "gotagopt":
+					fields := strings.Fields(ann.Content)
+					if optionEdits[goTypeStr] == nil {
+						optionEdits[goTypeStr] = make(map[string][]tagOptionEdit)
+					}
+					optionEdits[goTypeStr][fieldName] = append(optionEdits[goTypeStr][fieldName], tagOptionEdit{Key: fields[0], Ops: fields[1:], Line: lineNum})
+				case "gorenametag":
+					rename, err := p.tagRenameAnnotation(ann, lineNum, goTypeStr+"."+fieldName)
+					if err != nil {
+						return stats, fmt.Errorf("line %d: %v", lineNum, err)
+					}
+					if renames[goTypeStr] == nil {
+						renames[goTypeStr] = make(map[string][]tagRename)
+					}
+					renames[goTypeStr][fieldName] = append(renames[goTypeStr][fieldName], rename)
+				}
+			}
+		}
+	}
+
+	if p.Debug {
+		p.debugDump(inputPath, imports, fields, tags)
+	}
+
+	// A marker without annotations means the file was regenerated and the
+	// injections made by an earlier run are gone
+	hasMarker := bytes.Contains(src, []byte(enhancedMarker))
+	if hasMarker && !injected {
+		p.warnf(inputPath, "file was enhanced before but has no annotations left, injections may have been lost")
+	}
+
+	if p.NormalizeImports {
+		stats.CleanedImports += p.normalizeImports(inputPath, astFile)
+	}
+
+	// With --warn-import-cycles, imports are compared with the import path
+	// of the file's own package
+	var ownPkg *ownPackage
+	if p.WarnImportCycles && len(imports) > 0 {
+		if ownPkg, err = findOwnPackage(inputPath); err != nil {
+			return stats, fmt.Errorf("failed to find the file's module: %v", err)
+		}
+		if ownPkg == nil {
+			p.warnf(inputPath, "not in a Go module, imports can't be checked for cycles")
+		}
+	}
+
+	// Add new imports, except those only wanted when used, which have to
+	// wait for the fields and methods
+	for _, imp := range imports {
+		if imp.IfUsed {
+			continue
+		}
+		if p.addImport(inputPath, fset, astFile, imp) {
+			stats.Imports++
+			if ownPkg != nil {
+				p.checkImportCycle(inputPath, ownPkg, imp)
+			}
+		}
+	}
+
+	// Tags for the cases of oneofs go to the fields of their wrapper structs
+	moveOneofTargets(astFile, tags, p.GoNames)
+	moveOneofTargets(astFile, renames, p.GoNames)
+	moveOneofTargets(astFile, optionEdits, p.GoNames)
+
+	// Process type declarations and add fields/tags. Defaults of injected
+	// fields are noted once the file is printed.
+	var usedPackages []string
+	var unused []unusedEntry
+	defaults := make(map[string]map[string]string)
+	for _, decl := range astFile.Decls {
+		if genDecl, ok := decl.(*ast.GenDecl); ok && genDecl.Tok == token.TYPE {
+			for _, spec := range genDecl.Specs {
+				if typeSpec, ok := spec.(*ast.TypeSpec); ok {
+					if structType, ok := typeSpec.Type.(*ast.StructType); ok {
+						// Get the struct name
+						structName := typeSpec.Name.Name
+
+						// Add new fields
+						existingFields := make(map[string]bool)
+						for _, field := range structType.Fields.List {
+							if len(field.Names) > 0 {
+								// Fields declared together (A, B int) share an ast.Field
+								for _, ident := range field.Names {
+									existingFields[ident.Name] = true
+								}
+							} else {
+								// Handle embedded struct or interface, which is known both
+								// by its type (io.Reader) and its field name (Reader)
+								if embeddedName := getEmbeddedStructName(field); embeddedName != "" {
+									existingFields[embeddedName] = true
+									existingFields[embeddedFieldName(embeddedName)] = true
+								}
+							}
+						}
+						for _, spec := range fields[structName] {
+							field := createFieldFromString(spec.Decl)
+							if field != nil && spec.Tag != "" {
+								field.Tag = &ast.BasicLit{Kind: token.STRING, Value: tagLiteral(spec.Tag)}
+							}
+							if field != nil {
+								fieldName := ""
+								if len(field.Names) > 0 {
+									fieldName = field.Names[0].Name
+								} else {
+									fieldName = getEmbeddedStructName(field)
+								}
+
+								// Check for duplicates
+								isDuplicate := false
+								if fieldName != "" && (existingFields[fieldName] || existingFields[embeddedFieldName(fieldName)]) {
+									isDuplicate = true
+								}
+
+								if isDuplicate && spec.Replace {
+									// The type is swapped and the tags given merged into the
+									// field's, keeping its protobuf tag
+									if replaced, changed := replaceField(fset, astFile.Comments, structType, field); replaced != nil {
+										if spec.Tag != "" && p.applyTags(inputPath, structName+"."+fieldName, spec.Line, replaced, spec.Tag, true) {
+											changed = true
+										}
+										if changed {
+											stats.Fields++
+										}
+										for _, pkg := range referencedPackages(replaced.Type) {
+											if !slices.Contains(usedPackages, pkg) {
+												usedPackages = append(usedPackages, pkg)
+											}
+										}
+									}
+								} else if isDuplicate {
+									if conflict := conflictingField(structType, field); conflict != nil {
+										existing := "embeds " + types.ExprString(conflict.Type)
+										if len(conflict.Names) > 0 {
+											existing = "has " + embeddedFieldName(fieldLabel(field)) + " " + types.ExprString(conflict.Type)
+										}
+										// @goreplacefield only swaps the types of fields of the
+										// same kind, named or embedded
+										if len(conflict.Names) > 0 == (len(field.Names) > 0) {
+											existing += "; use @goreplacefield to change it"
+										}
+										p.warnAtf(inputPath, spec.Line, "not adding %s to %s, it already %s", spec.Decl, structName, existing)
+									}
+								} else {
+									index := spec.Index
+									if spec.Placement != "" {
+										index = findField(structType, spec.Anchor, p.GoNames)
+										if index < 0 {
+											p.warnAtf(inputPath, spec.Line, "field %s not found in %s, appending %s", spec.Anchor, structName, spec.Decl)
+										} else if spec.Placement == "after" {
+											index++
+										}
+									}
+									insertField(fset, astFile.Comments, structType, field, index)
+									stats.Fields++
+									if spec.Default != "" {
+										if defaults[structName] == nil {
+											defaults[structName] = make(map[string]string)
+										}
+										defaults[structName][fieldLabel(field)] = spec.Default
+									}
+									for _, pkg := range referencedPackages(field.Type) {
+										if !slices.Contains(usedPackages, pkg) {
+											usedPackages = append(usedPackages, pkg)
+										}
+									}
+									if fieldName != "" {
+										existingFields[fieldName] = true
+										existingFields[embeddedFieldName(fieldName)] = true
+									}
+								}
+							}
+						}
+
+						// Update tags
+						for _, name := range slices.Sorted(maps.Keys(tags[structName])) {
+							if len(matchFields(structType, name, p.GoNames)) == 0 {
+								for _, spec := range tags[structName][name] {
+									unused = append(unused, unusedEntry{File: inputPath, Struct: structName, Line: spec.Line, Kind: "tags", Entry: "(" + name + ") " + spec.Tags, Reason: "matches no field"})
+								}
+							}
+						}
+						for _, name := range slices.Sorted(maps.Keys(renames[structName])) {
+							if len(matchFields(structType, name, p.GoNames)) == 0 {
+								for _, rename := range renames[structName][name] {
+									unused = append(unused, unusedEntry{File: inputPath, Struct: structName, Line: rename.Line, Kind: "renametag", Entry: "(" + name + ") " + rename.Old + " " + rename.New, Reason: "matches no field"})
+								}
+							}
+						}
+						for _, name := range slices.Sorted(maps.Keys(optionEdits[structName])) {
+							if len(matchFields(structType, name, p.GoNames)) == 0 {
+								for _, edit := range optionEdits[structName][name] {
+									unused = append(unused, unusedEntry{File: inputPath, Struct: structName, Line: edit.Line, Kind: "tagopt", Entry: "(" + name + ") " + edit.Key + " " + strings.Join(edit.Ops, " "), Reason: "matches no field"})
+								}
+							}
+						}
+						tagTargets, unmatched, err := resolveTargets(structType, tags[structName], p.GoNames)
+						if err != nil {
+							return stats, fmt.Errorf("%s: @gotags %v", structName, err)
+						}
+						for _, name := range unmatched {
+							p.warnAtf(inputPath, tags[structName][name][0].Line, "@gotags(%s) matches no field of %s", name, structName)
+						}
+						renameTargets, unmatched, err := resolveTargets(structType, renames[structName], p.GoNames)
+						if err != nil {
+							return stats, fmt.Errorf("%s: @gorenametag %v", structName, err)
+						}
+						for _, name := range unmatched {
+							p.warnAtf(inputPath, renames[structName][name][0].Line, "@gorenametag(%s) matches no field of %s", name, structName)
+						}
+						editTargets, unmatched, err := resolveTargets(structType, optionEdits[structName], p.GoNames)
+						if err != nil {
+							return stats, fmt.Errorf("%s: @gotagopt %v", structName, err)
+						}
+						for _, name := range unmatched {
+							p.warnAtf(inputPath, optionEdits[structName][name][0].Line, "@gotagopt(%s) matches no field of %s", name, structName)
+						}
+						for _, field := range structType.Fields.List {
+							fieldName := ""
+							if len(field.Names) > 0 {
+								fieldName = field.Names[0].Name
+							} else {
+								fieldName = getEmbeddedStructName(field)
+							}
+							fieldLine := fset.Position(field.Pos()).Line
+							changed := false
+							for _, rename := range slices.Concat(renameTargets[field]...) {
+								if p.renameTag(inputPath, structName+"."+fieldName, field, rename) {
+									changed = true
+								}
+							}
+							if wktTagStr := p.wktTags(field); wktTagStr != "" && fieldName != "" {
+								// Well-known type tags never replace tags the field has
+								if p.applyTags(inputPath, structName+"."+fieldName, fieldLine, field, wktTagStr, false) {
+									changed = true
+								}
+							}
+							if typeTagStr := p.typeTags(astFile, field); typeTagStr != "" && len(field.Names) > 0 {
+								// Defaults never replace tags the field has
+								if p.applyTags(inputPath, structName+"."+fieldName, fieldLine, field, typeTagStr, false) {
+									changed = true
+								}
+							}
+							if prefix, ok := envPrefixes[structName]; ok && len(field.Names) > 0 && field.Names[0].IsExported() {
+								// Derived env tags never replace one the field has, and
+								// @gotags below can still override them
+								envTag := fmt.Sprintf(`env:"%s%s"`, prefix, strings.ToUpper(toSnakeCase(protoFieldName(field))))
+								if p.applyTags(inputPath, structName+"."+fieldName, fieldLine, field, envTag, false) {
+									changed = true
+								}
+							}
+							if gatewayTypes[structName] && len(field.Names) > 0 && field.Names[0].IsExported() {
+								// Like env tags, before @gotags can override them
+								if p.gatewayTags(inputPath, structName+"."+fieldName, fieldLine, field) {
+									changed = true
+								}
+							}
+							for _, spec := range slices.Concat(tagTargets[field]...) {
+								if spec.StructWide {
+									if p.applyStructTags(inputPath, structName+"."+fieldName, spec.Line, field, spec.Tags) {
+										changed = true
+									}
+								} else if p.applyTags(inputPath, structName+"."+fieldName, spec.Line, field, spec.Tags, true) {
+									changed = true
+								}
+							}
+							for _, edit := range slices.Concat(editTargets[field]...) {
+								if p.editTagOptions(inputPath, structName+"."+fieldName, field, edit) {
+									changed = true
+								}
+							}
+							if yamlTag := p.yamlTag(field); yamlTag != "" {
+								// Mirrored after @gotags so it sees the final json name,
+								// and never replacing a yaml tag the field has
+								if p.applyTags(inputPath, structName+"."+fieldName, fieldLine, field, yamlTag, false) {
+									changed = true
+								}
+							}
+							if changed {
+								stats.Tags++
+							}
+						}
+					}
+				}
+			}
+		}
+	}
+
+	// Add methods after all other declarations, in the order of the types
+	// they belong to
+	var newDecls []ast.Decl
+	for _, decl := range astFile.Decls {
+		if genDecl, ok := decl.(*ast.GenDecl); ok && genDecl.Tok == token.TYPE {
+			for _, spec := range genDecl.Specs {
+				if typeSpec, ok := spec.(*ast.TypeSpec); ok {
+					if tmpl := jsonTemplates[typeSpec.Name.Name]; tmpl != nil {
+						structType, _ := typeSpec.Type.(*ast.StructType)
+						created, err := createJSONMethods(fset, tmpl, typeSpec.Name.Name, receiverType(typeSpec.Name.Name, structNames, typeArgs), structType)
+						if err != nil {
+							return stats, fmt.Errorf("invalid @gojson for %s: %v", typeSpec.Name.Name, err)
+						}
+						for _, method := range created {
+							// Methods given with @gomethod take precedence
+							if !slices.ContainsFunc(methods[typeSpec.Name.Name], func(existing *ast.FuncDecl) bool { return existing.Name.Name == method.Name.Name }) {
+								methods[typeSpec.Name.Name] = append(methods[typeSpec.Name.Name], method)
+							}
+						}
+					}
+					for _, assertion := range assertions[typeSpec.Name.Name] {
+						// Assertions are counted with the methods they check
+						if !hasDecl(astFile, assertion) {
+							newDecls = append(newDecls, assertion)
+							stats.Methods++
+						}
+					}
+					if spec, ok := constructors[typeSpec.Name.Name]; ok && !hasFunc(astFile, "New"+typeSpec.Name.Name) {
+						// An existing function of the same name wins
+						structType, _ := typeSpec.Type.(*ast.StructType)
+						// Injected fields are set by it even when unexported
+						injected := make(map[string]bool)
+						for _, fieldSpec := range fields[typeSpec.Name.Name] {
+							if field := createFieldFromString(fieldSpec.Decl); field != nil {
+								for _, ident := range field.Names {
+									injected[ident.Name] = true
+								}
+								if len(field.Names) == 0 {
+									injected[embeddedFieldName(fieldLabel(field))] = true
+								}
+							}
+						}
+						constructor, err := createConstructor(fset, astFile, typeSpec, structType, spec, injected, p.GoNames)
+						if err != nil {
+							return stats, fmt.Errorf("line %d: invalid @goconstructor: %v", spec.Line, err)
+						}
+						newDecls = append(newDecls, constructor)
+						stats.Methods++
+					}
+					for _, method := range methods[typeSpec.Name.Name] {
+						// An existing method wins, which also keeps reruns idempotent
+						if hasMethod(astFile, typeSpec.Name.Name, method.Name.Name) {
+							continue
+						}
+						newDecls = append(newDecls, method)
+						stats.Methods++
+						if p.ExternalMethods {
+							// The companion file imports what its methods use
+							continue
+						}
+						for _, pkg := range referencedPackages(method) {
+							if !slices.Contains(usedPackages, pkg) {
+								usedPackages = append(usedPackages, pkg)
+							}
+						}
+					}
+				}
+			}
+		}
+	}
+
+	// Add the imports of @goimport(used) whose package the injected fields
+	// or methods refer to, by the name they'd be imported under
+	for _, imp := range imports {
+		if imp.IfUsed && slices.Contains(usedPackages, importName(imp.Alias, imp.Path)) {
+			if p.addImport(inputPath, fset, astFile, imp) {
+				stats.Imports++
+				if ownPkg != nil {
+					p.checkImportCycle(inputPath, ownPkg, imp)
+				}
+			}
+		}
+	}
+
+	// Import standard library packages that injected fields use but the file
+	// doesn't import yet, e.g. sync for an embedded sync.Mutex
+	for _, pkg := range usedPackages {
+		if path, ok := stdlibPackages[pkg]; ok && !importsName(astFile, pkg) {
+			if p.addImport(inputPath, fset, astFile, importEntry{Path: path}) {
+				stats.Imports++
+			}
+		}
+	}
+
+	// Apply custom annotations last, so their handlers see the result of the
+	// built-in ones
+	for _, custom := range customs {
+		if err := custom.Handler.Apply(&custom.Context, astFile); err != nil {
+			return stats, fmt.Errorf("line %d: @go%s: %v", custom.Context.Line, custom.Handler.Type(), err)
+		}
+		stats.Custom++
+	}
 
-	if match := goimportRe.FindStringSubmatch(line); len(match) > 1 {
-		annotations = append(annotations, Annotation{Type: "goimport", Content: match[1]})
+	// A file with nothing to apply usually lacks its annotations; reruns
+	// still find the annotations applied before, so they pass
+	if p.RequireAnnotations && !injected && !stats.changed() {
+		return stats, fmt.Errorf("no annotations to apply, and no option changed the file")
 	}
-	if match := gofieldRe.FindStringSubmatch(line); len(match) > 1 {
-		annotations = append(annotations, Annotation{Type: "gofield", Content: match[1]})
+
+	// Note the entries that no longer apply to anything: annotations of
+	// types the file doesn't declare (anymore), and imports nothing uses
+	if p.ReportUnused != "" {
+		for _, entry := range typeAnnotations {
+			if !typeNames[entry.Struct] {
+				entry.Reason = "no such type in the file"
+				unused = append(unused, entry)
+			}
+		}
+		referenced := referencedPackages(astFile)
+		for _, decl := range newDecls {
+			referenced = append(referenced, referencedPackages(decl)...)
+		}
+		for _, imp := range imports {
+			name := importName(imp.Alias, imp.Path)
+			if name == "_" || name == "." || slices.Contains(referenced, name) {
+				continue
+			}
+			reason := "nothing in the file uses it"
+			if imp.IfUsed {
+				reason = "no injected field or method uses it"
+			}
+			unused = append(unused, unusedEntry{File: inputPath, Line: imp.Line, Kind: "import", Entry: strconv.Quote(imp.Path), Reason: reason})
+		}
+		p.recordUnused(unused)
 	}
-	if match := gotagsRe.FindStringSubmatch(line); len(match) > 1 {
-		annotations = append(annotations, Annotation{Type: "gotags", Content: match[1]})
+
+	// With --external-methods, the new declarations go to a companion file
+	// instead, which is only the tool's to write
+	var companion []byte
+	if p.ExternalMethods {
+		if _, err := readCompanion(inputPath); err != nil {
+			return stats, err
+		}
+		if len(newDecls) > 0 {
+			if companion, err = renderCompanion(fset, astFile, imports, newDecls); err != nil {
+				return stats, fmt.Errorf("failed to format companion file: %v", err)
+			}
+			newDecls = nil
+		}
 	}
-	if match := gotypeRe.FindStringSubmatch(line); len(match) > 1 {
-		annotations = append(annotations, Annotation{Type: "gotype", Content: match[1]})
+
+	if p.Validate {
+		return stats, nil
 	}
 
-	return annotations
+	// Render the whole result before writing anything, so a printer error
+	// (e.g. from a malformed injected node) leaves no partial output behind
+	var buf bytes.Buffer
+	if err := formatNode(&buf, fset, astFile); err != nil {
+		return stats, fmt.Errorf("failed to format output: %v", err)
+	}
+
+	// Methods are printed on their own, since their positions come from a
+	// separate source and would not interleave with the file's comments
+	for _, decl := range newDecls {
+		buf.WriteString("\n")
+		if err := formatNode(&buf, fset, decl); err != nil {
+			return stats, fmt.Errorf("failed to format output: %v", err)
+		}
+		buf.WriteString("\n")
+	}
+	out := separateImports(buf.Bytes())
+	if len(defaults) > 0 {
+		if out, err = addDefaultComments(out, defaults); err != nil {
+			return stats, fmt.Errorf("failed to add default comments: %v", err)
+		}
+	}
+	if p.AlignTags {
+		if out, err = alignTags(out); err != nil {
+			return stats, fmt.Errorf("failed to align tags: %v", err)
+		}
+	}
+	if p.PreserveFormatting {
+		// Compare with the untouched file as the printer formats it, to tell
+		// the printer's changes from the injections
+		origFset := token.NewFileSet()
+		origFile, err := parser.ParseFile(origFset, inputPath, src, parser.ParseComments)
+		if err != nil {
+			return stats, fmt.Errorf("failed to parse file: %v", err)
+		}
+		var formatted bytes.Buffer
+		if err := format.Node(&formatted, origFset, origFile); err != nil {
+			return stats, fmt.Errorf("failed to format output: %v", err)
+		}
+		out = preserveFormatting(bytes.TrimPrefix(src, utf8BOM), formatted.Bytes(), out)
+	}
+
+	// Mark files that received injections so a later run can tell when
+	// regeneration dropped them. Files whose injections all went to their
+	// companion are left as generated.
+	inline := stats
+	if p.ExternalMethods {
+		inline.Methods = 0
+	}
+	if injected && !hasMarker && (!p.ExternalMethods || inline.changed()) {
+		out = addEnhancedMarker(out)
+	}
+
+	// Keep a leading UTF-8 byte order mark, which the printer drops, so the
+	// file's byte prefix is unchanged
+	if bytes.HasPrefix(src, utf8BOM) {
+		out = append(append([]byte(nil), utf8BOM...), out...)
+	}
+
+	// Write the intermediate files, removing them if that fails half way so
+	// they aren't mistaken for results
+	if companion != nil {
+		companionOut := companionPath(inputPath) + p.Suffix
+		if err := os.WriteFile(companionOut, companion, 0644); err != nil {
+			os.Remove(companionOut)
+			return stats, fmt.Errorf("failed to write companion file: %v", err)
+		}
+	}
+	outPath := inputPath + p.Suffix
+	if err := os.WriteFile(outPath, out, 0644); err != nil {
+		os.Remove(outPath)
+		os.Remove(companionPath(inputPath) + p.Suffix)
+		return stats, fmt.Errorf("failed to write output: %v", err)
+	}
+
+	return stats, nil
 }
 
-func createFieldFromString(fieldStr string) *ast.Field {
-	parts := strings.Fields(fieldStr)
-	if len(parts) == 1 { // Embedded type
-		return &ast.Field{
-			Type: ast.NewIdent(parts[0]),
+func printHelp() {
+	fmt.Println("protoc-go-inject - A tool to inject custom annotations into protobuf-generated Go files")
+	fmt.Println("\nUsage:")
+	fmt.Println("  protoc-go-inject [options] <.go files...>")
+	fmt.Println("\nOptions:")
+	fmt.Println("  -h, --help     Show this help message")
+	fmt.Println("  -v, --verbose  Print warnings, e.g. about malformed tags, errors as they happen and the imports added")
+	fmt.Println("  --log-format   Output format for processing events: text (default) or json")
+	fmt.Println("  --files-from   Read the files to process from a list, one path per line")
+	fmt.Println("  -j             Number of files to process in parallel (default 1)")
+	fmt.Println("  --max-parse    Number of files parsed at once, to bound memory, when lower than -j (default -j)")
+	fmt.Println("  -r             Process the *.pb.go files under directory arguments, skipping paths ignored by .gitignore")
+	fmt.Println("  --include      With -r, process the files matching these globs instead, e.g. '*.go' (repeatable)")
+	fmt.Println("  --root         Treat the arguments as patterns of files under this directory, with ** matching any directories")
+	fmt.Println("  --no-gitignore With -r or --root, also process files ignored by .gitignore")
+	fmt.Println("  --fail-on-warning  Exit non-zero if any warning was emitted")
+	fmt.Println("  --no-color     Don't color output on terminals (also disabled by NO_COLOR)")
+	fmt.Println("  --prefix       Annotation prefix replacing @go, e.g. @inject_ for @inject_tags (default @go)")
+	fmt.Println("  --debug        Print the annotations collected from each file to stderr")
+	fmt.Println("  --suffix       Suffix of the intermediate file written next to each input (default .enhanced)")
+	fmt.Println("  --preserve-formatting  Only rewrite the lines that changed, keeping the rest of a non-gofmt'd file as is")
+	fmt.Println("  --extract      Print the annotations that would reproduce the hand-made fields and tags of each file's messages")
+	fmt.Println("  --validate     Only check the annotations, failing on errors and warnings, without modifying any file")
+	fmt.Println("  --report-unused  List annotation entries that no longer apply, e.g. to renamed fields, to a file (.json for JSON) or - for stdout")
+	fmt.Println("  --print-config Print the settings the options given result in, and the files they select, then exit")
+	fmt.Println("  --require-annotations  Fail files without annotations that no option changed either")
+	fmt.Println("  --name-fallback  Names targeting fields without a protobuf name, in order: json,go (default) or go,json")
+	fmt.Println("  --go-names     Match annotation targets by Go field names only, ignoring proto names in protobuf tags")
+	fmt.Println("  --strict-types Fail a file when a @gotype matches several types, instead of using the most qualified")
+	fmt.Println("  --warn-import-cycles  Warn about added imports of packages in the file's own module, which may create a cycle")
+	fmt.Println("  --normalize-imports  Remove duplicate imports the file already has and requote paths written in backquotes")
+	fmt.Println("  --goimports    Format the result like goimports, to also group and prune imports")
+	fmt.Println("  --align-tags   Line up all tags of a struct on one column, beyond what gofmt aligns")
+	fmt.Println("  --conditions   Enable annotations gated by these conditions, e.g. gorm,sql (repeatable)")
+	fmt.Println("  --only         Only apply the annotations of these types, e.g. User,Order (repeatable)")
+	fmt.Println("  --allowed-tags Fail on annotations setting tag keys other than these, e.g. json,gorm,validate (repeatable)")
+	fmt.Println("  --external-methods  Write injected methods and constructors to a companion <name>_inject.go instead of the file")
+	fmt.Println("  --tag-order    Write tag keys in this order, e.g. json,gorm,protobuf; others follow alphabetically")
+	fmt.Println("  --merge        Merge a tag key's values instead of replacing them, e.g. gorm=gorm or json=json (repeatable)")
+	fmt.Println("  --patch        Write a unified diff of all changes to this file instead of modifying the inputs")
+	fmt.Println("  --out          Write the processed files to this directory, under their paths relative to the working directory, instead of modifying the inputs")
+	fmt.Println("  --rename-pattern  With --out, rename files by a regexp=replacement on their base names, e.g. '\\.pb\\.go$=.gen.go'")
+	fmt.Println("  --wkt-tags     Tag fields of well-known proto types, e.g. *timestamppb.Timestamp, with gorm:\"serializer:json\"")
+	fmt.Println("  --wkt-tag      Set the tags for a type, e.g. 'timestamppb.Timestamp=gorm:\"type:timestamptz\"' (repeatable, implies --wkt-tags)")
+	fmt.Println("  --type-tag     Default tags for every field of a type, e.g. 'time.Time=gorm:\"type:timestamptz\"' (repeatable)")
+	fmt.Println("  --type-tags    Read --type-tag rules from a file, one Type=tags per line")
+	fmt.Println("  --templates    Read the named templates @gotemplate expands from a file (repeatable)")
+	fmt.Println("  --yaml-tags    Add a yaml tag named like the json tag to every field that has one")
+	fmt.Println("  --yaml-case    Casing of the yaml tag names: json (as is), snake, camel or lower (implies --yaml-tags)")
+	fmt.Println("\nExample:")
+	fmt.Println("  protoc-go-inject a.pb.go b.pb.go")
+	fmt.Println("\nSupported Annotations:")
+	for i, spec := range SupportedAnnotations {
+		if i > 0 {
+			fmt.Println()
 		}
-	} else if len(parts) >= 2 { // Named field with type
-		return &ast.Field{
-			Names: []*ast.Ident{ast.NewIdent(parts[0])},
-			Type:  ast.NewIdent(parts[1]),
+		fmt.Printf("  @%s: %s\n", spec.Name, spec.Description)
+		fmt.Printf("    Syntax:  %s\n", spec.Syntax)
+		for _, example := range spec.Examples {
+			fmt.Printf("    Example: %s\n", example)
 		}
 	}
-	return nil
 }
 
-// parseTags parses a Go struct tag string into a map of key-value pairs
-func parseTags(tagStr string) map[string]string {
-	tags := make(map[string]string)
-	tagStr = strings.Trim(tagStr, "`")
+// onlyFlag collects the comma-separated type names given with --only, which
+// may be given more than once
+type onlyFlag struct {
+	p *Processor
+}
+
+func (f onlyFlag) String() string {
+	return ""
+}
+
+func (f onlyFlag) Set(value string) error {
+	if f.p.Only == nil {
+		f.p.Only = make(map[string]bool)
+	}
+	for _, name := range strings.Split(value, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			f.p.Only[name] = true
+		}
+	}
+	return nil
+}
 
-	// Use regex to find key-value pairs like protobuf:"..." or json:"..."
-	re := regexp.MustCompile(`(\w+):"([^"]+)"`)
+// nameFallbackFlag parses the comma-separated name sources of
+// --name-fallback
+type nameFallbackFlag struct {
+	p *Processor
+}
 
-	// Find all matches
-	matches := re.FindAllStringSubmatch(tagStr, -1)
+func (f nameFallbackFlag) String() string {
+	return ""
+}
 
-	for _, match := range matches {
-		if len(match) == 3 { // Ensure there are key and value
-			key := match[1]
-			val := match[2]
-			tags[key] = val
+func (f nameFallbackFlag) Set(value string) error {
+	var sources []string
+	for _, source := range strings.Split(value, ",") {
+		source = strings.TrimSpace(source)
+		if source != "json" && source != "go" {
+			return fmt.Errorf("unknown name source %q, expected json or go", source)
+		}
+		if !slices.Contains(sources, source) {
+			sources = append(sources, source)
 		}
 	}
-	return tags
+	f.p.NameFallback = sources
+	return nil
 }
 
-// formatTags converts a map of tags back to a tag string
-func formatTags(tags map[string]string) string {
-	var parts []string
-	for key, value := range tags {
-		parts = append(parts, fmt.Sprintf(`%s:"%s"`, key, value))
+// allowedTagsFlag collects the comma-separated tag keys given with
+// --allowed-tags, which may be given more than once
+type allowedTagsFlag struct {
+	p *Processor
+}
+
+func (f allowedTagsFlag) String() string {
+	return ""
+}
+
+func (f allowedTagsFlag) Set(value string) error {
+	if f.p.AllowedTags == nil {
+		f.p.AllowedTags = make(map[string]bool)
 	}
-	return strings.Join(parts, " ")
+	for _, key := range strings.Split(value, ",") {
+		if key = strings.TrimSpace(key); key != "" {
+			f.p.AllowedTags[key] = true
+		}
+	}
+	return nil
 }
 
-// getEmbeddedStructName gets the name of an embedded struct
-func getEmbeddedStructName(field *ast.Field) string {
-	// If field has names, it's not an embedded struct
-	if len(field.Names) > 0 {
+// disallowedTagKey returns the first key of a tag that --allowed-tags
+// doesn't allow, or "" when all are
+func (p *Processor) disallowedTagKey(tagStr string) string {
+	if p.AllowedTags == nil {
 		return ""
 	}
-
-	// Handle different types of embedded structs
-	switch t := field.Type.(type) {
-	case *ast.Ident:
-		// Simple embedded struct (e.g., Model)
-		return t.Name
-	case *ast.SelectorExpr:
-		// Qualified embedded struct (e.g., gorm.Model)
-		if x, ok := t.X.(*ast.Ident); ok {
-			return x.Name + "." + t.Sel.Name
+	tags, _ := parseTags(tagStr)
+	for _, tag := range tags {
+		if !p.AllowedTags[tag.Key] {
+			return tag.Key
 		}
 	}
 	return ""
 }
 
-func processFile(inputPath string) error {
-	// Read the input file
-	file, err := os.Open(inputPath)
+// readFileList reads the paths listed in a manifest file, one per line.
+// Blank lines and lines starting with # are ignored.
+func readFileList(path string) ([]string, error) {
+	file, err := os.Open(path)
 	if err != nil {
-		return fmt.Errorf("failed to open file: %v", err)
+		return nil, fmt.Errorf("failed to open file list: %v", err)
 	}
 	defer file.Close()
 
-	// Parse the Go file
-	fset := token.NewFileSet()
-	astFile, err := parser.ParseFile(fset, inputPath, nil, parser.ParseComments)
-	if err != nil {
-		return fmt.Errorf("failed to parse file: %v", err)
-	}
-
-	// Create maps to store unique imports and fields
-	imports := make(map[string]bool)
-	fields := make(map[string]map[string]string)
-	tags := make(map[string]map[string]string)
-
-	// Process annotations
-	goTypeStr := ""
+	var files []string
 	scanner := bufio.NewScanner(file)
 	for scanner.Scan() {
-		line := scanner.Text()
-		annotations := parseAnnotations(line)
-		if len(annotations) == 0 {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
 			continue
 		}
-		for _, ann := range annotations {
-			switch ann.Type {
-			case "goimport":
-				imports[ann.Content] = true
-			case "gotype":
-				goTypeStr = ann.Content
-				fields[goTypeStr] = make(map[string]string)
-				tags[goTypeStr] = make(map[string]string)
-			case "gofield":
-				fields[goTypeStr][ann.Content] = ann.Content
-			case "gotags":
-				// Extract field name from the line by looking for protobuf field names
-				fieldMatch := regexp.MustCompile(`name=(\w+)`).FindStringSubmatch(line)
-				if len(fieldMatch) > 1 {
-					fieldName := fieldMatch[1]
-					tags[goTypeStr][strings.ToLower(strings.ReplaceAll(fieldName, "_", ""))] = strings.TrimSpace(ann.Content)
-				}
+		files = append(files, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read file list: %v", err)
+	}
+	return files, nil
+}
+
+// patchPath returns the path a file is known by in a patch: relative to the
+// working directory, with forward slashes, as git apply expects
+func patchPath(fpath string) string {
+	if filepath.IsAbs(fpath) {
+		if wd, err := os.Getwd(); err == nil {
+			if rel, err := filepath.Rel(wd, fpath); err == nil {
+				fpath = rel
 			}
 		}
 	}
+	return filepath.ToSlash(filepath.Clean(fpath))
+}
+
+// writePatch writes the diffs of all processed files to the patch file, in
+// file order
+func (p *Processor) writePatch() error {
+	var paths []string
+	for path := range p.patches {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
 
-	// Add new imports
-	for imp := range imports {
-		importSpec := &ast.ImportSpec{
-			Path: &ast.BasicLit{
-				Kind:  token.STRING,
-				Value: fmt.Sprintf("%q", imp),
-			},
-		}
+	var sb strings.Builder
+	for _, path := range paths {
+		sb.WriteString(p.patches[path])
+	}
+	if err := os.WriteFile(p.PatchFile, []byte(sb.String()), 0644); err != nil {
+		return fmt.Errorf("failed to write patch: %v", err)
+	}
+	return nil
+}
 
-		// Find or create import declaration
-		var importDecl *ast.GenDecl
-		for _, decl := range astFile.Decls {
-			if genDecl, ok := decl.(*ast.GenDecl); ok && genDecl.Tok == token.IMPORT {
-				importDecl = genDecl
-				break
-			}
-		}
+// run processes a single file and writes the result back in place, or into
+// the patch when one was requested
+func (p *Processor) run(fpath string) {
+	p.logEvent(logEvent{File: fpath, Status: "start"})
 
-		if importDecl == nil {
-			importDecl = &ast.GenDecl{
-				Tok:    token.IMPORT,
-				Lparen: 1, // Multi-line import block
-			}
-			astFile.Decls = append([]ast.Decl{importDecl}, astFile.Decls...)
+	// Files without annotations are passed over with a byte scan, without
+	// waiting for a parse slot. Read errors are left to processFile.
+	if src, err := os.ReadFile(fpath); err == nil && p.canSkip(fpath, src) {
+		if p.Validate {
+			p.logEvent(logEvent{File: fpath, Status: "checked"})
+			return
 		}
-
-		// Check for duplicate imports
-		isDuplicate := false
-		for _, spec := range importDecl.Specs {
-			if impSpec, ok := spec.(*ast.ImportSpec); ok {
-				if impSpec.Path.Value == fmt.Sprintf("%q", imp) {
-					isDuplicate = true
-					break
-				}
+		if p.OutDir != "" {
+			if err := p.writeOutput(fpath, src); err != nil {
+				p.errorf(fpath, "%v", err)
+				return
 			}
 		}
+		p.logEvent(logEvent{File: fpath, Status: "skip", Counts: &fileStats{}})
+		return
+	}
+
+	// Each file's AST is held in memory while it's processed, so with
+	// --max-parse only that many workers process a file at once; the others
+	// read, write or diff files in the meantime
+	if p.parseSlots != nil {
+		p.parseSlots <- struct{}{}
+	}
+	stats, err := p.processFile(fpath)
+	if p.parseSlots != nil {
+		<-p.parseSlots
+	}
+	if err != nil {
+		p.errorf(fpath, "%v", err)
+		return
+	}
+	if p.Validate {
+		p.logEvent(logEvent{File: fpath, Status: "checked"})
+		return
+	}
 
-		if !isDuplicate {
-			importDecl.Specs = append(importDecl.Specs, importSpec)
+	// Read the enhanced file, and remove it right away so failures below
+	// don't leave it behind
+	enhancedContent, err := os.ReadFile(fpath + p.Suffix)
+	if err != nil {
+		p.errorf(fpath, "failed to read enhanced file: %v", err)
+		return
+	}
+	if err := os.Remove(fpath + p.Suffix); err != nil {
+		p.warnf(fpath, "could not remove enhanced file: %v", err)
+	}
+	if p.GoImports {
+		if enhancedContent, err = runGoimports(fpath, enhancedContent); err != nil {
+			p.errorf(fpath, "%v", err)
+			return
 		}
 	}
 
-	// Process type declarations and add fields/tags
-	for _, decl := range astFile.Decls {
-		if genDecl, ok := decl.(*ast.GenDecl); ok && genDecl.Tok == token.TYPE {
-			for _, spec := range genDecl.Specs {
-				if typeSpec, ok := spec.(*ast.TypeSpec); ok {
-					if structType, ok := typeSpec.Type.(*ast.StructType); ok {
-						// Get the struct name
-						structName := typeSpec.Name.Name
+	if p.PatchFile != "" {
+		// Record the change in the patch instead of modifying the source
+		original, err := os.ReadFile(fpath)
+		if err != nil {
+			p.errorf(fpath, "failed to read file: %v", err)
+			return
+		}
+		path := patchPath(fpath)
+		if diff := unifiedDiff(path, original, enhancedContent); diff != "" {
+			p.patchMu.Lock()
+			p.patches[path] = diff
+			p.patchMu.Unlock()
+		}
+	} else if p.OutDir != "" {
+		// Leave the source alone and write the result under the output
+		// directory
+		if err := p.writeOutput(fpath, enhancedContent); err != nil {
+			p.errorf(fpath, "%v", err)
+			return
+		}
+	} else if err := os.WriteFile(fpath, enhancedContent, 0644); err != nil {
+		// Write back to original file
+		p.errorf(fpath, "failed to write back: %v", err)
+		return
+	}
+	if p.ExternalMethods {
+		if err := p.writeCompanion(fpath); err != nil {
+			p.errorf(fpath, "%v", err)
+			return
+		}
+	}
 
-						// Add new fields
-						existingFields := make(map[string]bool)
-						for _, field := range structType.Fields.List {
-							if len(field.Names) > 0 {
-								existingFields[field.Names[0].Name] = true
-							} else {
-								// Handle embedded struct
-								if embeddedName := getEmbeddedStructName(field); embeddedName != "" {
-									existingFields[embeddedName] = true
-								}
-							}
-						}
-						for _, fieldStr := range fields[structName] {
-							field := createFieldFromString(fieldStr)
-							if field != nil {
-								fieldName := ""
-								if len(field.Names) > 0 {
-									fieldName = field.Names[0].Name
-								} else {
-									fieldName = getEmbeddedStructName(field)
-								}
+	status := "skip"
+	if stats.changed() {
+		status = "change"
+	}
+	p.logEvent(logEvent{File: fpath, Status: status, Counts: &stats})
+}
 
-								// Check for duplicates
-								isDuplicate := false
-								if fieldName != "" && existingFields[fieldName] {
-									isDuplicate = true
-								}
+// runAll processes files, up to jobs at a time
+func (p *Processor) runAll(files []string, jobs int) {
+	queue := make(chan string)
+	var wg sync.WaitGroup
+	for i := 0; i < jobs; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for fpath := range queue {
+				p.run(fpath)
+			}
+		}()
+	}
+	for _, fpath := range files {
+		queue <- fpath
+	}
+	close(queue)
+	wg.Wait()
+}
 
-								if !isDuplicate {
-									structType.Fields.List = append(structType.Fields.List, field)
-									if fieldName != "" {
-										existingFields[fieldName] = true
-									}
-								}
-							}
-						}
+func main() {
+	p := &Processor{}
+	var filesFrom, root string
+	var jobs, maxParse int
+	var failOnWarning, noColor, recursive, noGitignore, extract, printConfig bool
+	var include []string
+	flag.BoolVar(&p.Verbose, "v", false, "")
+	flag.BoolVar(&p.Verbose, "verbose", false, "")
+	flag.StringVar(&p.LogFormat, "log-format", "text", "")
+	flag.StringVar(&filesFrom, "files-from", "", "")
+	flag.IntVar(&jobs, "j", 1, "")
+	flag.IntVar(&maxParse, "max-parse", 0, "")
+	flag.BoolVar(&failOnWarning, "fail-on-warning", false, "")
+	flag.StringVar(&p.Prefix, "prefix", defaultPrefix, "")
+	flag.BoolVar(&p.Debug, "debug", false, "")
+	flag.StringVar(&p.Suffix, "suffix", ".enhanced", "")
+	flag.BoolFunc("wkt-tags", "", func(string) error {
+		p.enableWKTTags()
+		return nil
+	})
+	flag.Var(wktTagFlag{p}, "wkt-tag", "")
+	flag.Var(typeTagFlag{p}, "type-tag", "")
+	flag.Func("type-tags", "", p.readTypeTags)
+	flag.Func("templates", "", p.readTemplates)
+	flag.BoolFunc("yaml-tags", "", func(string) error {
+		if p.YAMLCase == "" {
+			p.YAMLCase = "json"
+		}
+		return nil
+	})
+	flag.Var(yamlCaseFlag{p}, "yaml-case", "")
+	flag.StringVar(&p.PatchFile, "patch", "", "")
+	flag.Var(mergeFlag{p}, "merge", "")
+	flag.Var(tagOrderFlag{p}, "tag-order", "")
+	flag.Var(conditionsFlag{p}, "conditions", "")
+	flag.BoolVar(&noColor, "no-color", false, "")
+	flag.BoolVar(&p.AlignTags, "align-tags", false, "")
+	flag.BoolVar(&p.NormalizeImports, "normalize-imports", false, "")
+	flag.BoolVar(&p.StrictTypes, "strict-types", false, "")
+	flag.BoolVar(&p.RequireAnnotations, "require-annotations", false, "")
+	flag.BoolVar(&p.Validate, "validate", false, "")
+	flag.BoolVar(&extract, "extract", false, "")
+	flag.BoolVar(&printConfig, "print-config", false, "")
+	flag.StringVar(&p.ReportUnused, "report-unused", "", "")
+	flag.BoolVar(&p.GoNames, "go-names", false, "")
+	flag.Var(nameFallbackFlag{p}, "name-fallback", "")
+	flag.BoolVar(&p.GoImports, "goimports", false, "")
+	flag.BoolVar(&p.WarnImportCycles, "warn-import-cycles", false, "")
+	flag.StringVar(&p.OutDir, "out", "", "")
+	flag.Var(renamePatternFlag{p}, "rename-pattern", "")
+	flag.Var(onlyFlag{p}, "only", "")
+	flag.Var(allowedTagsFlag{p}, "allowed-tags", "")
+	flag.BoolVar(&p.ExternalMethods, "external-methods", false, "")
+	flag.BoolVar(&p.PreserveFormatting, "preserve-formatting", false, "")
+	flag.BoolVar(&recursive, "r", false, "")
+	flag.StringVar(&root, "root", "", "")
+	flag.BoolVar(&noGitignore, "no-gitignore", false, "")
+	flag.Var(includeFlag{&include}, "include", "")
+	flag.Usage = printHelp
+	flag.Parse()
 
-						// Update tags
-						for _, field := range structType.Fields.List {
-							if len(field.Names) > 0 {
-								if newTagStr, exists := tags[structName][strings.ToLower(field.Names[0].Name)]; exists {
-									// Parse existing and new tags
-									existingTags := make(map[string]string)
-									if field.Tag != nil {
-										existingTags = parseTags(field.Tag.Value)
-									}
+	// Color only when a person is likely watching, following the NO_COLOR
+	// convention
+	p.Color = !noColor && os.Getenv("NO_COLOR") == "" && isTerminal(os.Stdout)
 
-									newTags := parseTags(newTagStr)
+	for _, h := range extraHandlers {
+		if err := p.RegisterHandler(h); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+	}
 
-									// Merge tags, new tags take precedence
-									for k, v := range newTags {
-										existingTags[k] = v
-									}
+	files := flag.Args()
 
-									// Set the combined tags
-									field.Tag = &ast.BasicLit{
-										Kind:  token.STRING,
-										Value: fmt.Sprintf("`%s`", formatTags(existingTags)),
-									}
-								}
-							}
-						}
-					}
-				}
-			}
+	// With --root, arguments are patterns matched against the paths under it
+	if root != "" {
+		if recursive {
+			fmt.Println("Invalid -r, --root patterns already match files in every directory under the root")
+			os.Exit(1)
+		}
+		if len(files) == 0 {
+			fmt.Println("Invalid --root, it requires patterns of the files to process, e.g. '**/*.pb.go'")
+			os.Exit(1)
 		}
+		matched, err := globDir(root, files, !noGitignore)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		files = matched
 	}
 
-	// Write the modified AST to output file
-	outFile, err := os.Create(inputPath + ".enhanced")
-	if err != nil {
-		return fmt.Errorf("failed to create output file: %v", err)
+	if filesFrom != "" {
+		listed, err := readFileList(filesFrom)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		files = append(files, listed...)
 	}
-	defer outFile.Close()
 
-	if err := format.Node(outFile, fset, astFile); err != nil {
-		return fmt.Errorf("failed to write output: %v", err)
+	// With -r, directories are replaced by the generated files under them
+	if recursive {
+		var expanded []string
+		for _, path := range files {
+			if info, err := os.Stat(path); err != nil || !info.IsDir() {
+				expanded = append(expanded, path)
+				continue
+			}
+			found, err := walkDir(path, include, !noGitignore)
+			if err != nil {
+				fmt.Printf("Error: %v\n", err)
+				os.Exit(1)
+			}
+			expanded = append(expanded, found...)
+		}
+		files = expanded
 	}
 
-	return nil
-}
-
-func printHelp() {
-	fmt.Println("protoc-go-inject - A tool to inject custom annotations into protobuf-generated Go files")
-	fmt.Println("\nUsage:")
-	fmt.Println("  protoc-go-inject [options] <pb.go files...>")
-	fmt.Println("\nOptions:")
-	fmt.Println("  -h, --help     Show this help message")
-	fmt.Println("\nExample:")
-	fmt.Println("  protoc-go-inject a.pb.go b.pb.go")
-	fmt.Println("\nSupported Annotations:")
-	fmt.Println("  @goimport: Add new package imports")
-	fmt.Println("    Example: // @goimport: \"gorm.io/gorm\"")
-	fmt.Println("\n  @gofield: Add new struct fields")
-	fmt.Println("    Example: // @gofield: gorm.Model")
-	fmt.Println("    Example: // @gofield: LastName string")
-	fmt.Println("\n  @gotags: Append or modify struct field tags")
-	fmt.Println("    Example: // @gotags: gorm:\"column:id;primaryKey;AUTO_INCREMENT\"")
-}
+	// Process files in a fixed order whatever the shell's glob order or the
+	// order given, so logs and summaries compare across machines. A file
+	// given twice is processed once.
+	slices.Sort(files)
+	files = slices.Compact(files)
 
-func main() {
-	if len(os.Args) < 2 || os.Args[1] == "-h" || os.Args[1] == "--help" {
+	if len(files) == 0 && !printConfig {
 		printHelp()
-		if len(os.Args) < 2 {
+		os.Exit(1)
+	}
+	if p.LogFormat != "text" && p.LogFormat != "json" {
+		fmt.Printf("Invalid --log-format %q, expected text or json\n", p.LogFormat)
+		os.Exit(1)
+	}
+	if p.Prefix == "" {
+		fmt.Println("Invalid --prefix, it must not be empty")
+		os.Exit(1)
+	}
+	if p.Suffix == "" {
+		fmt.Println("Invalid --suffix, it must not be empty")
+		os.Exit(1)
+	}
+	if p.OutDir != "" && p.PatchFile != "" {
+		fmt.Println("Invalid --out, it can't be combined with --patch")
+		os.Exit(1)
+	}
+	if p.RenamePattern != nil && p.OutDir == "" {
+		fmt.Println("Invalid --rename-pattern, it requires --out")
+		os.Exit(1)
+	}
+	if p.GoImports {
+		if p.AlignTags || p.PreserveFormatting {
+			fmt.Println("Invalid --goimports, it reformats the whole file, undoing --align-tags and --preserve-formatting")
 			os.Exit(1)
 		}
-		os.Exit(0)
+	}
+	if jobs < 1 {
+		jobs = 1
+	}
+	if maxParse > 0 && maxParse < jobs {
+		p.parseSlots = make(chan struct{}, maxParse)
 	}
 
-	// Process each input file
-	for _, fpath := range os.Args[1:] {
-		fmt.Printf("Processing %s...\n", fpath)
-
-		// Get absolute path
-		absPath, err := filepath.Abs(fpath)
+	// Show the settings once they are all checked, without processing
+	// anything
+	if printConfig {
+		if maxParse <= 0 || maxParse > jobs {
+			maxParse = jobs
+		}
+		err := p.printConfig(
+			configEntry{"extract", extract},
+			configEntry{"jobs", jobs},
+			configEntry{"max_parse", maxParse},
+			configEntry{"fail_on_warning", failOnWarning || p.Validate},
+			configEntry{"files", files},
+		)
 		if err != nil {
-			fmt.Printf("Error getting absolute path for %s: %v\n", fpath, err)
-			continue
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
 		}
+		return
+	}
 
-		if err := processFile(absPath); err != nil {
-			fmt.Printf("Error processing %s: %v\n", fpath, err)
-			continue
+	// Extraction only reads the files, in order, so their annotation blocks
+	// aren't interleaved
+	if extract {
+		for i, fpath := range files {
+			annotations, err := p.extractAnnotations(fpath)
+			if err != nil {
+				p.errorf(fpath, "%v", err)
+				continue
+			}
+			if len(files) > 1 {
+				if i > 0 {
+					fmt.Println()
+				}
+				fmt.Printf("// %s\n", fpath)
+			}
+			fmt.Print(annotations)
 		}
-
-		// Read the enhanced file
-		enhancedContent, err := os.ReadFile(absPath + ".enhanced")
-		if err != nil {
-			fmt.Printf("Error reading enhanced file for %s: %v\n", fpath, err)
-			continue
+		p.printErrorSummary()
+		if len(p.failures) > 0 {
+			os.Exit(1)
 		}
+		return
+	}
 
-		// Write back to original file
-		if err := os.WriteFile(absPath, enhancedContent, 0644); err != nil {
-			fmt.Printf("Error writing back to %s: %v\n", fpath, err)
-			continue
+	p.patches = make(map[string]string)
+	p.outputs = make(map[string]string)
+
+	p.runAll(files, jobs)
+
+	if p.PatchFile != "" && !p.Validate {
+		if err := p.writePatch(); err != nil {
+			p.errorf(p.PatchFile, "%v", err)
 		}
+	}
 
-		// Remove the .enhanced file
-		if err := os.Remove(absPath + ".enhanced"); err != nil {
-			fmt.Printf("Warning: Could not remove enhanced file for %s: %v\n", fpath, err)
+	if p.ReportUnused != "" {
+		if err := p.writeUnusedReport(); err != nil {
+			p.errorf(p.ReportUnused, "%v", err)
 		}
+	}
 
-		fmt.Printf("Successfully processed %s\n", fpath)
+	p.printImportSummary()
+	p.printErrorSummary()
+	if len(p.failures) > 0 {
+		os.Exit(1)
+	}
+	// Warnings point at annotations that would be ignored, which validation
+	// is meant to catch
+	if (failOnWarning || p.Validate) && p.warnings > 0 {
+		fmt.Printf("Failing because %d warning(s) were emitted\n", p.warnings)
+		os.Exit(1)
 	}
 }
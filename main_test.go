@@ -0,0 +1,1493 @@
+package main
+
+import (
+	"errors"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// newTestProcessor returns a Processor with the defaults main gives one
+func newTestProcessor() *Processor {
+	return &Processor{Prefix: defaultPrefix, Suffix: ".enhanced"}
+}
+
+// processSource writes src to a file named name in a temporary directory,
+// processes it with p and returns the enhanced output. Whatever p prints is
+// discarded.
+func processSource(t *testing.T, p *Processor, name, src string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var err error
+	captureStdout(t, func() { _, err = p.processFile(path) })
+	if err != nil {
+		t.Fatalf("processing failed: %v", err)
+	}
+	out, err := os.ReadFile(path + p.Suffix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(out)
+}
+
+// process runs a new Processor over src and returns the enhanced output
+func process(t *testing.T, src string) string {
+	t.Helper()
+	return processSource(t, newTestProcessor(), "test.pb.go", src)
+}
+
+// structDecl returns the declaration of a struct in a file, from its type
+// keyword to its closing brace, or "" if there is none
+func structDecl(src, name string) string {
+	start := strings.Index(src, "type "+name+" struct")
+	if start < 0 {
+		start = strings.Index(src, "type "+name+"[")
+	}
+	if start < 0 {
+		return ""
+	}
+	end := strings.Index(src[start:], "\n}")
+	if end < 0 {
+		return src[start:]
+	}
+	return src[start : start+end+2]
+}
+
+func TestGenericStructs(t *testing.T) {
+	src := `package pb
+
+type Box[T any, K comparable, V any] struct {
+	// @gofield: Index map[K]V
+	Value T
+}
+
+type Pair[T any] struct {
+	// @gofield: Next *Pair[T]
+	// @gofield: Base[T]
+	Base[T]
+	First T
+}
+
+type Base[T any] struct {
+	Id T
+}
+`
+	out := process(t, src)
+	for name, want := range map[string]string{
+		"Box": "type Box[T any, K comparable, V any] struct {\n\t// @gofield: Index map[K]V\n\tValue T\n\tIndex map[K]V\n}",
+		// The embedded Base[T] isn't added again
+		"Pair": "type Pair[T any] struct {\n\t// @gofield: Next *Pair[T]\n\t// @gofield: Base[T]\n\tBase[T]\n\tFirst T\n\tNext  *Pair[T]\n}",
+	} {
+		if got := structDecl(out, name); got != want {
+			t.Errorf("got:\n%s\nwant:\n%s", got, want)
+		}
+	}
+}
+
+func TestPlainGoFile(t *testing.T) {
+	src := "package config\n\ntype Config struct {\n" +
+		"\t// @gofield: Debug bool\n" +
+		"\tAddr string `json:\"addr\"` // @gotags: yaml:\"addr\"\n" +
+		"\tUser_Name string // @gotags: yaml:\"user\"\n" +
+		"}\n"
+	out := process(t, src)
+	for _, want := range []string{
+		"Addr      string `json:\"addr\" yaml:\"addr\"`",
+		"User_Name string `yaml:\"user\"`",
+		"Debug     bool",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("%s not found in:\n%s", want, out)
+		}
+	}
+}
+
+func TestEnhancedMarker(t *testing.T) {
+	body := "type User struct {\n\tId string `protobuf:\"bytes,1,opt,name=id,proto3\"` // @gotags: json:\"id\"\n}\n"
+	tests := []struct {
+		name string
+		src  string
+		want string
+	}{
+		{
+			"below the generated header",
+			"// Code generated by protoc-gen-go. DO NOT EDIT.\n// source: user.proto\n\npackage pb\n\n" + body,
+			"// Code generated by protoc-gen-go. DO NOT EDIT.\n" + enhancedMarker + "\n// source: user.proto\n\npackage pb\n",
+		},
+		{"without a header", "package pb\n\n" + body, enhancedMarker + "\n\npackage pb\n"},
+		{
+			"after a byte order mark",
+			"\xef\xbb\xbf// Code generated by protoc-gen-go. DO NOT EDIT.\n\npackage pb\n\n" + body,
+			"\xef\xbb\xbf// Code generated by protoc-gen-go. DO NOT EDIT.\n" + enhancedMarker + "\n\npackage pb\n",
+		},
+		{
+			"header after the package clause",
+			"package pb\n\n// Code generated by hand. DO NOT EDIT.\n" + body,
+			enhancedMarker + "\n\npackage pb\n",
+		},
+		{"already marked", enhancedMarker + "\n\npackage pb\n\n" + body, enhancedMarker + "\n\npackage pb\n"},
+		{"no annotations", "package pb\n\ntype User struct {\n\tId string\n}\n", "package pb\n"},
+	}
+	for _, tt := range tests {
+		out := process(t, tt.src)
+		if !strings.HasPrefix(out, tt.want) {
+			t.Errorf("%s: output doesn't start with %q:\n%s", tt.name, tt.want, out)
+		}
+		if n := strings.Count(out, enhancedMarker); n > 1 {
+			t.Errorf("%s: marker written %d times:\n%s", tt.name, n, out)
+		}
+	}
+}
+
+func TestEmbedInterfaces(t *testing.T) {
+	src := `package pb
+
+import "io"
+
+type User struct {
+	// @gofield: io.Reader
+	// @gofield: Validator
+	// @gofield: io.Writer
+	io.Writer
+	Name string
+}
+
+type Validator interface{ Validate() error }
+`
+	// The io.Writer embedded already isn't added again
+	got := structDecl(process(t, src), "User")
+	for embed, n := range map[string]int{"io.Writer": 1, "io.Reader": 1, "Validator": 1} {
+		if c := strings.Count(got, "\n\t"+embed+"\n"); c != n {
+			t.Errorf("%s embedded %d times, want %d:\n%s", embed, c, n, got)
+		}
+	}
+}
+
+func TestChanAndFuncFields(t *testing.T) {
+	tests := []struct {
+		decl string
+		want string
+	}{
+		{"Done chan struct{}", "Done chan struct{}"},
+		{"Done <-chan struct{}", "Done <-chan struct{}"},
+		{"Events chan<- string", "Events chan<- string"},
+		{"Pipes chan (<-chan int)", "Pipes chan (<-chan int)"},
+		{"OnChange func(string)", "OnChange func(string)"},
+		{"OnChange func(old, new string) error", "OnChange func(old, new string) error"},
+		{"Resolve func(context.Context) (string, error)", "Resolve func(context.Context) (string, error)"},
+		{"Hooks []func() <-chan error", "Hooks []func() <-chan error"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.decl, func(t *testing.T) {
+			src := "package pb\n\n// @gotype: User\n// @gofield: " + tt.decl + "\n\ntype User struct {\n}\n"
+			want := "type User struct {\n\t" + tt.want + "\n}"
+			if got := structDecl(process(t, src), "User"); got != want {
+				t.Errorf("got:\n%s\nwant:\n%s", got, want)
+			}
+		})
+	}
+}
+
+func TestArrayFields(t *testing.T) {
+	tests := []struct {
+		decl string
+		want string
+	}{
+		{"Hash [32]byte", "Hash [32]byte"},
+		{"Sum [sha256.Size]byte", "Sum [sha256.Size]byte"},
+		{"Buf [BufSize]byte", "Buf [BufSize]byte"},
+		{"Grid [2 * BufSize][4]int", "Grid [2 * BufSize][4]int"},
+		// Only composite literals can leave the length to the compiler
+		{"Keys [...]string", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.decl, func(t *testing.T) {
+			src := "package pb\n\n// @gotype: User\n// @gofield: " + tt.decl + "\n\ntype User struct {\n}\n\nconst BufSize = 8\n"
+			want := "type User struct {\n\t" + tt.want + "\n}"
+			if tt.want == "" {
+				want = "type User struct {\n}"
+			}
+			if got := structDecl(process(t, src), "User"); got != want {
+				t.Errorf("got:\n%s\nwant:\n%s", got, want)
+			}
+		})
+	}
+}
+
+func TestReadFileList(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "files.txt")
+	list := "# generated by the build\na.pb.go\n\n  dir/b.pb.go  \n\t# indented comment\n"
+	if err := os.WriteFile(path, []byte(list), 0644); err != nil {
+		t.Fatal(err)
+	}
+	files, err := readFileList(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"a.pb.go", "dir/b.pb.go"}; !reflect.DeepEqual(files, want) {
+		t.Errorf("readFileList = %q, want %q", files, want)
+	}
+	if _, err := readFileList(path + ".missing"); err == nil {
+		t.Error("missing file list gave no error")
+	}
+}
+
+func TestRunWritesInPlace(t *testing.T) {
+	for _, suffix := range []string{".enhanced", ".gen.go"} {
+		dir := t.TempDir()
+		path := filepath.Join(dir, "test.pb.go")
+		src := "package pb\n\ntype User struct {\n\t// @gofield: Age int\n}\n"
+		if err := os.WriteFile(path, []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+		// A file that happens to have the default suffix is left alone
+		other := path + ".enhanced"
+		if suffix != ".enhanced" {
+			if err := os.WriteFile(other, []byte("keep"), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		p := newTestProcessor()
+		p.Suffix = suffix
+		captureStdout(t, func() { p.run(path) })
+		out, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(out), "\tAge int\n") {
+			t.Errorf("%s: field not injected:\n%s", suffix, out)
+		}
+		if _, err := os.Stat(path + suffix); !os.IsNotExist(err) {
+			t.Errorf("%s: intermediate file left behind: %v", suffix, err)
+		}
+		if suffix != ".enhanced" {
+			if data, err := os.ReadFile(other); err != nil || string(data) != "keep" {
+				t.Errorf("%s: unrelated %s changed: %q, %v", suffix, other, data, err)
+			}
+		}
+	}
+}
+
+func TestResolveTypeName(t *testing.T) {
+	structNames := map[string]bool{"User": true, "Outer_Inner": true, "Inner": true, "mypkg_User": true}
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"User", "User"},
+		{"Outer_Inner", "Outer_Inner"},
+		// Nested messages map to their generated name
+		{"mypkg.Outer.Inner", "Outer_Inner"},
+		{"a.b.c.User", "User"},
+		// The most qualified match wins
+		{"mypkg.User", "mypkg_User"},
+		{"mypkg.Missing", "mypkg.Missing"},
+	}
+	p := &Processor{}
+	for _, tt := range tests {
+		var got string
+		var err error
+		captureStdout(t, func() { got, err = p.resolveTypeName("test.pb.go", 1, tt.name, structNames, nil, nil) })
+		if err != nil || got != tt.want {
+			t.Errorf("resolveTypeName(%q) = %q, %v, want %q", tt.name, got, err, tt.want)
+		}
+	}
+
+	// --strict-types refuses to pick among several matches
+	p.StrictTypes = true
+	if _, err := p.resolveTypeName("test.pb.go", 1, "mypkg.User", structNames, nil, nil); err == nil {
+		t.Error("ambiguous @gotype accepted with StrictTypes")
+	}
+	if got, err := p.resolveTypeName("test.pb.go", 1, "a.b.c.User", structNames, nil, nil); err != nil || got != "User" {
+		t.Errorf("unambiguous @gotype = %q, %v with StrictTypes", got, err)
+	}
+}
+
+func TestQualifiedGotype(t *testing.T) {
+	src := `package pb
+
+// @gotype: mypkg.User
+// @gofield: Age int
+// @gotype: mypkg.User.Address
+// @gofield: Zip string
+
+type User struct {
+	Name string
+}
+
+type User_Address struct {
+	Street string
+}
+`
+	out := process(t, src)
+	if got := structDecl(out, "User"); !strings.Contains(got, "\tAge  int\n") {
+		t.Errorf("Age not added to User:\n%s", got)
+	}
+	if got := structDecl(out, "User_Address"); !strings.Contains(got, "\tZip    string\n") {
+		t.Errorf("Zip not added to User_Address:\n%s", got)
+	}
+}
+
+func TestSupportedAnnotations(t *testing.T) {
+	help := captureStdout(t, printHelp)
+	for _, spec := range SupportedAnnotations {
+		if !strings.Contains(help, "@"+spec.Name+": "+spec.Description) {
+			t.Errorf("help doesn't describe @%s:\n%s", spec.Name, help)
+		}
+		// Every example parses as the annotation it documents
+		for _, example := range spec.Examples {
+			found := false
+			for _, ann := range parseAnnotations(example, defaultPrefix) {
+				found = found || ann.Type == spec.Name
+			}
+			if !found {
+				t.Errorf("example %q doesn't parse as @%s", example, spec.Name)
+			}
+		}
+	}
+}
+
+func TestParseAnnotationsPrefix(t *testing.T) {
+	tests := []struct {
+		line   string
+		prefix string
+		want   []Annotation
+	}{
+		{`// @gotags: json:"id"`, defaultPrefix, []Annotation{{Type: "gotags", Content: `json:"id"`}}},
+		{`// @inject_tags: json:"id"`, "@inject_", []Annotation{{Type: "gotags", Content: `json:"id"`}}},
+		// The default prefix isn't recognized once another is set
+		{`// @gotags: json:"id"`, "@inject_", nil},
+		// Regexp metacharacters in the prefix are matched literally
+		{`// +inject.field: Age int`, "+inject.", []Annotation{{Type: "gofield", Content: "Age int", Index: -1}}},
+		{`// +injectXfield: Age int`, "+inject.", nil},
+		// An earlier mention of the prefix doesn't hide the annotation
+		{`// see @go docs, @gotype: mypkg.User`, defaultPrefix, []Annotation{{Type: "gotype", Content: "mypkg.User"}}},
+	}
+	for _, tt := range tests {
+		if got := parseAnnotations(tt.line, tt.prefix); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseAnnotations(%q, %q) = %+v, want %+v", tt.line, tt.prefix, got, tt.want)
+		}
+	}
+}
+
+func TestCustomPrefix(t *testing.T) {
+	src := "package pb\n\ntype User struct {\n" +
+		"\t// @gofield: Other int\n" +
+		"\t// @inject_field: Age int\n" +
+		"\tId string `protobuf:\"bytes,1,opt,name=id,proto3\"` // @inject_tags: json:\"id\"\n" +
+		"}\n"
+	out := structDecl(processSource(t, &Processor{Prefix: "@inject_", Suffix: ".enhanced"}, "test.pb.go", src), "User")
+	for _, want := range []string{"\tAge int\n", "`protobuf:\"bytes,1,opt,name=id,proto3\" json:\"id\"`"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("%q not found in:\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, "\tOther") {
+		t.Errorf("@gofield applied with prefix @inject_:\n%s", out)
+	}
+}
+
+func TestIgnoreAnnotationsInStrings(t *testing.T) {
+	src := "package pb\n\ntype User struct {\n" +
+		"\t// @gofield: Age int\n" +
+		"\tId string `help:\"@gotags: json:\\\"x\\\"\"`\n" +
+		"}\n\n" +
+		"var doc = \"// @gofield: Evil int\"\n\n" +
+		"const usage = `\n@gofield: Other int\n`\n"
+	out := structDecl(process(t, src), "User")
+	want := "type User struct {\n\t// @gofield: Age int\n\tId  string `help:\"@gotags: json:\\\"x\\\"\"`\n\tAge int\n}"
+	if out != want {
+		t.Errorf("got:\n%s\nwant:\n%s", out, want)
+	}
+}
+
+func TestStripTrailingComment(t *testing.T) {
+	tests := []struct {
+		content string
+		want    string
+	}{
+		{`json:"id"`, `json:"id"`},
+		{`json:"id" // primary key`, `json:"id"`},
+		{`json:"id"// primary key`, `json:"id"`},
+		{`json:"url" default:"http://example.com"`, `json:"url" default:"http://example.com"`},
+		{`default:"http://example.com" // the site`, `default:"http://example.com"`},
+		{`doc:"a \" // b" // c`, `doc:"a \" // b"`},
+		{"Meta struct{ K string `json:\"k//v\"` } // grouped", "Meta struct{ K string `json:\"k//v\"` }"},
+		{`Count int // number of items`, `Count int`},
+	}
+	for _, tt := range tests {
+		if got := stripTrailingComment(tt.content); got != tt.want {
+			t.Errorf("stripTrailingComment(%q) = %q, want %q", tt.content, got, tt.want)
+		}
+	}
+}
+
+func TestAnnotationTrailingComments(t *testing.T) {
+	src := "package pb\n\n// @gotype: User\n// @gofield: Count int // number of items\n\ntype User struct {\n" +
+		"\tId string `protobuf:\"bytes,1,opt,name=id,proto3\"` // @gotags: json:\"id\" // primary key\n" +
+		"\tSite string `protobuf:\"bytes,2,opt,name=site,proto3\"` // @gotags: default:\"http://example.com\"\n" +
+		"}\n"
+	want := "type User struct {\n" +
+		"\tId    string `protobuf:\"bytes,1,opt,name=id,proto3\" json:\"id\"`                      // @gotags: json:\"id\" // primary key\n" +
+		"\tSite  string `protobuf:\"bytes,2,opt,name=site,proto3\" default:\"http://example.com\"` // @gotags: default:\"http://example.com\"\n" +
+		"\tCount int\n" +
+		"}"
+	if got := structDecl(process(t, src), "User"); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestEmptyStruct(t *testing.T) {
+	src := `package pb
+
+// Ping has no fields of its own
+// @gofield: Sent int64
+type Ping struct {
+}
+
+// @gofield: Kind string
+type Pong struct{}
+`
+	out := process(t, src)
+	want := "type Ping struct {\n\tSent int64\n}"
+	if got := structDecl(out, "Ping"); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+	// A struct written on one line gets a line per field
+	if want := "type Pong struct {\n\tKind string\n}\n"; !strings.Contains(out, want) {
+		t.Errorf("%q not found in:\n%s", want, out)
+	}
+}
+
+func TestOrphanedAnnotations(t *testing.T) {
+	src := "package pb\n\n// @gofield: Lost int\n\nvar x = 1\n\ntype User struct {\n\tName string\n}\n"
+	path := filepath.Join(t.TempDir(), "test.pb.go")
+	if err := os.WriteFile(path, []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+	p := newTestProcessor()
+	out := captureStdout(t, func() {
+		if _, err := p.processFile(path); err != nil {
+			t.Error(err)
+		}
+	})
+	if p.warnings != 1 || !strings.Contains(out, "ignoring @gofield: Lost int outside of any struct") {
+		t.Errorf("got %d warnings:\n%s", p.warnings, out)
+	}
+}
+
+func TestFieldPosition(t *testing.T) {
+	src := `package pb
+
+import "sync"
+
+// @gotype: User
+// @gofield[0]: sync.Mutex
+// @gofield[0]: Version int64
+// @gofield[4]: Nickname string
+// @gofield: UpdatedAt int64
+// @gofield[9]: DeletedAt int64
+
+type User struct {
+	state protoimpl.MessageState //nolint:govet
+
+	Id int64 ` + "`protobuf:\"varint,1,opt,name=id,proto3\"`" + ` //nolint:lll
+	// Email is where mail goes.
+	Email string
+}
+`
+	// Trailing comments stay on their field, doc comments on theirs
+	want := `type User struct {
+	Version int64
+	sync.Mutex
+	state protoimpl.MessageState //nolint:govet
+
+	Id       int64 ` + "`protobuf:\"varint,1,opt,name=id,proto3\"`" + ` //nolint:lll
+	Nickname string
+	// Email is where mail goes.
+	Email     string
+	UpdatedAt int64
+	DeletedAt int64
+}`
+	if got := structDecl(process(t, src), "User"); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestPrimaryKey(t *testing.T) {
+	src := `package pb
+
+// @gotype: User
+// @goprimarykey
+
+// @gotype: Order
+// @goprimarykey: Code string
+
+type User struct {
+	Name string
+}
+
+type Order struct {
+	Total int64
+}
+`
+	out := process(t, src)
+	want := "type User struct {\n\tID   uint `gorm:\"primaryKey;autoIncrement\"`\n\tName string\n}"
+	if got := structDecl(out, "User"); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+	want = "type Order struct {\n\tCode  string `gorm:\"primaryKey\"`\n\tTotal int64\n}"
+	if got := structDecl(out, "Order"); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestTypeAliases(t *testing.T) {
+	src := `package pb
+
+import "time"
+
+// @gotype: Member
+// @gofield: Role string
+
+// @gotype: Admin
+// @gofield: Level int
+
+// @gotype: Stamp
+// @gofield: Zone string
+
+type User struct {
+	Name string
+}
+
+type Member = User
+
+type Admin = Member
+
+type Stamp = time.Time
+`
+	p := newTestProcessor()
+	out := processSource(t, p, "test.pb.go", src)
+	// Fields land on the struct the aliases name, through the chain
+	want := "type User struct {\n\tName  string\n\tRole  string\n\tLevel int\n}"
+	if got := structDecl(out, "User"); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+	if !strings.Contains(out, "type Member = User\n") || !strings.Contains(out, "type Stamp = time.Time\n") {
+		t.Errorf("aliases changed:\n%s", out)
+	}
+	if p.warnings != 1 {
+		t.Errorf("got %d warnings, want 1 for the alias of time.Time", p.warnings)
+	}
+}
+
+func TestDirectives(t *testing.T) {
+	// Every annotation example has a directive form parsing the same way
+	for _, spec := range SupportedAnnotations {
+		for _, example := range spec.Examples {
+			name, value, _ := strings.Cut(strings.TrimPrefix(example, "// @go"), ":")
+			directive := "//go:inject-" + name + " " + strings.TrimSpace(value)
+			want := parseAnnotations(example, defaultPrefix)
+			if got := parseAnnotations(directive, defaultPrefix); !reflect.DeepEqual(got, want) {
+				t.Errorf("parseAnnotations(%q) = %+v, want %+v", directive, got, want)
+			}
+		}
+	}
+
+	src := "package pb\n\nimport \"sync\"\n\n//go:inject-type User\n//go:inject-field[0] sync.Mutex\n\ntype User struct {\n" +
+		"\t//go:inject-field Age int\n" +
+		"\tId string `protobuf:\"bytes,1,opt,name=id,proto3\"` //go:inject-tags json:\"id\"\n" +
+		"\t//go:inject-tags(Age) json:\"age\"\n" +
+		"\t//go:generate not an annotation\n" +
+		"}\n"
+	want := "type User struct {\n\tsync.Mutex\n" +
+		"\t//go:inject-field Age int\n" +
+		"\tId  string `protobuf:\"bytes,1,opt,name=id,proto3\" json:\"id\"` //go:inject-tags json:\"id\"\n" +
+		"\tAge int    `json:\"age\"`\n" +
+		"\t//go:inject-tags(Age) json:\"age\"\n" +
+		"\t//go:generate not an annotation\n" +
+		"}"
+	if got := structDecl(process(t, src), "User"); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestPreserveBOM(t *testing.T) {
+	src := "\xef\xbb\xbfpackage pb\n\n// @gotype: User\n// @gofield: Age int\n\ntype User struct {\n\tName string\n}\n"
+	out := process(t, src)
+	if !strings.HasPrefix(out, "\xef\xbb\xbf") || strings.Count(out, "\xef\xbb\xbf") != 1 {
+		t.Errorf("BOM not kept once at the start:\n%q", out)
+	}
+	want := "type User struct {\n\tName string\n\tAge  int\n}"
+	if got := structDecl(out, "User"); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+	// A second run leaves the file as it is
+	if again := process(t, out); again != out {
+		t.Errorf("rerun changed the file:\n%q\nwant:\n%q", again, out)
+	}
+}
+
+func TestImportAliasDedup(t *testing.T) {
+	tests := []struct {
+		name     string
+		existing string
+		request  string
+		use      string
+		want     string
+		warnings int
+	}{
+		{"aliased, plain requested", `u "net/url"`, `"net/url"`, "u", `import u "net/url"`, 1},
+		{"plain, aliased requested", `"net/url"`, `u "net/url"`, "url", `import "net/url"`, 1},
+		{"same alias", `u "net/url"`, `u "net/url"`, "u", `import u "net/url"`, 0},
+		{"package name as alias", `url "net/url"`, `"net/url"`, "url", `import url "net/url"`, 0},
+		{"raw string path", "u `net/url`", `"net/url"`, "u", `import u "net/url"`, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src := "package pb\n\nimport " + tt.existing + "\n\n// @goimport: " + tt.request + "\n\nvar _ = " + tt.use + ".URL{}\n"
+			p := newTestProcessor()
+			out := processSource(t, p, "test.pb.go", src)
+			// The existing import is kept, and no other added
+			if strings.Count(out, "net/url") != 2 || !strings.Contains(out, tt.want+"\n") {
+				t.Errorf("want only %s in:\n%s", tt.want, out)
+			}
+			if p.warnings != tt.warnings {
+				t.Errorf("got %d warnings, want %d", p.warnings, tt.warnings)
+			}
+		})
+	}
+}
+
+func TestNormalizeImports(t *testing.T) {
+	src := "package pb\n\nimport (\n\t\"time\"\n\t`strings`\n\t\"time\" // again\n\tt2 \"time\"\n)\n\n" +
+		"import \"fmt\"\nimport \"fmt\"\n\n" +
+		"type User struct {\n\tAt time.Time\n}\n\nvar _ = strings.ToUpper\nvar _ = fmt.Sprint\nvar _ = t2.Now\n"
+	path := filepath.Join(t.TempDir(), "test.pb.go")
+	if err := os.WriteFile(path, []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+	p := newTestProcessor()
+	p.NormalizeImports = true
+	var stats fileStats
+	var err error
+	out := captureStdout(t, func() { stats, err = p.processFile(path) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.CleanedImports != 3 {
+		t.Errorf("cleaned %d imports, want 3", stats.CleanedImports)
+	}
+	if p.warnings != 1 || !strings.Contains(out, `import "time" is present both as an unnamed import and as alias t2`) {
+		t.Errorf("got %d warnings:\n%s", p.warnings, out)
+	}
+
+	data, err := os.ReadFile(path + p.Suffix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := string(data)
+	for _, want := range []string{"\t\"strings\"\n", "\tt2 \"time\"\n", "import \"fmt\"\n"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("missing %q:\n%s", want, got)
+		}
+	}
+	if strings.Count(got, "\t\"time\"") != 1 || strings.Count(got, "import \"fmt\"") != 1 || strings.Contains(got, "again") {
+		t.Errorf("duplicates kept:\n%s", got)
+	}
+}
+
+func TestValidateImport(t *testing.T) {
+	tests := []struct {
+		imp   importEntry
+		valid bool
+	}{
+		{importEntry{Path: "gorm.io/gorm"}, true},
+		{importEntry{Path: "gorm.io/gorm", Alias: "g"}, true},
+		{importEntry{Path: "gorm.io/gorm", Alias: "_"}, true},
+		{importEntry{Path: "gorm.io/gorm", Alias: "."}, true},
+		{importEntry{Path: "gorm.io/gorm", Alias: "1g"}, false},
+		{importEntry{Path: "./local"}, false},
+		{importEntry{Path: "../shared/types"}, false},
+		{importEntry{Path: ".."}, false},
+		// Only whole path elements count, not dotted names
+		{importEntry{Path: ".hidden/pkg"}, true},
+		{importEntry{Path: ""}, false},
+		{importEntry{Path: "/usr/lib/pkg"}, false},
+		{importEntry{Path: "https://github.com/lib/pq"}, false},
+		{importEntry{Path: "github.com/lib pq"}, false},
+		{importEntry{Path: "github.com/lib/pq?v=1"}, false},
+		{importEntry{Path: "github.com//pq"}, false},
+		{importEntry{Path: "example.com/ünï/pkg"}, true},
+	}
+	for _, tt := range tests {
+		if err := validateImport(tt.imp); (err == nil) != tt.valid {
+			t.Errorf("validateImport(%+v) = %v, want valid %v", tt.imp, err, tt.valid)
+		}
+	}
+
+	path := filepath.Join(t.TempDir(), "test.pb.go")
+	src := "package pb\n\n// @goimport: \"./local\"\n\ntype User struct{}\n"
+	if err := os.WriteFile(path, []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+	_, err := newTestProcessor().processFile(path)
+	if err == nil || !strings.Contains(err.Error(), "line 3: invalid @goimport \"./local\"") {
+		t.Errorf("processFile error = %v", err)
+	}
+}
+
+func TestEmbedMutexFirst(t *testing.T) {
+	src := `package pb
+
+import (
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+)
+
+// @gotype: Counter
+// @gofield[0]: sync.Mutex
+
+type Counter struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Count int64 ` + "`protobuf:\"varint,1,opt,name=count,proto3\" json:\"count,omitempty\"`" + `
+}
+`
+	out := process(t, src)
+	want := "type Counter struct {\n\tsync.Mutex\n\tstate         protoimpl.MessageState\n"
+	if !strings.HasPrefix(structDecl(out, "Counter"), want) {
+		t.Errorf("mutex not embedded first:\n%s", out)
+	}
+	if !strings.Contains(out, "\t\"sync\"\n") {
+		t.Errorf("sync not imported:\n%s", out)
+	}
+	// A second run doesn't embed another mutex
+	if again := process(t, out); again != out {
+		t.Errorf("rerun changed the file:\n%s", again)
+	}
+}
+
+func TestToSnakeCase(t *testing.T) {
+	tests := map[string]string{
+		"UserID":      "user_id",
+		"HTTPServer":  "http_server",
+		"listen_addr": "listen_addr",
+		"ListenAddr":  "listen_addr",
+		"Port8080":    "port8080",
+		"Api_Key":     "api_key",
+	}
+	for name, want := range tests {
+		if got := toSnakeCase(name); got != want {
+			t.Errorf("toSnakeCase(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestEnvTags(t *testing.T) {
+	src := "package pb\n\n// @gotype: Config\n// @goenv: APP_\n\ntype Config struct {\n" +
+		"\tListenAddr string `protobuf:\"bytes,1,opt,name=listen_addr,proto3\" json:\"listen_addr,omitempty\"`\n" +
+		"\tDbURL string `protobuf:\"bytes,2,opt,name=db_url,proto3\" env:\"DATABASE_URL\"`\n" +
+		"\tPassword string `protobuf:\"bytes,3,opt,name=password,proto3\"` // @gotags: env:\"DB_PASSWORD\"\n" +
+		"\tMaxConns int32\n" +
+		"\tstate int\n" +
+		"}\n\ntype Other struct {\n\tName string\n}\n"
+	out := process(t, src)
+	config := structDecl(out, "Config")
+	for _, want := range []string{
+		`json:"listen_addr,omitempty" env:"APP_LISTEN_ADDR"`,
+		`env:"DATABASE_URL"`,
+		`env:"DB_PASSWORD"`,
+		"`env:\"APP_MAX_CONNS\"`",
+	} {
+		if !strings.Contains(config, want) {
+			t.Errorf("%s not found in:\n%s", want, config)
+		}
+	}
+	if !strings.Contains(config, "\tstate      int\n") {
+		t.Errorf("unexported field tagged:\n%s", config)
+	}
+	if strings.Contains(structDecl(out, "Other"), "env:") {
+		t.Errorf("struct without @goenv tagged:\n%s", out)
+	}
+	// A second run leaves the file as it is
+	if again := process(t, out); again != out {
+		t.Errorf("rerun changed the file:\n%s", again)
+	}
+}
+
+func TestValidateMethod(t *testing.T) {
+	src := `package pb
+
+import "errors"
+
+// @gotype: User
+// @govalidate: if x.Name == "" { return errors.New("name is required") }; return nil
+
+type User struct {
+	Name string
+}
+
+type Box[K comparable, V any] struct {
+	// @govalidate
+	Items map[K]V
+}
+
+type Done struct {
+	// @govalidate
+	Ok bool
+}
+
+func (x *Done) Validate() error { return nil }
+`
+	out := process(t, src)
+	for _, want := range []string{
+		"func (x *User) Validate() error {\n\tif x.Name == \"\" {\n\t\treturn errors.New(\"name is required\")\n\t}\n\treturn nil\n}\n",
+		"func (x *Box[K, V]) Validate() error {\n\treturn nil\n}\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("%s not found in:\n%s", want, out)
+		}
+	}
+	// The existing method is kept
+	if n := strings.Count(out, "func (x *Done) Validate()"); n != 1 {
+		t.Errorf("Done has %d Validate methods:\n%s", n, out)
+	}
+	// A second run doesn't add the methods again
+	if again := process(t, out); again != out {
+		t.Errorf("rerun changed the file:\n%s", again)
+	}
+}
+
+func TestInvalidValidateBody(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "test.pb.go")
+	src := "package pb\n\ntype User struct {\n\t// @govalidate: return nil }\n\tName string\n}\n"
+	if err := os.WriteFile(path, []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var err error
+	captureStdout(t, func() { _, err = newTestProcessor().processFile(path) })
+	if err == nil || !strings.Contains(err.Error(), "invalid @govalidate") {
+		t.Errorf("processFile error = %v", err)
+	}
+}
+
+func TestTrailingCommentAfterLastField(t *testing.T) {
+	src := `package pb
+
+// @gotype: User
+// @gofield: Age int
+// @gofield[before:Name]: Id int64
+
+type User struct {
+	Email string // contact address
+	Name  string // display name
+	// Fields below are deprecated.
+}
+`
+	// Each comment stays with its field, the free-standing one at the end
+	want := `type User struct {
+	Email string // contact address
+	Id    int64
+	Name  string // display name
+	Age   int
+	// Fields below are deprecated.
+}`
+	out := process(t, src)
+	if got := structDecl(out, "User"); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+	if again := process(t, out); again != out {
+		t.Errorf("rerun changed the file:\n%s", again)
+	}
+}
+
+func TestFieldPlacement(t *testing.T) {
+	src := `package pb
+
+// @gotype: User
+// @gofield[after:first_name]: LastName string
+// @gofield[before:Id]: TenantId string
+// @gofield[after:Model]: Version int
+// @gofield[after:Missing]: Extra bool
+
+type User struct {
+	gorm.Model
+	Id        string ` + "`protobuf:\"bytes,1,opt,name=id,proto3\"`" + `
+	FirstName string ` + "`protobuf:\"bytes,2,opt,name=first_name,proto3\"`" + `
+	Email     string
+}
+`
+	path := filepath.Join(t.TempDir(), "test.pb.go")
+	if err := os.WriteFile(path, []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+	p := newTestProcessor()
+	var err error
+	stdout := captureStdout(t, func() { _, err = p.processFile(path) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path + p.Suffix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := string(data)
+	var names []string
+	for _, line := range strings.Split(structDecl(out, "User"), "\n")[1:] {
+		if fields := strings.Fields(line); len(fields) > 0 && fields[0] != "}" {
+			names = append(names, fields[0])
+		}
+	}
+	want := []string{"gorm.Model", "Version", "TenantId", "Id", "FirstName", "LastName", "Email", "Extra"}
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("fields = %v, want %v", names, want)
+	}
+	if !strings.Contains(stdout, "field Missing not found in User, appending Extra bool") {
+		t.Errorf("missing field not reported:\n%s", stdout)
+	}
+	if again := process(t, out); again != out {
+		t.Errorf("rerun changed the file:\n%s", again)
+	}
+}
+
+func TestInlineStructFields(t *testing.T) {
+	tests := []struct {
+		decl string
+		want string
+	}{
+		{"Meta struct{ K string }", "Meta struct{ K string }"},
+		{"Meta struct{ Key string; Value string `json:\"value\"` }", "Meta struct {\n\t\tKey   string\n\t\tValue string `json:\"value\"`\n\t}"},
+		{"Points []struct{ X, Y int }", "Points []struct{ X, Y int }"},
+		// Inline structs can't be embedded
+		{"struct{ K string }", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.decl, func(t *testing.T) {
+			src := "package pb\n\n// @gotype: User\n// @gofield: " + tt.decl + "\n\ntype User struct {\n}\n"
+			want := "type User struct {\n\t" + tt.want + "\n}"
+			if tt.want == "" {
+				want = "type User struct {\n}"
+			}
+			out := process(t, src)
+			if got := structDecl(out, "User"); got != want {
+				t.Errorf("got:\n%s\nwant:\n%s", got, want)
+			}
+			if again := process(t, out); again != out {
+				t.Errorf("rerun changed the file:\n%s", again)
+			}
+		})
+	}
+}
+
+func TestOnlyTypes(t *testing.T) {
+	src := "package pb\n\ntype User struct {\n" +
+		"\t// @gofield: Age int\n" +
+		"\tName string `json:\"name\"` // @gotags: gorm:\"column:name\"\n" +
+		"}\n\ntype Order struct {\n" +
+		"\t// @goimport: \"time\"\n" +
+		"\t// @gofield: Total int64\n" +
+		"\tId string\n" +
+		"}\n"
+	p := newTestProcessor()
+	if err := (onlyFlag{p}).Set("User, Account"); err != nil {
+		t.Fatal(err)
+	}
+	out := processSource(t, p, "test.pb.go", src)
+	user := structDecl(out, "User")
+	if !strings.Contains(user, "\tAge  int\n") || !strings.Contains(user, `gorm:"column:name"`) {
+		t.Errorf("User not enhanced:\n%s", user)
+	}
+	if strings.Contains(structDecl(out, "Order"), "\tTotal") || strings.Contains(out, "import \"time\"") || strings.Contains(out, "\t\"time\"") {
+		t.Errorf("Order enhanced:\n%s", out)
+	}
+}
+
+func TestImportMatrix(t *testing.T) {
+	states := []struct {
+		name    string
+		imports string
+		use     string
+		urlName string // Name net/url is imported under, if at all
+	}{
+		{"none", "", "", ""},
+		{"single", "import \"net/url\"\n", "var _ = url.URL{}\n", "url"},
+		{"block", "import (\n\t\"fmt\"\n\t\"net/url\"\n)\n", "var _ = fmt.Sprint(url.URL{})\n", "url"},
+		{"aliased", "import u \"net/url\"\n", "var _ = u.URL{}\n", "u"},
+		{"blank", "import _ \"net/url\"\n", "", ""},
+		{"dot", "import . \"net/url\"\n", "var _ = URL{}\n", "."},
+	}
+	injections := []struct {
+		annotation string
+		use        string
+		urlName    string // Name net/url is requested under, if at all
+	}{
+		{`"net/url"`, "url.URL", "url"},
+		{`nu "net/url"`, "nu.URL", "nu"},
+		{`"strings"`, "strings.Builder", ""},
+	}
+	for _, state := range states {
+		for _, inj := range injections {
+			t.Run(state.name+"/"+inj.annotation, func(t *testing.T) {
+				src := "package pb\n\n" + state.imports + "\n// @goimport: " + inj.annotation +
+					"\n// @gotype: User\n// @gofield: Extra " + inj.use + "\n\ntype User struct {\n\tName string\n}\n\n" + state.use
+				p := newTestProcessor()
+				out := processSource(t, p, "test.pb.go", src)
+				if len(p.failures) > 0 {
+					t.Fatalf("processing failed: %s", p.failures[0].Message)
+				}
+				// An import already present under another name is kept, with
+				// a warning
+				conflict := state.urlName != "" && inj.urlName != "" && state.urlName != inj.urlName
+				if got := p.warnings > 0; got != conflict {
+					t.Errorf("warned = %v, want %v", got, conflict)
+				}
+
+				if formatted, err := format.Source([]byte(out)); err != nil {
+					t.Fatalf("output doesn't parse: %v\n%s", err, out)
+				} else if string(formatted) != out {
+					t.Errorf("output isn't gofmt'd:\n%s", out)
+				}
+				file, err := parser.ParseFile(token.NewFileSet(), "", out, parser.ImportsOnly)
+				if err != nil {
+					t.Fatal(err)
+				}
+				// Each path is imported once, except that a blank import
+				// needs another one for its name
+				seen := make(map[string]int)
+				for _, imp := range file.Imports {
+					path, _ := strconv.Unquote(imp.Path.Value)
+					if imp.Name == nil || imp.Name.Name != "_" {
+						seen[path]++
+					}
+				}
+				for path, n := range seen {
+					if n > 1 {
+						t.Errorf("%s imported %d times:\n%s", path, n, out)
+					}
+				}
+				path, _ := strconv.Unquote(strings.Fields(inj.annotation)[len(strings.Fields(inj.annotation))-1])
+				if seen[path] != 1 {
+					t.Errorf("%s not imported:\n%s", path, out)
+				}
+				if again := process(t, out); again != out {
+					t.Errorf("rerun changed the file:\n%s", again)
+				}
+			})
+		}
+	}
+}
+
+func TestMaxParse(t *testing.T) {
+	dir := t.TempDir()
+	var files []string
+	for i := 0; i < 8; i++ {
+		path := filepath.Join(dir, "f"+strconv.Itoa(i)+".pb.go")
+		src := "package pb\n\ntype User struct {\n\t// @gofield: Age int\n}\n"
+		if i == 3 {
+			src = "package pb\n\ntype User struct {\n\t// @gofield: Age\n"
+		}
+		if err := os.WriteFile(path, []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, path)
+	}
+
+	p := newTestProcessor()
+	p.parseSlots = make(chan struct{}, 2)
+	captureStdout(t, func() {
+		var wg sync.WaitGroup
+		for _, path := range files {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				p.run(path)
+			}()
+		}
+		wg.Wait()
+	})
+	// A file that fails to parse gives its slot back too
+	if len(p.parseSlots) != 0 {
+		t.Errorf("%d parse slots still taken", len(p.parseSlots))
+	}
+	if len(p.failures) != 1 {
+		t.Errorf("got %d failures, want 1", len(p.failures))
+	}
+	for i, path := range files {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := strings.Contains(string(data), "\tAge int\n"); got != (i != 3) {
+			t.Errorf("%s: field injected = %v", path, got)
+		}
+	}
+}
+
+func TestRequireAnnotations(t *testing.T) {
+	dir := t.TempDir()
+	plain := filepath.Join(dir, "plain.pb.go")
+	annotated := filepath.Join(dir, "annotated.pb.go")
+	files := map[string]string{
+		plain:     "package pb\n\ntype User struct {\n\tName string\n}\n",
+		annotated: "package pb\n\ntype User struct {\n\t// @gofield: Age int\n\tName string\n}\n",
+	}
+	for path, src := range files {
+		if err := os.WriteFile(path, []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	p := newTestProcessor()
+	p.RequireAnnotations = true
+	captureStdout(t, func() {
+		p.run(plain)
+		p.run(annotated)
+		// A rerun finds the annotations applied the first time
+		p.run(annotated)
+	})
+	if len(p.failures) != 1 || p.failures[0].File != plain {
+		t.Errorf("failures = %+v, want only %s", p.failures, plain)
+	}
+	if _, err := os.Stat(plain + p.Suffix); !os.IsNotExist(err) {
+		t.Errorf("output written for a failed file: %v", err)
+	}
+}
+
+func TestImportPathTrimmed(t *testing.T) {
+	src := "package pb\n\n// @goimport: \" gorm.io/gorm/ \"\n// @goimport: \"gorm.io/gorm\"\n\ntype User struct {\n\t// @gofield: DB *gorm.DB\n}\n"
+	out := process(t, src)
+	if strings.Count(out, "\t\"gorm.io/gorm\"\n") != 1 {
+		t.Errorf("import not trimmed or added twice:\n%s", out)
+	}
+}
+
+func TestMultiNameFields(t *testing.T) {
+	src := `package pb
+
+// @gotype: Point
+// @gofield: Y int
+// @gofield: Z int
+// @gotags(Y): json:"y"
+
+type Point struct {
+	X, Y int
+}
+`
+	// Y is declared already, as the second name of X, Y
+	want := "type Point struct {\n\tX, Y int `json:\"y\"`\n\tZ    int\n}"
+	out := process(t, src)
+	if got := structDecl(out, "Point"); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+	if again := process(t, out); again != out {
+		t.Errorf("rerun changed the file:\n%s", again)
+	}
+}
+
+func TestReplaceField(t *testing.T) {
+	src := `package pb
+
+import "gorm.io/gorm"
+
+// @gotype: Order
+// @goreplacefield: Status OrderStatus
+// @goreplacefield: CreatedAt time.Time ` + "`gorm:\"autoCreateTime\"`" + `
+// @goreplacefield: *gorm.Model
+// @goreplacefield: B int64
+// @goreplacefield: Note string ` + "`json:\"note\"`" + `
+
+type Order struct {
+	gorm.Model
+	Status    string ` + "`protobuf:\"bytes,1,opt,name=status,proto3\" json:\"status,omitempty\"`" + `
+	CreatedAt int64  ` + "`protobuf:\"varint,2,opt,name=created_at,json=createdAt,proto3\" json:\"created_at,omitempty\"`" + `
+	A, B      int32
+}
+
+type OrderStatus string
+`
+	want := `type Order struct {
+	*gorm.Model
+	Status    OrderStatus ` + "`protobuf:\"bytes,1,opt,name=status,proto3\" json:\"status,omitempty\"`" + `
+	CreatedAt time.Time   ` + "`protobuf:\"varint,2,opt,name=created_at,json=createdAt,proto3\" json:\"created_at,omitempty\" gorm:\"autoCreateTime\"`" + `
+	A         int32
+	B         int64
+	Note      string ` + "`json:\"note\"`" + `
+}`
+	out := process(t, src)
+	if got := structDecl(out, "Order"); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+	if !strings.Contains(out, "\t\"time\"\n") {
+		t.Errorf("time not imported:\n%s", out)
+	}
+	if again := process(t, out); again != out {
+		t.Errorf("rerun changed the file:\n%s", again)
+	}
+}
+
+func TestImportIfUsed(t *testing.T) {
+	src := `package pb
+
+// @goimport(used): "gorm.io/gorm"
+// @goimport(used): "github.com/google/uuid"
+// @goimport(used): dec "github.com/shopspring/decimal"
+
+type User struct {
+	// @gofield: DB *gorm.DB
+	// @gofield: Price dec.Decimal
+	Name string
+}
+`
+	out := process(t, src)
+	for _, want := range []string{"\t\"gorm.io/gorm\"\n", "\tdec \"github.com/shopspring/decimal\"\n"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("missing import %q:\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, "\t\"github.com/google/uuid\"") {
+		t.Errorf("unused import added:\n%s", out)
+	}
+
+	// A plain @goimport of the same path always adds it
+	src = "package pb\n\n// @goimport(used): \"github.com/google/uuid\"\n// @goimport: \"github.com/google/uuid\"\n\ntype User struct{}\n"
+	if out := process(t, src); !strings.Contains(out, "\t\"github.com/google/uuid\"\n") {
+		t.Errorf("import not added:\n%s", out)
+	}
+
+	path := filepath.Join(t.TempDir(), "test.pb.go")
+	src = "package pb\n\n// @goimport(maybe): \"github.com/google/uuid\"\n\ntype User struct{}\n"
+	if err := os.WriteFile(path, []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := newTestProcessor().processFile(path); err == nil || !strings.Contains(err.Error(), `invalid @goimport option "maybe"`) {
+		t.Errorf("got error %v", err)
+	}
+}
+
+func TestSplitTypeBlocks(t *testing.T) {
+	src := `package pb
+
+// @gotype: User
+// @gofield: Age int
+// @gomethod: Adult() bool { return x.Age >= 18 }
+
+// @gotype: Account
+
+type Account struct {
+	Id int64
+}
+
+type User struct {
+	// @gotype: User
+	// @gotags(Name): json:"name"
+	// @gofield: Email string
+	Name string
+}
+
+// @gotype: User
+// @gotags(Age): json:"age"
+`
+	want := "type User struct {\n\t// @gotype: User\n\t// @gotags(Name): json:\"name\"\n\t// @gofield: Email string\n" +
+		"\tName  string `json:\"name\"`\n\tAge   int    `json:\"age\"`\n\tEmail string\n}"
+	out := process(t, src)
+	if got := structDecl(out, "User"); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+	if !strings.Contains(out, "func (x *User) Adult() bool") {
+		t.Errorf("method from the first block missing:\n%s", out)
+	}
+}
+
+func TestTagsFromSeveralBlocks(t *testing.T) {
+	src := "package pb\n\n// @gotype: User\n// @gotags(Name): json:\"name\"\n\ntype User struct {\n" +
+		"\tName string // @gotags: yaml:\"name\"\n}\n\n// @gotype: User\n// @gotags(Name): json:\"full_name\" db:\"name\"\n"
+	// The later block wins for json, and every block's keys are kept
+	want := "type User struct {\n\tName string `json:\"full_name\" yaml:\"name\" db:\"name\"` // @gotags: yaml:\"name\"\n}"
+	if got := structDecl(process(t, src), "User"); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestEmbedQualifiedInterfaces(t *testing.T) {
+	src := `package pb
+
+// @gotype: Stream
+// @gofield: io.ReadCloser
+// @gofield[0]: fmt.Stringer
+// @gofield: http.Handler
+
+type Stream struct {
+	Id int64
+}
+`
+	want := "type Stream struct {\n\tfmt.Stringer\n\tId int64\n\tio.ReadCloser\n\thttp.Handler\n}"
+	out := process(t, src)
+	if got := structDecl(out, "Stream"); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+	for _, path := range []string{`"fmt"`, `"io"`, `"net/http"`} {
+		if strings.Count(out, path) != 1 {
+			t.Errorf("want %s imported once:\n%s", path, out)
+		}
+	}
+	if again := process(t, out); again != out {
+		t.Errorf("rerun changed the file:\n%s", again)
+	}
+}
+
+func TestInjectedFieldClash(t *testing.T) {
+	src := `package pb
+
+import "context"
+
+// @gotype: User
+// @gofield: context.Context
+// @gofield: Name int
+// @gofield: Id int64
+
+type User struct {
+	Context string
+	Name    string
+	Id      int64
+}
+`
+	path := filepath.Join(t.TempDir(), "test.pb.go")
+	if err := os.WriteFile(path, []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+	p := newTestProcessor()
+	out := captureStdout(t, func() {
+		if _, err := p.processFile(path); err != nil {
+			t.Fatal(err)
+		}
+	})
+	for _, want := range []string{
+		"not adding context.Context to User, it already has Context string\n",
+		"not adding Name int to User, it already has Name string; use @goreplacefield to change it\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("missing warning %q:\n%s", want, out)
+		}
+	}
+	// A field injected by an earlier run isn't a clash
+	if p.warnings != 2 {
+		t.Errorf("got %d warnings, want 2:\n%s", p.warnings, out)
+	}
+}
+
+func TestFormatFailureLeavesFile(t *testing.T) {
+	src := "package pb\n\n// @gotype: User\n// @gofield: Age int\n// @gomethod: Adult() bool { return x.Age >= 18 }\n\ntype User struct {\n\tName string\n}\n"
+	for _, failOn := range []string{"file", "method"} {
+		t.Run(failOn, func(t *testing.T) {
+			defer func(orig func(io.Writer, *token.FileSet, any) error) { formatNode = orig }(formatNode)
+			formatNode = func(w io.Writer, fset *token.FileSet, node any) error {
+				// Write part of the output first, like the printer can
+				io.WriteString(w, "package pb\n\ntype User stru")
+				if _, isFile := node.(*ast.File); isFile == (failOn == "file") {
+					return errors.New("broken node")
+				}
+				return nil
+			}
+
+			dir := t.TempDir()
+			path := filepath.Join(dir, "test.pb.go")
+			if err := os.WriteFile(path, []byte(src), 0644); err != nil {
+				t.Fatal(err)
+			}
+			p := newTestProcessor()
+			captureStdout(t, func() { p.run(path) })
+			if len(p.failures) != 1 || !strings.Contains(p.failures[0].Message, "failed to format output: broken node") {
+				t.Fatalf("failures = %v, want a format error", p.failures)
+			}
+			if out, err := os.ReadFile(path); err != nil || string(out) != src {
+				t.Errorf("file changed: %v\n%s", err, out)
+			}
+			// Nothing is left next to it either
+			entries, err := os.ReadDir(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(entries) != 1 {
+				t.Errorf("want only the input file, got %d entries", len(entries))
+			}
+		})
+	}
+}
+
+func TestOneLineStruct(t *testing.T) {
+	src := `package pb
+
+// @gotype: Marker
+// @gofield: Name string
+// @gofield[0]: sync.Mutex
+// @gotags(Name): json:"name"
+
+type Marker struct{}
+
+type Other struct{}
+`
+	want := "type Marker struct {\n\tsync.Mutex\n\tName string `json:\"name\"`\n}\n\ntype Other struct{}\n"
+	out := process(t, src)
+	if !strings.Contains(out, want) {
+		t.Errorf("want:\n%s\nin:\n%s", want, out)
+	}
+	if again := process(t, out); again != out {
+		t.Errorf("rerun changed the file:\n%s", again)
+	}
+}
+
+func TestImportIntoPackageOnlyFile(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want string
+	}{
+		{
+			"bare",
+			"package pb // @goimport: \"fmt\"\n",
+			"package pb // @goimport: \"fmt\"\n\nimport (\n\t\"fmt\"\n)\n",
+		},
+		{
+			"doc and trailing comments",
+			"// Package pb is a stub.\npackage pb // @goimport: \"fmt\"\n\n// trailing note\n",
+			"// Package pb is a stub.\npackage pb // @goimport: \"fmt\"\n\nimport (\n\t\"fmt\"\n)\n\n// trailing note\n",
+		},
+		{
+			"annotation below",
+			"package pb\n\n// @goimport: \"fmt\"\n",
+			"package pb\n\nimport (\n\t\"fmt\"\n)\n\n// @goimport: \"fmt\"\n",
+		},
+		{
+			"declarations but no imports",
+			"package pb // @goimport: \"fmt\"\n\nvar _ = fmt.Sprint\n",
+			"package pb // @goimport: \"fmt\"\n\nimport (\n\t\"fmt\"\n)\n\nvar _ = fmt.Sprint\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			want := enhancedMarker + "\n\n" + tt.want
+			out := process(t, tt.src)
+			if out != want {
+				t.Errorf("got:\n%s\nwant:\n%s", out, want)
+			}
+			if again := process(t, out); again != out {
+				t.Errorf("rerun changed the file:\n%s", again)
+			}
+		})
+	}
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// tagMergers combine an existing tag value with a new one for keys whose
+// values hold several options, selected per key with --merge key=strategy.
+// Keys without a strategy have their value replaced.
+var tagMergers = map[string]func(existing, value string) string{
+	"replace": func(existing, value string) string { return value },
+	"gorm":    mergeGormValue,
+	"json":    mergeJSONValue,
+}
+
+// mergeFlag collects --merge key=strategy settings
+type mergeFlag struct {
+	p *Processor
+}
+
+func (f mergeFlag) String() string {
+	return ""
+}
+
+func (f mergeFlag) Set(value string) error {
+	key, strategy, ok := strings.Cut(value, "=")
+	if !ok || strings.TrimSpace(key) == "" {
+		return fmt.Errorf("expected key=strategy, got %q", value)
+	}
+	strategy = strings.TrimSpace(strategy)
+	if _, ok := tagMergers[strategy]; !ok {
+		return fmt.Errorf("unknown merge strategy %q", strategy)
+	}
+	if f.p.MergeStrategies == nil {
+		f.p.MergeStrategies = make(map[string]string)
+	}
+	f.p.MergeStrategies[strings.TrimSpace(key)] = strategy
+	return nil
+}
+
+// mergeGormValue merges gorm's semicolon-separated options, e.g.
+// "column:id;type:varchar(64)" with "type:text;not null" gives
+// "column:id;type:text;not null". Options are matched by name, ignoring
+// case like gorm does. Existing options named in the new value are replaced
+// by all of its options of that name, in place of the first one; the others,
+// including repeated ones such as index, are kept. New options are appended.
+func mergeGormValue(existing, value string) string {
+	newOptions := make(map[string][]string)
+	var newNames []string
+	for _, option := range splitGormOptions(value) {
+		name := gormOptionName(option)
+		if _, ok := newOptions[name]; !ok {
+			newNames = append(newNames, name)
+		}
+		newOptions[name] = append(newOptions[name], option)
+	}
+
+	var options []string
+	used := make(map[string]bool)
+	for _, option := range splitGormOptions(existing) {
+		name := gormOptionName(option)
+		if _, ok := newOptions[name]; !ok {
+			options = append(options, option)
+			continue
+		}
+		if !used[name] {
+			options = append(options, newOptions[name]...)
+			used[name] = true
+		}
+	}
+	for _, name := range newNames {
+		if !used[name] {
+			options = append(options, newOptions[name]...)
+		}
+	}
+	return strings.Join(options, ";")
+}
+
+// splitGormOptions splits a gorm tag value, as written in the tag literal,
+// into its non-empty options. Semicolons inside single quotes
+// (default:'a;b') or escaped for gorm (a backslash before them in the tag's
+// value, \\; in the literal) don't separate options, and other escape
+// sequences (\") are skipped whole.
+func splitGormOptions(value string) []string {
+	var options []string
+	add := func(option string) {
+		if option = strings.TrimSpace(option); option != "" {
+			options = append(options, option)
+		}
+	}
+	start := 0
+	quoted := false
+	for i := 0; i < len(value); i++ {
+		switch value[i] {
+		case '\\':
+			if strings.HasPrefix(value[i:], `\\;`) {
+				i += 2
+			} else {
+				i++
+			}
+		case '\'':
+			quoted = !quoted
+		case ';':
+			if !quoted {
+				add(value[start:i])
+				start = i + 1
+			}
+		}
+	}
+	add(value[start:])
+	return options
+}
+
+// gormOptionName returns the lowercased name of a gorm option
+func gormOptionName(option string) string {
+	name, _, _ := strings.Cut(option, ":")
+	return strings.ToLower(strings.TrimSpace(name))
+}
+
+// mergeJSONValue merges json-style values: a name followed by comma-separated
+// options. A new name replaces the existing one while an empty one keeps
+// it, and options are accumulated, so "id,omitempty" with ",string" gives
+// "id,omitempty,string".
+func mergeJSONValue(existing, value string) string {
+	existingName, existingOptions, _ := strings.Cut(existing, ",")
+	name, options, _ := strings.Cut(value, ",")
+	if name == "" {
+		name = existingName
+	}
+
+	merged := []string{name}
+	for _, option := range append(strings.Split(existingOptions, ","), strings.Split(options, ",")...) {
+		option = strings.TrimSpace(option)
+		if option != "" && !slices.Contains(merged[1:], option) {
+			merged = append(merged, option)
+		}
+	}
+	return strings.Join(merged, ",")
+}
+
+// editOptions adds (+name) and removes (-name) options of a tag value. Gorm
+// style values are ;-separated options matched by name, where adding an
+// option replaces one of the same name; other values are a name followed by
+// ,-separated options, like json, and the name is never touched.
+func editOptions(value string, gormStyle bool, ops []string) string {
+	for _, op := range ops {
+		option := op[1:]
+		switch {
+		case op[0] == '+' && gormStyle:
+			value = mergeGormValue(value, option)
+		case op[0] == '+':
+			value = mergeJSONValue(value, ","+option)
+		case gormStyle:
+			var kept []string
+			for _, existing := range splitGormOptions(value) {
+				if gormOptionName(existing) != strings.ToLower(option) {
+					kept = append(kept, existing)
+				}
+			}
+			value = strings.Join(kept, ";")
+		default:
+			name, options, _ := strings.Cut(value, ",")
+			kept := []string{name}
+			for _, existing := range strings.Split(options, ",") {
+				if existing = strings.TrimSpace(existing); existing != "" && existing != option {
+					kept = append(kept, existing)
+				}
+			}
+			value = strings.Join(kept, ",")
+		}
+	}
+	return value
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMergeGormValue(t *testing.T) {
+	tests := []struct {
+		existing, value string
+		want            string
+	}{
+		{"column:id;type:varchar(64)", "type:text;not null", "column:id;type:text;not null"},
+		{"column:id;TYPE:varchar(64)", "type:text", "column:id;type:text"},
+		{"", "primaryKey", "primaryKey"},
+		{"primaryKey;", " ;autoIncrement", "primaryKey;autoIncrement"},
+		// Repeated options are kept unless the new value names them
+		{"index:a;index:b", "type:text", "index:a;index:b;type:text"},
+		{"index:a;column:id;index:b", "index:c", "index:c;column:id"},
+		{"column:id", "index:a;index:b", "column:id;index:a;index:b"},
+		// Quoted and escaped semicolons don't separate options
+		{"default:'a;b';type:text", "not null", "default:'a;b';type:text;not null"},
+		{`check:a\\;b;type:text`, "type:varchar(8)", `check:a\\;b;type:varchar(8)`},
+		{"default:'x'", "default:'y;z'", "default:'y;z'"},
+	}
+	for _, tt := range tests {
+		if got := mergeGormValue(tt.existing, tt.value); got != tt.want {
+			t.Errorf("mergeGormValue(%q, %q) = %q, want %q", tt.existing, tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestMergeJSONValue(t *testing.T) {
+	tests := []struct {
+		existing, value string
+		want            string
+	}{
+		{"id,omitempty", ",string", "id,omitempty,string"},
+		{"id,omitempty", "uid", "uid,omitempty"},
+		{"id", "uid,omitempty", "uid,omitempty"},
+		{"id,omitempty", ",omitempty", "id,omitempty"},
+		{"", "-", "-"},
+		{"id", "", "id"},
+	}
+	for _, tt := range tests {
+		if got := mergeJSONValue(tt.existing, tt.value); got != tt.want {
+			t.Errorf("mergeJSONValue(%q, %q) = %q, want %q", tt.existing, tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestMergeTagsStrategies(t *testing.T) {
+	existing := []tagPair{{"json", "id"}, {"gorm", "column:id;index:a"}}
+	newTags := []tagPair{{"json", "uid"}, {"gorm", "index:b;not null"}}
+	got := mergeTags(existing, newTags, true, map[string]string{"gorm": "gorm"})
+	want := []tagPair{{"json", "uid"}, {"gorm", "column:id;index:b;not null"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("mergeTags = %v, want %v", got, want)
+	}
+}
+
+func TestMergeFlag(t *testing.T) {
+	p := newTestProcessor()
+	if err := (mergeFlag{p}).Set(" gorm = gorm "); err != nil {
+		t.Fatal(err)
+	}
+	if p.MergeStrategies["gorm"] != "gorm" {
+		t.Errorf("MergeStrategies = %v", p.MergeStrategies)
+	}
+	for _, value := range []string{"gorm", "=gorm", "gorm=unknown"} {
+		if err := (mergeFlag{p}).Set(value); err == nil {
+			t.Errorf("Set(%q) succeeded", value)
+		}
+	}
+}
+
+func TestInjectMergedGormTags(t *testing.T) {
+	src := "package pb\n\ntype User struct {\n" +
+		"\tName string `gorm:\"column:name;index:a;index:b\"` // @gotags: gorm:\"type:text\"\n" +
+		"}\n"
+	p := newTestProcessor()
+	p.MergeStrategies = map[string]string{"gorm": "gorm"}
+	out := processSource(t, p, "test.pb.go", src)
+	if want := "`gorm:\"column:name;index:a;index:b;type:text\"`"; !strings.Contains(out, want) {
+		t.Errorf("tag %s not found in:\n%s", want, out)
+	}
+}
+
+func TestEditOptions(t *testing.T) {
+	tests := []struct {
+		value     string
+		gormStyle bool
+		ops       []string
+		want      string
+	}{
+		{"id", false, []string{"+omitempty"}, "id,omitempty"},
+		{"id,omitempty", false, []string{"+omitempty"}, "id,omitempty"},
+		{"id,omitempty,string", false, []string{"-omitempty"}, "id,string"},
+		{"id,omitempty", false, []string{"-omitempty", "+string"}, "id,string"},
+		{"", false, []string{"+omitempty"}, ",omitempty"},
+		{"column:id;index", true, []string{"+type:text"}, "column:id;index;type:text"},
+		{"column:id;type:varchar(64)", true, []string{"+type:text"}, "column:id;type:text"},
+		{"column:id;autoIncrement;index", true, []string{"-autoIncrement"}, "column:id;index"},
+		{"column:id;INDEX", true, []string{"-index"}, "column:id"},
+		{"index:a;column:id;index:b", true, []string{"-index"}, "column:id"},
+	}
+	for _, tt := range tests {
+		if got := editOptions(tt.value, tt.gormStyle, tt.ops); got != tt.want {
+			t.Errorf("editOptions(%q, %v, %q) = %q, want %q", tt.value, tt.gormStyle, tt.ops, got, tt.want)
+		}
+	}
+}
+
+func TestTagOptAnnotation(t *testing.T) {
+	tests := []struct {
+		name string
+		line string
+		want string
+	}{
+		{
+			"add",
+			"Name string `protobuf:\"bytes,1,opt,name=name,proto3\" json:\"name\"` // @gotagopt: json +omitempty",
+			"`protobuf:\"bytes,1,opt,name=name,proto3\" json:\"name,omitempty\"`",
+		},
+		{
+			"remove",
+			"Name string `protobuf:\"bytes,1,opt,name=name,proto3\" json:\"name,omitempty\"` // @gotagopt: json -omitempty",
+			"`protobuf:\"bytes,1,opt,name=name,proto3\" json:\"name\"`",
+		},
+		{
+			"missing key",
+			"Name string `protobuf:\"bytes,1,opt,name=name,proto3\"` // @gotagopt: gorm +index",
+			"`protobuf:\"bytes,1,opt,name=name,proto3\" gorm:\"index\"`",
+		},
+		{
+			"skipped field",
+			"Name string `protobuf:\"bytes,1,opt,name=name,proto3\" json:\"-\"` // @gotagopt: json +omitempty",
+			"`protobuf:\"bytes,1,opt,name=name,proto3\" json:\"-\"`",
+		},
+		{
+			"explicit target",
+			"// @gotagopt(Name): gorm -autoIncrement +type:text\n\tName string `gorm:\"column:name;autoIncrement\"`",
+			"`gorm:\"column:name;type:text\"`",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := process(t, "package pb\n\ntype User struct {\n\t"+tt.line+"\n}\n")
+			if !strings.Contains(out, tt.want) {
+				t.Errorf("tag %s not found in:\n%s", tt.want, out)
+			}
+		})
+	}
+}
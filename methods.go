@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"strings"
+)
+
+// defaultValidateBody is the body of an injected Validate method when the
+// annotation doesn't give one
+const defaultValidateBody = "return nil"
+
+// hasMethod reports whether the file declares a method with the given name
+// on the named type, with either a value or a pointer receiver
+func hasMethod(astFile *ast.File, typeName, method string) bool {
+	for _, decl := range astFile.Decls {
+		funcDecl, ok := decl.(*ast.FuncDecl)
+		if !ok || funcDecl.Recv == nil || len(funcDecl.Recv.List) == 0 || funcDecl.Name.Name != method {
+			continue
+		}
+		recvType := funcDecl.Recv.List[0].Type
+		if star, ok := recvType.(*ast.StarExpr); ok {
+			recvType = star.X
+		}
+		// Generic receivers (Box[K, V]) name the type before their parameters
+		switch t := recvType.(type) {
+		case *ast.IndexExpr:
+			recvType = t.X
+		case *ast.IndexListExpr:
+			recvType = t.X
+		}
+		if ident, ok := recvType.(*ast.Ident); ok && ident.Name == typeName {
+			return true
+		}
+	}
+	return false
+}
+
+// typeParamNames returns the type parameters of a generic type as its
+// methods' receivers list them, e.g. [K, V] for Box[K comparable, V any],
+// or "" for other types
+func typeParamNames(typeSpec *ast.TypeSpec) string {
+	if typeSpec.TypeParams == nil {
+		return ""
+	}
+	var names []string
+	for _, param := range typeSpec.TypeParams.List {
+		for _, ident := range param.Names {
+			names = append(names, ident.Name)
+		}
+	}
+	return "[" + strings.Join(names, ", ") + "]"
+}
+
+// receiverType returns the receiver type of methods injected into a type:
+// a pointer for structs and the value for other types, like the methods
+// protoc-gen-go generates for messages and enums. Generic types are
+// instantiated with their type parameters, from typeArgs (e.g. [K, V]).
+func receiverType(typeName string, structNames map[string]bool, typeArgs map[string]string) string {
+	recv := typeName + typeArgs[typeName]
+	if structNames[typeName] {
+		return "*" + recv
+	}
+	return recv
+}
+
+// createMethod parses a method declaration, given without the func keyword
+// and receiver (e.g. TableName() string { return "users" }), as a method on
+// recv named x. It is parsed as a file of its own in fset.
+func createMethod(fset *token.FileSet, recv, decl string) (*ast.FuncDecl, error) {
+	src := fmt.Sprintf("package p\n\nfunc (x %s) %s\n", recv, decl)
+	file, err := parser.ParseFile(fset, "", src, 0)
+	if err != nil {
+		return nil, err
+	}
+	if len(file.Decls) != 1 {
+		return nil, fmt.Errorf("must declare a single method")
+	}
+	funcDecl, ok := file.Decls[0].(*ast.FuncDecl)
+	if !ok || funcDecl.Body == nil {
+		return nil, fmt.Errorf("must declare a single method with a body")
+	}
+	return funcDecl, nil
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"go/token"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestEnumMethods(t *testing.T) {
+	src := `package pb
+
+type Status int32
+
+const (
+	Status_UNKNOWN Status = 0
+	Status_ACTIVE  Status = 1
+)
+
+// @gotype: Status
+// @gomethod: IsActive() bool { return x == Status_ACTIVE }
+// @gomethod: Label() string { return fmt.Sprint(int32(x)) }
+// @gomethod: IsActive() bool { return false }
+// @gofield: Name string
+// @gotags: json:"status"
+
+type User struct {
+	Name string
+}
+`
+	path := filepath.Join(t.TempDir(), "test.pb.go")
+	if err := os.WriteFile(path, []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+	p := newTestProcessor()
+	var err error
+	stdout := captureStdout(t, func() { _, err = p.processFile(path) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path + p.Suffix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := string(data)
+
+	// Enums get value receivers, and the first method of a name wins
+	for _, want := range []string{
+		"func (x Status) IsActive() bool { return x == Status_ACTIVE }\n",
+		"func (x Status) Label() string { return fmt.Sprint(int32(x)) }\n",
+		"\t\"fmt\"\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("%s not found in:\n%s", want, out)
+		}
+	}
+	if n := strings.Count(out, ") IsActive()"); n != 1 {
+		t.Errorf("IsActive added %d times:\n%s", n, out)
+	}
+
+	// Fields and tags only apply to structs
+	for _, want := range []string{
+		"ignoring @gofield: Name string, Status is not a struct",
+		"ignoring @gotags: json:\"status\", Status is not a struct",
+	} {
+		if !strings.Contains(stdout, want) {
+			t.Errorf("%q not reported:\n%s", want, stdout)
+		}
+	}
+	if p.warnings != 2 {
+		t.Errorf("got %d warnings, want 2:\n%s", p.warnings, stdout)
+	}
+
+	if again := process(t, out); again != out {
+		t.Errorf("rerun changed the file:\n%s", again)
+	}
+}
+
+func TestStructMethods(t *testing.T) {
+	src := `package pb
+
+type User struct {
+	// @gomethod: TableName() string { return "users" }
+	Name string
+}
+
+type Box[K comparable, V any] struct {
+	// @gomethod: Len() int { return len(x.Items) }
+	Items map[K]V
+}
+`
+	out := process(t, src)
+	for _, want := range []string{
+		"func (x *User) TableName() string { return \"users\" }\n",
+		"func (x *Box[K, V]) Len() int { return len(x.Items) }\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("%s not found in:\n%s", want, out)
+		}
+	}
+}
+
+func TestInvalidMethod(t *testing.T) {
+	for _, decl := range []string{"Len() int", "Len() int { return 0 }; var y int", "{ return }"} {
+		if _, err := createMethod(token.NewFileSet(), "*User", decl); err == nil {
+			t.Errorf("createMethod(%q) succeeded", decl)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"go/ast"
+	"go/token"
+	"sort"
+	"strings"
+)
+
+// isOneofField reports whether a field is the interface field protoc-gen-go
+// declares for a oneof, tagged protobuf_oneof
+func isOneofField(field *ast.Field) bool {
+	tags, _ := fieldTags(field)
+	for _, tag := range tags {
+		if tag.Key == "protobuf_oneof" {
+			return true
+		}
+	}
+	return false
+}
+
+// oneofWrappers returns the wrapper structs of a oneof's cases: the types
+// whose pointer has the marker method of the oneof's interface, e.g.
+// (*User_Email).isUser_Contact
+func oneofWrappers(astFile *ast.File, field *ast.Field) []string {
+	iface, ok := field.Type.(*ast.Ident)
+	if !ok {
+		return nil
+	}
+	var wrappers []string
+	for _, decl := range astFile.Decls {
+		funcDecl, ok := decl.(*ast.FuncDecl)
+		if !ok || funcDecl.Recv == nil || funcDecl.Name.Name != iface.Name || len(funcDecl.Recv.List) != 1 {
+			continue
+		}
+		if star, ok := funcDecl.Recv.List[0].Type.(*ast.StarExpr); ok {
+			if ident, ok := star.X.(*ast.Ident); ok {
+				wrappers = append(wrappers, ident.Name)
+			}
+		}
+	}
+	return wrappers
+}
+
+// moveOneofTargets moves annotations of m (by type, then target) that
+// target a case of a oneof, as Oneof.Case or Message.Oneof.Case, to the
+// field of the case's wrapper struct, e.g. Contact.email in User to Email in
+// User_Email. The message is the annotation's own unless named, by its Go
+// name. The oneof and case are matched like any target. Targets that name a
+// field of the message themselves, such as an embedded io.Reader, are left
+// alone, as are those no case matches, to be reported unmatched.
+func moveOneofTargets[V any](astFile *ast.File, m map[string]map[string][]V, goNames bool) {
+	structs := make(map[string]*ast.StructType)
+	for _, decl := range astFile.Decls {
+		if genDecl, ok := decl.(*ast.GenDecl); ok && genDecl.Tok == token.TYPE {
+			for _, spec := range genDecl.Specs {
+				if typeSpec, ok := spec.(*ast.TypeSpec); ok {
+					if structType, ok := typeSpec.Type.(*ast.StructType); ok {
+						structs[typeSpec.Name.Name] = structType
+					}
+				}
+			}
+		}
+	}
+
+	// Go in a fixed order, as targets may be added to other types' maps
+	typeNames := make([]string, 0, len(m))
+	for typeName := range m {
+		typeNames = append(typeNames, typeName)
+	}
+	sort.Strings(typeNames)
+	for _, typeName := range typeNames {
+		structType := structs[typeName]
+		if structType == nil {
+			continue
+		}
+		targets := make([]string, 0, len(m[typeName]))
+		for target := range m[typeName] {
+			targets = append(targets, target)
+		}
+		sort.Strings(targets)
+		for _, target := range targets {
+			if targetRank(target) < 2 || !strings.Contains(target, ".") || len(matchFields(structType, target, goNames)) > 0 {
+				continue
+			}
+			// The message may be named, even from another type's annotations
+			parts := strings.Split(target, ".")
+			message := structType
+			if len(parts) == 3 && structs[parts[0]] != nil {
+				message, parts = structs[parts[0]], parts[1:]
+			}
+			if len(parts) != 2 {
+				continue
+			}
+
+			var oneofs []*ast.Field
+			for _, field := range matchFields(message, parts[0], goNames) {
+				if isOneofField(field) {
+					oneofs = append(oneofs, field)
+				}
+			}
+			if len(oneofs) != 1 {
+				continue
+			}
+			for _, wrapper := range oneofWrappers(astFile, oneofs[0]) {
+				if structs[wrapper] == nil {
+					continue
+				}
+				matches := matchFields(structs[wrapper], parts[1], goNames)
+				if len(matches) != 1 {
+					continue
+				}
+				if m[wrapper] == nil {
+					m[wrapper] = make(map[string][]V)
+				}
+				caseName := fieldLabel(matches[0])
+				m[wrapper][caseName] = append(m[wrapper][caseName], m[typeName][target]...)
+				delete(m[typeName], target)
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// renamePatternFlag parses --rename-pattern regexp=replacement
+type renamePatternFlag struct {
+	p *Processor
+}
+
+func (f renamePatternFlag) String() string {
+	return ""
+}
+
+func (f renamePatternFlag) Set(value string) error {
+	pattern, replacement, ok := strings.Cut(value, "=")
+	if !ok || pattern == "" {
+		return fmt.Errorf("expected regexp=replacement, got %q", value)
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return fmt.Errorf("invalid rename pattern: %v", err)
+	}
+	f.p.RenamePattern = re
+	f.p.RenameReplacement = replacement
+	return nil
+}
+
+// outputPath returns where the result for an input goes with --out: its
+// path relative to the working directory under OutDir, or just its base
+// name for inputs outside of the working directory. RenamePattern, if set,
+// is applied to the base name (user.pb.go -> user.gen.go).
+func (p *Processor) outputPath(fpath string) (string, error) {
+	rel := filepath.FromSlash(patchPath(fpath))
+	if !filepath.IsLocal(rel) {
+		rel = filepath.Base(fpath)
+	}
+	dir, base := filepath.Split(rel)
+	if p.RenamePattern != nil {
+		base = p.RenamePattern.ReplaceAllString(base, p.RenameReplacement)
+		if base == "" || strings.ContainsAny(base, `/\`) {
+			return "", fmt.Errorf("--rename-pattern turns %s into %q, which is not a file name", filepath.Base(fpath), base)
+		}
+	}
+	return filepath.Join(p.OutDir, dir, base), nil
+}
+
+// writeOutput writes the result for an input to its output path, creating
+// directories as needed. Two inputs can't go to the same path.
+func (p *Processor) writeOutput(fpath string, content []byte) error {
+	outPath, err := p.outputPath(fpath)
+	if err != nil {
+		return err
+	}
+
+	p.outputsMu.Lock()
+	other, taken := p.outputs[outPath]
+	if !taken {
+		p.outputs[outPath] = fpath
+	}
+	p.outputsMu.Unlock()
+	if taken {
+		return fmt.Errorf("%s is also written by %s", outPath, other)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(outPath), 0755); err != nil {
+		return fmt.Errorf("failed to create output directory: %v", err)
+	}
+	if err := os.WriteFile(outPath, content, 0644); err != nil {
+		return fmt.Errorf("failed to write output: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestOutputPath(t *testing.T) {
+	tests := []struct {
+		pattern string
+		input   string
+		want    string
+		wantErr bool
+	}{
+		{"", "api/v1/user.pb.go", "gen/api/v1/user.pb.go", false},
+		{"", "./user.pb.go", "gen/user.pb.go", false},
+		// Inputs outside of the working directory keep only their name
+		{"", "../shared/user.pb.go", "gen/user.pb.go", false},
+		{`\.pb\.go$=.gen.go`, "api/user.pb.go", "gen/api/user.gen.go", false},
+		{`^(\w+)\.pb\.go$=${1}_model.go`, "user.pb.go", "gen/user_model.go", false},
+		{`.*=`, "user.pb.go", "", true},
+		{`user=a/b`, "user.pb.go", "", true},
+	}
+	for _, tt := range tests {
+		p := &Processor{OutDir: "gen"}
+		if tt.pattern != "" {
+			if err := (renamePatternFlag{p}).Set(tt.pattern); err != nil {
+				t.Fatal(err)
+			}
+		}
+		got, err := p.outputPath(tt.input)
+		if (err != nil) != tt.wantErr || got != filepath.FromSlash(tt.want) {
+			t.Errorf("outputPath(%q) with %q = %q, %v, want %q", tt.input, tt.pattern, got, err, tt.want)
+		}
+	}
+
+	for _, value := range []string{"no-equals", "=x", "(=x"} {
+		if err := (renamePatternFlag{&Processor{}}).Set(value); err == nil {
+			t.Errorf("--rename-pattern %q accepted", value)
+		}
+	}
+}
+
+func TestOutDir(t *testing.T) {
+	dir := t.TempDir()
+	src := "package pb\n\ntype User struct {\n\t// @gofield: Age int\n}\n"
+	inputs := []string{filepath.Join(dir, "a", "user.pb.go"), filepath.Join(dir, "b", "user.pb.go")}
+	for _, path := range inputs {
+		writeTree(t, filepath.Dir(path), map[string]string{"user.pb.go": src})
+	}
+
+	p := newTestProcessor()
+	p.OutDir = filepath.Join(dir, "out")
+	p.outputs = make(map[string]string)
+	if err := (renamePatternFlag{p}).Set(`\.pb\.go$=.gen.go`); err != nil {
+		t.Fatal(err)
+	}
+	captureStdout(t, func() {
+		for _, path := range inputs {
+			p.run(path)
+		}
+	})
+
+	// Both inputs are outside of the working directory, so they collide
+	if len(p.failures) != 1 || !strings.Contains(p.failures[0].Message, "is also written by "+inputs[0]) {
+		t.Errorf("failures = %+v", p.failures)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "out", "user.gen.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), "\tAge int\n") {
+		t.Errorf("output not processed:\n%s", data)
+	}
+	// The inputs are left alone
+	for _, path := range inputs {
+		if data, err := os.ReadFile(path); err != nil || string(data) != src {
+			t.Errorf("%s modified: %v\n%s", path, err, data)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import "strings"
+
+// lineHunk replaces the lines [Start, End) of a base text with Lines
+type lineHunk struct {
+	Start, End int
+	Lines      []string
+}
+
+// lineHunks turns the edit script from base to another text into hunks over
+// base
+func lineHunks(ops []diffOp) []lineHunk {
+	var hunks []lineHunk
+	pos := 0
+	var current *lineHunk
+	for _, op := range ops {
+		if op.Kind == ' ' {
+			current = nil
+			pos++
+			continue
+		}
+		if current == nil {
+			hunks = append(hunks, lineHunk{Start: pos, End: pos})
+			current = &hunks[len(hunks)-1]
+		}
+		if op.Kind == '-' {
+			pos++
+			current.End = pos
+		} else {
+			current.Lines = append(current.Lines, op.Line)
+		}
+	}
+	return hunks
+}
+
+// applyHunks applies the hunks lying within base[lo:hi] to that range
+func applyHunks(base []string, hunks []lineHunk, lo, hi int) []string {
+	var out []string
+	pos := lo
+	for _, h := range hunks {
+		if h.Start < lo || h.End > hi {
+			continue
+		}
+		out = append(out, base[pos:h.Start]...)
+		out = append(out, h.Lines...)
+		pos = h.End
+	}
+	return append(out, base[pos:hi]...)
+}
+
+// preserveFormatting limits the output's changes to the lines injection
+// touched. The printer reformats the whole file, so for source that isn't
+// gofmt'd every reformatted line would show up as changed. Both the
+// original source and the injected output are compared with the original
+// as the printer formats it: the original's lines are kept wherever
+// injection didn't change the formatted text, and the injected lines are
+// used where it did, like a three-way merge that resolves overlaps in favor
+// of the injections.
+func preserveFormatting(original, formatted, injected []byte) []byte {
+	base := splitLines(string(formatted))
+	originalHunks := lineHunks(diffLines(base, splitLines(string(original))))
+	injectedHunks := lineHunks(diffLines(base, splitLines(string(injected))))
+
+	var out []string
+	pos, i, j := 0, 0, 0
+	for i < len(originalHunks) || j < len(injectedHunks) {
+		// Start a group with the earliest hunk and grow it with every hunk of
+		// either side that overlaps or touches it
+		lo := len(base) + 1
+		if i < len(originalHunks) {
+			lo = originalHunks[i].Start
+		}
+		if j < len(injectedHunks) {
+			lo = min(lo, injectedHunks[j].Start)
+		}
+		hi := lo
+		firstOriginal, firstInjected := i, j
+		for {
+			if i < len(originalHunks) && originalHunks[i].Start <= hi {
+				hi = max(hi, originalHunks[i].End)
+				i++
+			} else if j < len(injectedHunks) && injectedHunks[j].Start <= hi {
+				hi = max(hi, injectedHunks[j].End)
+				j++
+			} else {
+				break
+			}
+		}
+
+		out = append(out, base[pos:lo]...)
+		if j > firstInjected {
+			out = append(out, applyHunks(base, injectedHunks[firstInjected:j], lo, hi)...)
+		} else {
+			out = append(out, applyHunks(base, originalHunks[firstOriginal:i], lo, hi)...)
+		}
+		pos = hi
+	}
+	out = append(out, base[pos:]...)
+	return []byte(strings.Join(out, ""))
+}
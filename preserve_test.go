@@ -0,0 +1,72 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPreserveFormatting(t *testing.T) {
+	tests := []struct {
+		name                          string
+		original, formatted, injected string
+		want                          string
+	}{
+		{
+			name:      "keeps unformatted lines",
+			original:  "a\n  b\nc\n",
+			formatted: "a\nb\nc\n",
+			injected:  "a\nb\nc\nd\n",
+			want:      "a\n  b\nc\nd\n",
+		},
+		{
+			name:      "injection wins on overlap",
+			original:  "a\n  b\nc\n",
+			formatted: "a\nb\nc\n",
+			injected:  "a\nb `json:\"b\"`\nc\n",
+			want:      "a\nb `json:\"b\"`\nc\n",
+		},
+		{
+			name:      "adjacent changes",
+			original:  "a  \nb\nc\n",
+			formatted: "a\nb\nc\n",
+			injected:  "a\nx\nb\nc\n",
+			want:      "a\nx\nb\nc\n",
+		},
+		{
+			name:      "nothing injected",
+			original:  "a\n\n\n  b\n",
+			formatted: "a\n\nb\n",
+			injected:  "a\n\nb\n",
+			want:      "a\n\n\n  b\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := preserveFormatting([]byte(tt.original), []byte(tt.formatted), []byte(tt.injected))
+			if string(got) != tt.want {
+				t.Errorf("got:\n%q\nwant:\n%q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPreserveFormattingFile(t *testing.T) {
+	src := "package pb\n\n" +
+		"var  x  = 1\n\n" +
+		"type User struct {\n" +
+		"\tId   string  // @gotags: json:\"id\"\n" +
+		"}\n\n" +
+		"func (x *User)   GetId() string { return x.Id }\n"
+	p := newTestProcessor()
+	p.PreserveFormatting = true
+	out := processSource(t, p, "test.pb.go", src)
+
+	for _, line := range []string{"var  x  = 1\n", "func (x *User)   GetId() string { return x.Id }\n"} {
+		if !strings.Contains(out, line) {
+			t.Errorf("line %q was reformatted:\n%s", line, out)
+		}
+	}
+	if !strings.Contains(out, "Id string `json:\"id\"`") {
+		t.Errorf("tag not injected:\n%s", out)
+	}
+}
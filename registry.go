@@ -0,0 +1,146 @@
+package main
+
+import (
+	"go/ast"
+	"go/token"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// goTypesCommentRe matches the comments protoc-gen-go puts on the entries
+// of a file's goTypes table, giving each type's full proto name:
+// (*User_Address)(nil), // 1: example.v1.User.Address
+var goTypesCommentRe = regexp.MustCompile(`^//\s*\d+:\s*([\w.]+)\s*$`)
+
+// protoTypeNames maps the full proto names of the messages and enums
+// declared in a file to their Go types, from the registration metadata
+// protoc-gen-go generates: the file_..._proto_goTypes table of current
+// versions, or the proto.RegisterType and proto.RegisterEnum calls of older
+// ones. Types declared in other files, like well-known types, are left out.
+func protoTypeNames(fset *token.FileSet, astFile *ast.File) map[string]string {
+	names := make(map[string]string)
+
+	// The table's comments are found by line, as the printer attaches them
+	// to nothing in particular
+	comments := make(map[int]string)
+	for _, group := range astFile.Comments {
+		for _, comment := range group.List {
+			if match := goTypesCommentRe.FindStringSubmatch(comment.Text); match != nil {
+				comments[fset.Position(comment.Pos()).Line] = match[1]
+			}
+		}
+	}
+
+	ast.Inspect(astFile, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.ValueSpec:
+			if len(n.Names) != 1 || !strings.HasSuffix(n.Names[0].Name, "_goTypes") || len(n.Values) != 1 {
+				return true
+			}
+			table, ok := n.Values[0].(*ast.CompositeLit)
+			if !ok {
+				return true
+			}
+			for _, elt := range table.Elts {
+				fullName, ok := comments[fset.Position(elt.End()).Line]
+				if !ok {
+					continue
+				}
+				// (*User)(nil) for messages, (Status)(0) for enums
+				if call, ok := elt.(*ast.CallExpr); ok {
+					if typeName := localTypeName(call.Fun); typeName != "" {
+						names[fullName] = typeName
+					}
+				}
+			}
+			return false
+		case *ast.CallExpr:
+			sel, ok := n.Fun.(*ast.SelectorExpr)
+			if !ok || len(n.Args) < 2 {
+				return true
+			}
+			switch sel.Sel.Name {
+			case "RegisterType":
+				// proto.RegisterType((*User)(nil), "example.v1.User")
+				call, ok := n.Args[0].(*ast.CallExpr)
+				fullName := stringLit(n.Args[1])
+				if !ok || fullName == "" {
+					return true
+				}
+				if typeName := localTypeName(call.Fun); typeName != "" {
+					names[fullName] = typeName
+				}
+			case "RegisterEnum":
+				// proto.RegisterEnum("example.v1.Status", Status_name, Status_value)
+				fullName := stringLit(n.Args[0])
+				ident, ok := n.Args[1].(*ast.Ident)
+				if fullName != "" && ok && strings.HasSuffix(ident.Name, "_name") {
+					names[fullName] = strings.TrimSuffix(ident.Name, "_name")
+				}
+			}
+		}
+		return true
+	})
+	return names
+}
+
+// localTypeName returns the type of a conversion like (*User) or (Status),
+// or "" for types of other packages
+func localTypeName(expr ast.Expr) string {
+	if paren, ok := expr.(*ast.ParenExpr); ok {
+		expr = paren.X
+	}
+	if star, ok := expr.(*ast.StarExpr); ok {
+		expr = star.X
+	}
+	if ident, ok := expr.(*ast.Ident); ok {
+		return ident.Name
+	}
+	return ""
+}
+
+// stringLit returns the value of a string literal, or "" for anything else
+func stringLit(expr ast.Expr) string {
+	lit, ok := expr.(*ast.BasicLit)
+	if !ok || lit.Kind != token.STRING {
+		return ""
+	}
+	value, err := strconv.Unquote(lit.Value)
+	if err != nil {
+		return ""
+	}
+	return value
+}
+
+// registryCandidates returns the Go types of the registered proto names a
+// @gotype name matches: the full name itself, or one ending with it after
+// a dot (User.Address for example.v1.User.Address). Several types match
+// when a short name is declared more than once, nested in different
+// messages. They are ordered most qualified first, like the names
+// resolveTypeName derives: the most deeply nested type (Outer_Inner for
+// example.v1.Outer.Inner before Inner for example.v1.Inner), then by full
+// name.
+func registryCandidates(name string, registry map[string]string, typeNames map[string]bool) []string {
+	name = strings.TrimPrefix(name, ".")
+	var matches []string
+	for fullName, typeName := range registry {
+		if (fullName == name || strings.HasSuffix(fullName, "."+name)) && typeNames[typeName] {
+			matches = append(matches, fullName)
+		}
+	}
+	slices.SortFunc(matches, func(a, b string) int {
+		if depth := strings.Count(b, ".") - strings.Count(a, "."); depth != 0 {
+			return depth
+		}
+		return strings.Compare(a, b)
+	})
+	var candidates []string
+	for _, fullName := range matches {
+		if typeName := registry[fullName]; !slices.Contains(candidates, typeName) {
+			candidates = append(candidates, typeName)
+		}
+	}
+	return candidates
+}
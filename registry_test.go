@@ -0,0 +1,93 @@
+package main
+
+import (
+	"go/parser"
+	"go/token"
+	"maps"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestProtoTypeNames(t *testing.T) {
+	src := `package pb
+
+type Outer struct{}
+type Outer_Reset_ struct{}
+type Status int32
+type Legacy struct{}
+type LegacyKind int32
+
+var file_example_proto_goTypes = []any{
+	(Status)(0),                   // 0: example.v1.Status
+	(*Outer)(nil),                 // 1: example.v1.Outer
+	(*Outer_Reset_)(nil),          // 2: example.v1.Outer.Reset
+	(*timestamppb.Timestamp)(nil), // 3: google.protobuf.Timestamp
+}
+
+func init() {
+	proto.RegisterType((*Legacy)(nil), "example.v1.Legacy")
+	proto.RegisterEnum("example.v1.Legacy.Kind", LegacyKind_name, LegacyKind_value)
+}
+`
+	fset := token.NewFileSet()
+	astFile, err := parser.ParseFile(fset, "test.pb.go", src, parser.ParseComments)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := protoTypeNames(fset, astFile)
+	want := map[string]string{
+		"example.v1.Status":      "Status",
+		"example.v1.Outer":       "Outer",
+		"example.v1.Outer.Reset": "Outer_Reset_",
+		"example.v1.Legacy":      "Legacy",
+		"example.v1.Legacy.Kind": "LegacyKind",
+	}
+	if !maps.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestRegistryCandidates(t *testing.T) {
+	registry := map[string]string{
+		"example.v1.Item":        "Item_",
+		"example.v1.Zone.Item":   "Zone_Item",
+		"example.v1.Outer.Reset": "Outer_Reset_",
+	}
+	typeNames := map[string]bool{"Item_": true, "Zone_Item": true, "Outer_Reset_": true}
+	tests := []struct {
+		name string
+		want []string
+	}{
+		{"example.v1.Outer.Reset", []string{"Outer_Reset_"}},
+		{".example.v1.Outer.Reset", []string{"Outer_Reset_"}},
+		{"Outer.Reset", []string{"Outer_Reset_"}},
+		{"Reset", []string{"Outer_Reset_"}},
+		{"Zone.Item", []string{"Zone_Item"}},
+		// The nested type is the most qualified match, though it sorts after
+		{"Item", []string{"Zone_Item", "Item_"}},
+		{"Missing", nil},
+	}
+	for _, tt := range tests {
+		if got := registryCandidates(tt.name, registry, typeNames); !slices.Equal(got, tt.want) {
+			t.Errorf("registryCandidates(%q) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	// resolveTypeName uses the most qualified candidate, or fails with
+	// --strict-types
+	p := &Processor{}
+	var got string
+	var err error
+	out := captureStdout(t, func() { got, err = p.resolveTypeName("test.pb.go", 1, "Item", typeNames, nil, registry) })
+	if err != nil || got != "Zone_Item" {
+		t.Errorf("resolveTypeName(Item) = %q, %v, want Zone_Item", got, err)
+	}
+	if !strings.Contains(out, "@gotype Item is ambiguous between Zone_Item, Item_, using Zone_Item") {
+		t.Errorf("missing ambiguity warning:\n%s", out)
+	}
+	p.StrictTypes = true
+	if _, err := p.resolveTypeName("test.pb.go", 1, "Item", typeNames, nil, registry); err == nil {
+		t.Error("ambiguous @gotype accepted with StrictTypes")
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// unusedEntry is an annotation entry that no longer applies to anything,
+// listed by --report-unused
+type unusedEntry struct {
+	File   string `json:"-"`
+	Struct string `json:"-"`
+	Line   int    `json:"line"`
+	Kind   string `json:"kind"` // Annotation name without the prefix, e.g. tags
+	Entry  string `json:"entry"`
+	Reason string `json:"reason"`
+}
+
+// unusedStruct groups the unused entries of a struct in the JSON report;
+// imports belong to the file, under an empty struct name
+type unusedStruct struct {
+	Struct  string        `json:"struct"`
+	Entries []unusedEntry `json:"entries"`
+}
+
+// unusedFile groups the unused entries of a file in the JSON report
+type unusedFile struct {
+	File    string         `json:"file"`
+	Structs []unusedStruct `json:"structs"`
+}
+
+// recordUnused adds the unused entries found in a file to the report
+func (p *Processor) recordUnused(entries []unusedEntry) {
+	p.unusedMu.Lock()
+	p.unused = append(p.unused, entries...)
+	p.unusedMu.Unlock()
+}
+
+// writeUnusedReport writes the entries collected for --report-unused,
+// grouped by file and struct: to stdout for "-", in the --log-format
+// there, otherwise to a file, as JSON when its name ends in .json
+func (p *Processor) writeUnusedReport() error {
+	sort.SliceStable(p.unused, func(i, j int) bool {
+		a, b := p.unused[i], p.unused[j]
+		if a.File != b.File {
+			return a.File < b.File
+		}
+		if a.Struct != b.Struct {
+			return a.Struct < b.Struct
+		}
+		return a.Line < b.Line
+	})
+
+	var files []unusedFile
+	for _, entry := range p.unused {
+		if len(files) == 0 || files[len(files)-1].File != entry.File {
+			files = append(files, unusedFile{File: entry.File})
+		}
+		file := &files[len(files)-1]
+		if len(file.Structs) == 0 || file.Structs[len(file.Structs)-1].Struct != entry.Struct {
+			file.Structs = append(file.Structs, unusedStruct{Struct: entry.Struct})
+		}
+		group := &file.Structs[len(file.Structs)-1]
+		group.Entries = append(group.Entries, entry)
+	}
+
+	asJSON := p.LogFormat == "json"
+	if p.ReportUnused != "-" {
+		asJSON = strings.EqualFold(filepath.Ext(p.ReportUnused), ".json")
+	}
+	var sb strings.Builder
+	if asJSON {
+		if files == nil {
+			files = []unusedFile{}
+		}
+		data, err := json.MarshalIndent(files, "", "  ")
+		if err != nil {
+			return fmt.Errorf("failed to encode unused report: %v", err)
+		}
+		sb.Write(data)
+		sb.WriteString("\n")
+	} else {
+		for _, file := range files {
+			sb.WriteString(file.File + "\n")
+			for _, group := range file.Structs {
+				name := group.Struct
+				if name == "" {
+					name = "(file)"
+				}
+				sb.WriteString("  " + name + "\n")
+				for _, entry := range group.Entries {
+					annotation := p.Prefix + entry.Kind
+					if entry.Entry != "" {
+						annotation += " " + entry.Entry
+					}
+					fmt.Fprintf(&sb, "    line %d: %s: %s\n", entry.Line, annotation, entry.Reason)
+				}
+			}
+		}
+	}
+
+	if p.ReportUnused == "-" {
+		fmt.Print(sb.String())
+		return nil
+	}
+	if err := os.WriteFile(p.ReportUnused, []byte(sb.String()), 0644); err != nil {
+		return fmt.Errorf("failed to write unused report: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestReportUnused(t *testing.T) {
+	src := `package pb
+
+// @goimport: "strings"
+// @gotype: User
+// @gotags(Gone): json:"gone"
+// @gotagopt(Gone): json +omitempty
+// @gorenametag(Gone): json yaml
+// @gotags(Name): json:"name,omitempty"
+// @gotagopt(Name): json +string
+// @gotags[gorm](Gone): gorm:"column:gone"
+// @gotype: Deleted
+// @gofield: Extra int
+// @govalidate
+// @goconstructor
+// @gotype: Status
+// @govalidate
+
+type User struct {
+	Name string ` + "`json:\"name\"`" + `
+}
+
+type Status int32
+`
+	p := newTestProcessor()
+	p.ReportUnused = filepath.Join(t.TempDir(), "unused.txt")
+	processSource(t, p, "test.pb.go", src)
+	if len(p.failures) > 0 {
+		t.Fatalf("processing failed: %s", p.failures[0].Message)
+	}
+	if err := p.writeUnusedReport(); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(p.ReportUnused)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The gated @gotags isn't checked, and the entries of User.Name and
+	// Status apply
+	_, report, _ := strings.Cut(string(data), "\n")
+	want := `  (file)
+    line 3: @goimport "strings": nothing in the file uses it
+  Deleted
+    line 12: @gofield Extra int: no such type in the file
+    line 13: @govalidate: no such type in the file
+    line 14: @goconstructor: no such type in the file
+  User
+    line 5: @gotags (Gone) json:"gone": matches no field
+    line 6: @gotagopt (Gone) json +omitempty: matches no field
+    line 7: @gorenametag (Gone) json yaml: matches no field
+`
+	if report != want {
+		t.Errorf("report:\n%s\nwant:\n%s", report, want)
+	}
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"bytes"
+	"os"
+)
+
+// directivePrefix starts annotations written as directives, e.g.
+// //go:inject-tags json:"id"
+const directivePrefix = "//go:inject-"
+
+// mayHaveAnnotations reports whether src may contain annotations, with a
+// byte scan for the annotation prefix and the directive form. Every
+// annotation contains one of them, so none are missed; comments or strings
+// merely mentioning them only cost a full parse.
+func mayHaveAnnotations(src []byte, prefix string) bool {
+	return bytes.Contains(src, []byte(prefix)) || bytes.Contains(src, []byte(directivePrefix))
+}
+
+// canSkip reports whether a file can be left alone without parsing it: it
+// has no annotations, and no option changes or checks files without them.
+// In large batches most files usually have none, and parsing is the bulk
+// of the work.
+func (p *Processor) canSkip(fpath string, src []byte) bool {
+	if p.WKTTags != nil || p.TypeTags != nil || p.YAMLCase != "" || p.NormalizeImports || p.GoImports || p.AlignTags || p.RequireAnnotations {
+		return false
+	}
+	// Files enhanced before are warned about when their annotations are gone
+	if bytes.Contains(src, []byte(enhancedMarker)) {
+		return false
+	}
+	// A companion left by an earlier run has to be removed
+	if p.ExternalMethods {
+		if _, err := os.Stat(companionPath(fpath)); err == nil {
+			return false
+		}
+	}
+	return !mayHaveAnnotations(src, p.Prefix)
+}
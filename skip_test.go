@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMayHaveAnnotations(t *testing.T) {
+	tests := []struct {
+		src    string
+		prefix string
+		want   bool
+	}{
+		{"package pb\n\ntype User struct{}\n", "@go", false},
+		{"package pb\n\n// @gotype: User\n", "@go", true},
+		{"package pb\n\ntype User struct {\n\tId int64 // @gotags: json:\"id\"\n}\n", "@go", true},
+		{"package pb\n\n//go:inject-tags json:\"id\"\n", "@go", true},
+		{"package pb\n\n// @gotype: User\n", "@inject_", false},
+		{"package pb\n\n// @inject_type: User\n", "@inject_", true},
+		// Mentions cost a parse, but are never missed
+		{"package pb\n\nconst doc = \"see @go\"\n", "@go", true},
+	}
+	for _, tt := range tests {
+		if got := mayHaveAnnotations([]byte(tt.src), tt.prefix); got != tt.want {
+			t.Errorf("mayHaveAnnotations(%q, %q) = %v, want %v", tt.src, tt.prefix, got, tt.want)
+		}
+	}
+}
+
+func TestSkipUnannotated(t *testing.T) {
+	// Not gofmt'd, so it would change if it were printed
+	src := "package pb\n\ntype User struct {\n\tId   int64\n}\n"
+	path := filepath.Join(t.TempDir(), "test.pb.go")
+	if err := os.WriteFile(path, []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+	p := newTestProcessor()
+	captureStdout(t, func() { p.run(path) })
+	if data, err := os.ReadFile(path); err != nil || string(data) != src {
+		t.Errorf("file without annotations changed: %v\n%s", err, data)
+	}
+
+	// Options changing files without annotations turn the skip off
+	p = newTestProcessor()
+	p.AlignTags = true
+	if p.canSkip("test.pb.go", []byte(src)) {
+		t.Error("file skipped with --align-tags")
+	}
+	if p := newTestProcessor(); p.canSkip("test.pb.go", []byte(enhancedMarker+"\n\n"+src)) {
+		t.Error("file enhanced before skipped")
+	}
+}
+
+// benchmarkFile returns a generated file with a few messages, annotated or
+// not
+func benchmarkFile(n int, annotated bool) string {
+	var sb strings.Builder
+	sb.WriteString("// Code generated by protoc-gen-go. DO NOT EDIT.\n\npackage pb\n\n")
+	sb.WriteString("import (\n\tprotoreflect \"google.golang.org/protobuf/reflect/protoreflect\"\n\tprotoimpl \"google.golang.org/protobuf/runtime/protoimpl\"\n)\n")
+	for m := 0; m < 5; m++ {
+		name := fmt.Sprintf("Message%d_%d", n, m)
+		if annotated {
+			fmt.Fprintf(&sb, "\n// @gotype: %s\n// @gofield: Extra string\n", name)
+		}
+		fmt.Fprintf(&sb, "\ntype %s struct {\n\tstate         protoimpl.MessageState\n\tsizeCache     protoimpl.SizeCache\n\tunknownFields protoimpl.UnknownFields\n\n", name)
+		for f := 1; f <= 12; f++ {
+			tags := ""
+			if annotated && f == 1 {
+				tags = " // @gotags: gorm:\"primaryKey\""
+			}
+			fmt.Fprintf(&sb, "\tField%d string `protobuf:\"bytes,%d,opt,name=field%d,proto3\" json:\"field%d,omitempty\"`%s\n", f, f, f, f, tags)
+		}
+		sb.WriteString("}\n")
+		fmt.Fprintf(&sb, "\nfunc (x *%s) Reset() {\n\t*x = %s{}\n}\n", name, name)
+		fmt.Fprintf(&sb, "\nfunc (x *%s) ProtoReflect() protoreflect.Message {\n\treturn nil\n}\n", name)
+		for f := 1; f <= 12; f++ {
+			fmt.Fprintf(&sb, "\nfunc (x *%s) GetField%d() string {\n\tif x != nil {\n\t\treturn x.Field%d\n\t}\n\treturn \"\"\n}\n", name, f, f)
+		}
+	}
+	return sb.String()
+}
+
+// BenchmarkRun processes a batch of generated files where one in ten (or
+// all of them) has annotations, with different numbers of workers (-j).
+// Files without annotations are passed over without being parsed.
+func BenchmarkRun(b *testing.B) {
+	stdout := os.Stdout
+	devNull, err := os.Open(os.DevNull)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer devNull.Close()
+
+	for _, every := range []int{10, 1} {
+		dir := b.TempDir()
+		var files []string
+		for n := 0; n < 200; n++ {
+			path := filepath.Join(dir, fmt.Sprintf("file%d.pb.go", n))
+			if err := os.WriteFile(path, []byte(benchmarkFile(n, n%every == 0)), 0644); err != nil {
+				b.Fatal(err)
+			}
+			files = append(files, path)
+		}
+		for _, jobs := range []int{1, 2, 4, 8} {
+			b.Run(fmt.Sprintf("annotated=%d%%/j=%d", 100/every, jobs), func(b *testing.B) {
+				os.Stdout = devNull
+				defer func() { os.Stdout = stdout }()
+				for i := 0; i < b.N; i++ {
+					// Annotated files were enhanced by the first run; later
+					// ones parse them again and leave them as they are
+					p := newTestProcessor()
+					p.runAll(files, jobs)
+					if len(p.failures) > 0 {
+						b.Fatalf("processing failed: %s", p.failures[0].Message)
+					}
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"slices"
+	"strings"
+)
+
+// tagOrderFlag parses the comma-separated tag keys of --tag-order
+type tagOrderFlag struct {
+	p *Processor
+}
+
+func (f tagOrderFlag) String() string {
+	return ""
+}
+
+func (f tagOrderFlag) Set(value string) error {
+	f.p.TagOrder = nil
+	for _, key := range strings.Split(value, ",") {
+		if key = strings.TrimSpace(key); key != "" && !slices.Contains(f.p.TagOrder, key) {
+			f.p.TagOrder = append(f.p.TagOrder, key)
+		}
+	}
+	return nil
+}
+
+// orderTags sorts the tags the tool writes by TagOrder: listed keys first,
+// in that order, then the others alphabetically. Without TagOrder the tags
+// are kept as they are, existing keys in place and new ones appended.
+func (p *Processor) orderTags(tags []tagPair) []tagPair {
+	if p.TagOrder == nil {
+		return tags
+	}
+	rank := func(key string) int {
+		if i := slices.Index(p.TagOrder, key); i >= 0 {
+			return i
+		}
+		return len(p.TagOrder)
+	}
+	sorted := slices.Clone(tags)
+	slices.SortStableFunc(sorted, func(a, b tagPair) int {
+		if diff := rank(a.Key) - rank(b.Key); diff != 0 {
+			return diff
+		}
+		if rank(a.Key) == len(p.TagOrder) {
+			return strings.Compare(a.Key, b.Key)
+		}
+		return 0
+	})
+	return sorted
+}
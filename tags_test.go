@@ -0,0 +1,563 @@
+package main
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestParseTags(t *testing.T) {
+	tests := []struct {
+		tag       string
+		want      []tagPair
+		remainder string
+	}{
+		{
+			"`protobuf:\"bytes,1,opt,name=user_id,json=userId,proto3\" json:\"user_id,omitempty\"`",
+			[]tagPair{{"protobuf", "bytes,1,opt,name=user_id,json=userId,proto3"}, {"json", "user_id,omitempty"}},
+			"",
+		},
+		{
+			"`protobuf:\"bytes,2,rep,name=labels,proto3\" protobuf_key:\"bytes,1,opt,name=key,proto3\" protobuf_val:\"bytes,2,opt,name=value,proto3\"`",
+			[]tagPair{{"protobuf", "bytes,2,rep,name=labels,proto3"}, {"protobuf_key", "bytes,1,opt,name=key,proto3"}, {"protobuf_val", "bytes,2,opt,name=value,proto3"}},
+			"",
+		},
+		// Keys keep their case and may contain dashes and dots
+		{"`JSON:\"a\" x-key:\"b\" a.b:\"c\"`", []tagPair{{"JSON", "a"}, {"x-key", "b"}, {"a.b", "c"}}, ""},
+		{"`json:\"a\\\"b\"`", []tagPair{{"json", `a\"b`}}, ""},
+		{"`json:\"a\" broken gorm:\"b\"`", []tagPair{{"json", "a"}, {"gorm", "b"}}, "broken"},
+		{"`json:\"a\" gorm:\"unterminated`", []tagPair{{"json", "a"}}, `gorm:"unterminated`},
+		{"``", nil, ""},
+	}
+	for _, tt := range tests {
+		got, remainder := parseTags(tt.tag)
+		if !reflect.DeepEqual(got, tt.want) || remainder != tt.remainder {
+			t.Errorf("parseTags(%s) = %v, %q, want %v, %q", tt.tag, got, remainder, tt.want, tt.remainder)
+		}
+	}
+}
+
+func TestFormatTagsRoundTrip(t *testing.T) {
+	tags := []string{
+		`gqlgen:"userId"`,
+		`gqlgen:"name,omitempty" json:"name"`,
+		`graphql:"user(id: $id, first: 10)"`,
+		`description:"a \"quoted\" value, with: colons"`,
+		`json:"-" yaml:"a b"`,
+	}
+	for _, tag := range tags {
+		pairs, remainder := parseTags("`" + tag + "`")
+		if remainder != "" {
+			t.Errorf("parseTags(%s) left %q", tag, remainder)
+		}
+		if got := formatTags(pairs); got != tag {
+			t.Errorf("formatTags(parseTags(%s)) = %s", tag, got)
+		}
+	}
+}
+
+func TestInterpretedStringTags(t *testing.T) {
+	src := "package pb\n\ntype User struct {\n" +
+		"\tId string \"json:\\\"id\\\"\" // @gotags: gqlgen:\"userId\"\n" +
+		"\tName string \"json:\\\"name\\\"\" // @gotags: doc:\"`name`\"\n" +
+		"}\n"
+	out := process(t, src)
+	if !strings.Contains(out, "`json:\"id\" gqlgen:\"userId\"`") {
+		t.Errorf("interpreted tag not merged:\n%s", out)
+	}
+	if !strings.Contains(out, `"json:\"name\" doc:\"`+"`name`"+`\""`) {
+		t.Errorf("tag with a backquote not kept interpreted:\n%s", out)
+	}
+}
+
+func TestMergeTagsKeepsExisting(t *testing.T) {
+	existing := []tagPair{{"protobuf", "bytes,1,opt,name=id,proto3"}, {"json", "id,omitempty"}, {"protobuf_oneof", "kind"}}
+	tests := []struct {
+		name     string
+		newTags  []tagPair
+		override bool
+		want     []tagPair
+	}{
+		{"new key", []tagPair{{"gorm", "primaryKey"}}, true, append(existing[:3:3], tagPair{"gorm", "primaryKey"})},
+		{"replaced key", []tagPair{{"json", "id"}}, true, []tagPair{existing[0], {"json", "id"}, existing[2]}},
+		{"kept key", []tagPair{{"json", "id"}}, false, existing},
+		{"differently cased key", []tagPair{{"JSON", "id"}}, true, append(existing[:3:3], tagPair{"JSON", "id"})},
+	}
+	for _, tt := range tests {
+		got := mergeTags(existing, tt.newTags, tt.override, nil)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: mergeTags = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestInjectTagsKeepsProtobufTag(t *testing.T) {
+	src := "package pb\n\ntype User struct {\n" +
+		"\tUserId string `protobuf:\"bytes,1,opt,name=user_id,json=userId,proto3\" json:\"user_id,omitempty\"` // @gotags: json:\"uid\"\n" +
+		"\tLabels map[string]string `protobuf:\"bytes,2,rep,name=labels,proto3\" json:\"labels,omitempty\" protobuf_key:\"bytes,1,opt,name=key,proto3\" protobuf_val:\"bytes,2,opt,name=value,proto3\"` // @gotags: json:\"tags\" yaml:\"tags\"\n" +
+		"}\n"
+	out := process(t, src)
+	for _, want := range []string{
+		"`protobuf:\"bytes,1,opt,name=user_id,json=userId,proto3\" json:\"uid\"`",
+		"`protobuf:\"bytes,2,rep,name=labels,proto3\" json:\"tags\" protobuf_key:\"bytes,1,opt,name=key,proto3\" protobuf_val:\"bytes,2,opt,name=value,proto3\" yaml:\"tags\"`",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("tag %s not found in:\n%s", want, out)
+		}
+	}
+}
+
+func TestKeepMalformedTags(t *testing.T) {
+	src := "package pb\n\ntype User struct {\n" +
+		"\tId string `protobuf:\"bytes,1,opt,name=id,proto3\" broken` // @gotags: yaml:\"id\" stray\n" +
+		"}\n"
+	want := "`protobuf:\"bytes,1,opt,name=id,proto3\" yaml:\"id\" broken`"
+	if out := process(t, src); !strings.Contains(out, want) {
+		t.Errorf("tag %s not found in:\n%s", want, out)
+	}
+}
+
+func TestTagEmbeddedFields(t *testing.T) {
+	src := `package pb
+
+import "gorm.io/gorm"
+
+// @gotype: User
+// @gotags(gorm.Model): gorm:"embedded;embeddedPrefix:base_"
+// @gotags(Audit): json:"audit"
+// @gotags(Meta): json:"meta"
+
+type User struct {
+	gorm.Model
+	*Audit
+	Meta
+	Name string
+}
+
+type Audit struct{}
+
+type Meta struct{}
+`
+	want := "type User struct {\n" +
+		"\tgorm.Model `gorm:\"embedded;embeddedPrefix:base_\"`\n" +
+		"\t*Audit     `json:\"audit\"`\n" +
+		"\tMeta       `json:\"meta\"`\n" +
+		"\tName       string\n" +
+		"}"
+	if got := structDecl(process(t, src), "User"); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestMapFields(t *testing.T) {
+	// The line name comes from the protobuf tag, not protobuf_key or
+	// protobuf_val, wherever they are
+	src := "package pb\n\n// @gotype: Config\n// @gotags(Labels): yaml:\"labels\"\n// @gofield: Cache map[string]*Label // @gotags(Cache): json:\"-\"\n\ntype Config struct {\n" +
+		"\tLabels map[string]*Label `protobuf:\"bytes,1,rep,name=labels,proto3\" json:\"labels,omitempty\" protobuf_key:\"bytes,1,opt,name=key,proto3\" protobuf_val:\"bytes,2,opt,name=value,proto3\"`\n" +
+		"\tEnv map[string]string `protobuf_key:\"bytes,1,opt,name=key,proto3\" protobuf:\"bytes,2,rep,name=env,proto3\" json:\"env,omitempty\" protobuf_val:\"bytes,2,opt,name=value,proto3\"` // @gotags: json:\"environment\"\n" +
+		"}\n\ntype Label struct{}\n"
+	want := "type Config struct {\n" +
+		"\tLabels map[string]*Label `protobuf:\"bytes,1,rep,name=labels,proto3\" json:\"labels,omitempty\" protobuf_key:\"bytes,1,opt,name=key,proto3\" protobuf_val:\"bytes,2,opt,name=value,proto3\" yaml:\"labels\"`\n" +
+		"\tEnv    map[string]string `protobuf_key:\"bytes,1,opt,name=key,proto3\" protobuf:\"bytes,2,rep,name=env,proto3\" json:\"environment\" protobuf_val:\"bytes,2,opt,name=value,proto3\"` // @gotags: json:\"environment\"\n" +
+		"\tCache  map[string]*Label `json:\"-\"`\n" +
+		"}"
+	if got := structDecl(process(t, src), "Config"); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestRenameTag(t *testing.T) {
+	src := "package pb\n\ntype User struct {\n" +
+		"\t// @gorenametag(Name): bson db\n" +
+		"\tId string `bson:\"_id\" json:\"id\"` // @gorenametag: bson db\n" +
+		"\tName string `db:\"old\" json:\"name\" bson:\"name\"`\n" +
+		"\tEmail string `db:\"email\"` // @gorenametag: bson db\n" +
+		"\tPhone string `json:\"phone\"` // @gorenametag: bson db\n" +
+		"}\n"
+	path := filepath.Join(t.TempDir(), "test.pb.go")
+	if err := os.WriteFile(path, []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+	p := newTestProcessor()
+	var err error
+	stdout := captureStdout(t, func() { _, err = p.processFile(path) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := os.ReadFile(path + p.Suffix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"`db:\"_id\" json:\"id\"`",
+		// The renamed tag replaces the existing db tag
+		"`json:\"name\" db:\"name\"`",
+		// Already renamed
+		"`db:\"email\"`",
+		"`json:\"phone\"`",
+	} {
+		if !strings.Contains(string(out), want) {
+			t.Errorf("%s not found in:\n%s", want, out)
+		}
+	}
+	if !strings.Contains(stdout, "User.Phone: no bson tag to rename to db") || p.warnings != 1 {
+		t.Errorf("got %d warnings:\n%s", p.warnings, stdout)
+	}
+}
+
+func TestTargetCollisions(t *testing.T) {
+	fields := "type User struct {\n" +
+		"\tID string `protobuf:\"bytes,1,opt,name=ID,proto3\" json:\"ID,omitempty\"`\n" +
+		"\tId string `protobuf:\"bytes,2,opt,name=id,proto3\" json:\"id,omitempty\"`\n" +
+		"\tUserId string `protobuf:\"bytes,3,opt,name=userId,proto3\" json:\"userId,omitempty\"`\n" +
+		"\tUserID string `protobuf:\"bytes,4,opt,name=user_id,proto3\" json:\"user_id,omitempty\"`\n" +
+		"}\n"
+	tests := []struct {
+		annotation string
+		err        string
+	}{
+		{`@gotags(i_d): json:"x"`, `User: @gotags "i_d" matches both ID and Id`},
+		{`@gotags(userid): json:"x"`, `User: @gotags "userid" matches both UserId and UserID`},
+		{`@gorenametag(USER_ID): json yaml`, `User: @gorenametag "USER_ID" matches both UserId and UserID`},
+		{`@gotagopt(iD): json +string`, `User: @gotagopt "iD" matches both ID and Id`},
+		// Exact Go or proto names pick one field
+		{`@gotags(Id): json:"x"`, ""},
+		{`@gotags(user_id): json:"x"`, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.annotation, func(t *testing.T) {
+			src := "package pb\n\n// @gotype: User\n// " + tt.annotation + "\n\n" + fields
+			if tt.err == "" {
+				out := process(t, src)
+				if strings.Count(out, "json:\"x\"`") != 1 {
+					t.Errorf("want exactly one field tagged:\n%s", out)
+				}
+				return
+			}
+
+			path := filepath.Join(t.TempDir(), "test.pb.go")
+			if err := os.WriteFile(path, []byte(src), 0644); err != nil {
+				t.Fatal(err)
+			}
+			p := newTestProcessor()
+			var err error
+			captureStdout(t, func() { _, err = p.processFile(path) })
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Fatalf("err = %v, want one containing %q", err, tt.err)
+			}
+			if _, err := os.Stat(path + p.Suffix); !os.IsNotExist(err) {
+				t.Errorf("output written for a failed file")
+			}
+		})
+	}
+}
+
+func TestPatternTargets(t *testing.T) {
+	src := "package pb\n\n// @gotype: User\n" +
+		"// @gotags(/_at$/): gorm:\"type:timestamptz\"\n" +
+		"// @gotags(updated_at): gorm:\"autoUpdateTime\"\n" +
+		"// @gotagopt(/^Name/): json +omitempty\n" +
+		"// @gotags(/^nothing/): json:\"x\"\n\n" +
+		"type User struct {\n" +
+		"\tCreatedAt int64 `protobuf:\"varint,1,opt,name=created_at,proto3\"`\n" +
+		"\tUpdatedAt int64 `protobuf:\"varint,2,opt,name=updated_at,proto3\"`\n" +
+		"\tName string `json:\"name\"`\n" +
+		"\tNameSuffix string `json:\"suffix\"`\n" +
+		"}\n"
+	path := filepath.Join(t.TempDir(), "test.pb.go")
+	if err := os.WriteFile(path, []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+	p := newTestProcessor()
+	out := captureStdout(t, func() {
+		if _, err := p.processFile(path); err != nil {
+			t.Error(err)
+		}
+	})
+	if p.warnings != 1 || !strings.Contains(out, ":7: warning: @gotags(/^nothing/) matches no field of User") {
+		t.Errorf("got %d warnings:\n%s", p.warnings, out)
+	}
+
+	data, err := os.ReadFile(path + p.Suffix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Tags set by name win over those of patterns
+	for _, want := range []string{
+		"name=created_at,proto3\" gorm:\"type:timestamptz\"`",
+		"name=updated_at,proto3\" gorm:\"autoUpdateTime\"`",
+		"`json:\"name,omitempty\"`",
+		"`json:\"suffix,omitempty\"`",
+	} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("missing %q:\n%s", want, data)
+		}
+	}
+
+	src = "package pb\n\n// @gotype: User\n// @gotags(/[/): json:\"x\"\n\ntype User struct {\n\tName string\n}\n"
+	if err := os.WriteFile(path, []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var perr error
+	captureStdout(t, func() { _, perr = p.processFile(path) })
+	if perr == nil || !strings.Contains(perr.Error(), "line 4: invalid @gotags target /[/") {
+		t.Errorf("err = %v", perr)
+	}
+}
+
+func TestGormDefaultRoundTrip(t *testing.T) {
+	tag := "`protobuf:\"bytes,1,opt,name=meta,proto3\" gorm:\"type:jsonb;default:'{}'\" json:\"meta\"`"
+	got, remainder := parseTags(tag)
+	want := []tagPair{{"protobuf", "bytes,1,opt,name=meta,proto3"}, {"gorm", "type:jsonb;default:'{}'"}, {"json", "meta"}}
+	if !reflect.DeepEqual(got, want) || remainder != "" {
+		t.Errorf("parseTags = %v, %q, want %v", got, remainder, want)
+	}
+
+	src := "package pb\n\ntype Doc struct {\n" +
+		"\tMeta string `protobuf:\"bytes,1,opt,name=meta,proto3\" gorm:\"type:jsonb;default:'{}'\" json:\"meta\"` // @gotags: yaml:\"meta\"\n" +
+		"\tNote string `protobuf:\"bytes,2,opt,name=note,proto3\" json:\"note\"` // @gotags: gorm:\"default:'{\\\"a\\\": \\\"b c\\\"}';not null\" json:\"note,omitempty\"\n" +
+		"\tTags string `protobuf:\"bytes,3,opt,name=tags,proto3\" gorm:\"default:'[]'\" json:\"tags\"` // @gotags: gorm:\"type:text\"\n" +
+		"}\n"
+	run := func(src string) string {
+		p := newTestProcessor()
+		p.MergeStrategies = map[string]string{"gorm": "gorm"}
+		out := processSource(t, p, "test.pb.go", src)
+		if len(p.failures) > 0 {
+			t.Fatalf("processing failed: %s", p.failures[0].Message)
+		}
+		return out
+	}
+	out := run(src)
+	for _, want := range []string{
+		"`protobuf:\"bytes,1,opt,name=meta,proto3\" gorm:\"type:jsonb;default:'{}'\" json:\"meta\" yaml:\"meta\"`",
+		"`protobuf:\"bytes,2,opt,name=note,proto3\" json:\"note,omitempty\" gorm:\"default:'{\\\"a\\\": \\\"b c\\\"}';not null\"`",
+		"`protobuf:\"bytes,3,opt,name=tags,proto3\" gorm:\"default:'[]';type:text\" json:\"tags\"`",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("tag %s not found in:\n%s", want, out)
+		}
+	}
+	if again := run(out); again != out {
+		t.Errorf("rerun changed the file:\n%s", again)
+	}
+}
+
+func TestFieldNumberTargets(t *testing.T) {
+	src := "package pb\n\n// @gotype: User\n// @gotags(#3): gorm:\"column:email\"\n// @gotags(*): yaml:\"-\"\n// @gotagopt(#1): json +string\n// @gotags(#9): json:\"nine\"\n\ntype User struct {\n" +
+		"\tId int64 `protobuf:\"varint,1,opt,name=id,proto3\" json:\"id,omitempty\"`\n" +
+		"\tName string `protobuf:\"bytes,2,opt,name=name,proto3\" json:\"name,omitempty\"`\n" +
+		"\tMail string `protobuf:\"bytes,3,opt,name=mail,proto3\" json:\"mail,omitempty\"` // @gotags: yaml:\"mail\"\n" +
+		"\tNote string `json:\"note\"`\n" +
+		"}\n"
+	p := newTestProcessor()
+	out := processSource(t, p, "test.pb.go", src)
+	for _, want := range []string{
+		"`protobuf:\"varint,1,opt,name=id,proto3\" json:\"id,omitempty,string\" yaml:\"-\"`",
+		"`protobuf:\"bytes,2,opt,name=name,proto3\" json:\"name,omitempty\" yaml:\"-\"`",
+		"`protobuf:\"bytes,3,opt,name=mail,proto3\" json:\"mail,omitempty\" yaml:\"mail\" gorm:\"column:email\"`",
+		"`json:\"note\" yaml:\"-\"`",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("tag %s not found in:\n%s", want, out)
+		}
+	}
+	// #9 matches no field
+	if p.warnings != 1 {
+		t.Errorf("got %d warnings, want 1", p.warnings)
+	}
+}
+
+func TestGoNames(t *testing.T) {
+	// The protobuf-looking tags name the fields email and full_name, which
+	// --go-names ignores
+	src := "package models\n\n// @gotype: User\n// @gotags(email): db:\"email\"\n\ntype User struct {\n" +
+		"\tMail string `protobuf:\"bytes,1,opt,name=email\"`\n" +
+		"\tName string `protobuf:\"bytes,2,opt,name=full_name\"` // @gotags: json:\"name\"\n" +
+		"}\n"
+	tests := []struct {
+		goNames bool
+		mail    string
+	}{
+		{false, "`protobuf:\"bytes,1,opt,name=email\" db:\"email\"`"},
+		{true, "`protobuf:\"bytes,1,opt,name=email\"`\n"},
+	}
+	for _, tt := range tests {
+		p := newTestProcessor()
+		p.GoNames = tt.goNames
+		out := processSource(t, p, "test.go", src)
+		// The line annotation finds its field either way
+		for _, want := range []string{tt.mail, "`protobuf:\"bytes,2,opt,name=full_name\" json:\"name\"`"} {
+			if !strings.Contains(out, want) {
+				t.Errorf("with GoNames %v, tag %s not found in:\n%s", tt.goNames, want, out)
+			}
+		}
+	}
+}
+
+func TestAllowedTags(t *testing.T) {
+	tests := []struct {
+		name        string
+		annotations string
+		wantErr     string // Part of the error expected, "" for none
+	}{
+		{"allowed", "// @gotags(Name): json:\"name\" gorm:\"column:name\"", ""},
+		{"gotags", "// @gotags(Name): json:\"name\" xml:\"name\"", `@gotags for User.Name sets tag key "xml"`},
+		{"replacefield", "// @goreplacefield: Name string `yaml:\"name\"`", `@goreplacefield Name string sets tag key "yaml"`},
+		{"renametag", "// @gorenametag(Name): json bson", `renames json to tag key "bson"`},
+		{"renametag allowed", "// @gorenametag(Name): json gorm", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src := "package pb\n\n// @gotype: User\n" + tt.annotations + "\n\ntype User struct {\n\tName string `json:\"name\"`\n}\n"
+			path := filepath.Join(t.TempDir(), "test.pb.go")
+			if err := os.WriteFile(path, []byte(src), 0644); err != nil {
+				t.Fatal(err)
+			}
+			p := newTestProcessor()
+			if err := (allowedTagsFlag{p}).Set("json, gorm"); err != nil {
+				t.Fatal(err)
+			}
+			var err error
+			captureStdout(t, func() { _, err = p.processFile(path) })
+			if tt.wantErr == "" && err != nil {
+				t.Fatal(err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Errorf("got error %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestTagOrder(t *testing.T) {
+	src := "package pb\n\n// @gotype: User\n" +
+		"// @gotags(Name): validate:\"required\" gorm:\"column:name\"\n" +
+		"// @gotags(*): xml:\",omitempty\"\n\n" +
+		"type User struct {\n" +
+		"\tName string `protobuf:\"bytes,1,opt,name=name,proto3\" json:\"name,omitempty\"`\n" +
+		"\tAge  int    `json:\"age\"`\n" +
+		"}\n"
+
+	// Without --tag-order new keys are appended
+	got := structDecl(process(t, src), "User")
+	if want := "`protobuf:\"bytes,1,opt,name=name,proto3\" json:\"name,omitempty\" xml:\",omitempty\" validate:\"required\" gorm:\"column:name\"`"; !strings.Contains(got, want) {
+		t.Errorf("got:\n%s\nwant tag %s", got, want)
+	}
+
+	p := newTestProcessor()
+	if err := (tagOrderFlag{p}).Set("json, gorm,json,"); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"json", "gorm"}; !slices.Equal(p.TagOrder, want) {
+		t.Errorf("TagOrder = %q, want %q", p.TagOrder, want)
+	}
+	got = structDecl(processSource(t, p, "test.pb.go", src), "User")
+	for _, want := range []string{
+		"`json:\"name,omitempty\" gorm:\"column:name\" protobuf:\"bytes,1,opt,name=name,proto3\" validate:\"required\" xml:\",omitempty\"`",
+		"`json:\"age\" xml:\",omitempty\"`",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("got:\n%s\nwant tag %s", got, want)
+		}
+	}
+}
+
+func TestLineFieldNameFallback(t *testing.T) {
+	tests := []struct {
+		line     string
+		goNames  bool
+		fallback []string
+		want     string
+	}{
+		{"\tUserId string `protobuf:\"bytes,1,opt,name=user_id,proto3\" json:\"uid\"`", false, nil, "user_id"},
+		{"\tUserId string `json:\"user_id,omitempty\"`", false, nil, "user_id"},
+		{"\tUserId string `json:\"user_id,omitempty\"`", false, []string{"go", "json"}, "UserId"},
+		{"\tUserId string `json:\"user_id,omitempty\"`", true, nil, "UserId"},
+		{"\tUserId string `protobuf:\"bytes,1,opt,name=user_id,proto3\"`", true, nil, "UserId"},
+		{"\tInternal string `json:\"-\"`", false, nil, "Internal"},
+		// The json tag in the annotation isn't the field's
+		{"\tNote string // @gotags: json:\"note\"", false, []string{"json"}, ""},
+	}
+	for _, tt := range tests {
+		if got := lineFieldName(tt.line, tt.goNames, tt.fallback); got != tt.want {
+			t.Errorf("lineFieldName(%q, %v, %v) = %q, want %q", tt.line, tt.goNames, tt.fallback, got, tt.want)
+		}
+	}
+}
+
+func TestNameFallbackFlag(t *testing.T) {
+	p := &Processor{}
+	if err := (nameFallbackFlag{p}).Set("go, json,go"); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"go", "json"}; !slices.Equal(p.NameFallback, want) {
+		t.Errorf("NameFallback = %q, want %q", p.NameFallback, want)
+	}
+	if err := (nameFallbackFlag{p}).Set("json,proto"); err == nil {
+		t.Error("unknown name source accepted")
+	}
+}
+
+func TestBacktickTagValues(t *testing.T) {
+	if got, want := tagLiteral(`json:"id"`), "`json:\"id\"`"; got != want {
+		t.Errorf("tagLiteral = %s, want %s", got, want)
+	}
+	if got, want := tagLiteral("doc:\"see `id`\""), `"doc:\"see `+"`id`"+`\""`; got != want {
+		t.Errorf("tagLiteral = %s, want %s", got, want)
+	}
+
+	src := "package pb\n\n// @gotype: User\n// @gotags(Name): doc:\"see `name`\"\n" +
+		"// @gofield: Note string\n// @gotags(Note): doc:\"a `note`\"\n\ntype User struct {\n" +
+		"\tId string \"json:\\\"id\\\" doc:\\\"the `id`\\\"\" // @gotags: yaml:\"id\"\n" +
+		"\tName string `json:\"name\"`\n" +
+		"\tEmail string `json:\"email\"` // @gotags: yaml:\"email\"\n" +
+		"}\n"
+	out := process(t, src)
+	file, err := parser.ParseFile(token.NewFileSet(), "", out, 0)
+	if err != nil {
+		t.Fatalf("output doesn't parse: %v\n%s", err, out)
+	}
+	want := map[string]map[string]string{
+		"Id":    {"json": "id", "doc": "the `id`", "yaml": "id"},
+		"Name":  {"json": "name", "doc": "see `name`"},
+		"Email": {"json": "email", "yaml": "email"},
+		"Note":  {"doc": "a `note`"},
+	}
+	structType := file.Decls[0].(*ast.GenDecl).Specs[0].(*ast.TypeSpec).Type.(*ast.StructType)
+	for _, field := range structType.Fields.List {
+		tag, err := strconv.Unquote(field.Tag.Value)
+		if err != nil {
+			t.Fatalf("tag %s of %s: %v", field.Tag.Value, field.Names[0].Name, err)
+		}
+		for key, value := range want[field.Names[0].Name] {
+			if got := reflect.StructTag(tag).Get(key); got != value {
+				t.Errorf("%s: %s tag = %q, want %q", field.Names[0].Name, key, got, value)
+			}
+		}
+	}
+	// Tags without backquotes stay raw strings
+	if !strings.Contains(out, "`json:\"email\" yaml:\"email\"`") {
+		t.Errorf("raw string tag not kept:\n%s", out)
+	}
+	if again := process(t, out); again != out {
+		t.Errorf("rerun changed the file:\n%s", again)
+	}
+
+	// Aligned tags keep the quoting
+	p := newTestProcessor()
+	p.AlignTags = true
+	aligned := processSource(t, p, "test.pb.go", src)
+	if _, err := parser.ParseFile(token.NewFileSet(), "", aligned, 0); err != nil {
+		t.Fatalf("aligned output doesn't parse: %v\n%s", err, aligned)
+	}
+	if !strings.Contains(aligned, "\"json:\\\"id\\\" doc:\\\"the `id`\\\" yaml:\\\"id\\\"\"") {
+		t.Errorf("quoted tag not kept with --align-tags:\n%s", aligned)
+	}
+}
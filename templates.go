@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// templateNameRe matches the line starting a template in a --templates file
+var templateNameRe = regexp.MustCompile(`^([\w-]+):$`)
+
+// readTemplates loads the named injection templates of a --templates file.
+// A template starts with its name and a colon on a line of its own, followed
+// by indented annotation lines, which @gotemplate expands to:
+//
+//	logger:
+//	    @goimport: "go.uber.org/zap"
+//	    @gofield: log *zap.Logger
+//	    @gomethod: Logger() *zap.Logger { return x.log }
+//
+// Blank lines and # comments are skipped.
+func (p *Processor) readTemplates(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open templates: %v", err)
+	}
+	defer file.Close()
+
+	if p.Templates == nil {
+		p.Templates = make(map[string][]string)
+	}
+	name := ""
+	lineNum := 0
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		lineNum++
+		text := scanner.Text()
+		line := strings.TrimSpace(text)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if line == text {
+			match := templateNameRe.FindStringSubmatch(line)
+			if match == nil {
+				return fmt.Errorf("%s:%d: expected a template name followed by a colon, got %q", path, lineNum, line)
+			}
+			name = match[1]
+			if _, ok := p.Templates[name]; ok {
+				return fmt.Errorf("%s:%d: template %s is defined twice", path, lineNum, name)
+			}
+			p.Templates[name] = nil
+			continue
+		}
+		if name == "" {
+			return fmt.Errorf("%s:%d: annotation outside of a template", path, lineNum)
+		}
+		p.Templates[name] = append(p.Templates[name], line)
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read templates: %v", err)
+	}
+	return nil
+}
+
+// expandTemplates replaces the @gotemplate annotations of a line by the
+// annotations of their templates. A template's condition, if any, applies
+// to each of its annotations.
+func (p *Processor) expandTemplates(annotations []Annotation) ([]Annotation, error) {
+	var expanded []Annotation
+	for _, ann := range annotations {
+		if ann.Type != "gotemplate" {
+			expanded = append(expanded, ann)
+			continue
+		}
+		lines, ok := p.Templates[ann.Content]
+		if !ok {
+			if p.Templates == nil {
+				return nil, fmt.Errorf("unknown @gotemplate %s, load templates with --templates", ann.Content)
+			}
+			return nil, fmt.Errorf("unknown @gotemplate %s", ann.Content)
+		}
+		for _, line := range lines {
+			parsed := append(parseAnnotations(line, p.Prefix), p.parseCustomAnnotations(line)...)
+			if len(parsed) == 0 {
+				return nil, fmt.Errorf("template %s: no annotation in %q", ann.Content, line)
+			}
+			for _, inner := range parsed {
+				if inner.Type == "gotemplate" || inner.Type == "gotype" {
+					return nil, fmt.Errorf("template %s: @%s can't be used in templates", ann.Content, inner.Type)
+				}
+				if inner.Condition == "" {
+					inner.Condition = ann.Condition
+				}
+				expanded = append(expanded, inner)
+			}
+		}
+	}
+	return expanded, nil
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReadTemplates(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	p := &Processor{}
+	path := write("ok.txt", "# shared fields\nlogger:\n    @goimport: \"log/slog\"\n\n    @gofield: log *slog.Logger\naudit-log:\n\t@gofield: CreatedBy string\n")
+	if err := p.readTemplates(path); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string][]string{
+		"logger":    {`@goimport: "log/slog"`, "@gofield: log *slog.Logger"},
+		"audit-log": {"@gofield: CreatedBy string"},
+	}
+	if !reflect.DeepEqual(p.Templates, want) {
+		t.Errorf("got %q, want %q", p.Templates, want)
+	}
+
+	for _, tt := range []struct{ content, wantErr string }{
+		{"    @gofield: A int\n", "annotation outside of a template"},
+		{"logger\n    @gofield: A int\n", "expected a template name"},
+		{"a:\n    @gofield: A int\na:\n", "template a is defined twice"},
+	} {
+		err := (&Processor{}).readTemplates(write("bad.txt", tt.content))
+		if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("readTemplates(%q) = %v, want %q", tt.content, err, tt.wantErr)
+		}
+	}
+}
+
+func TestTemplates(t *testing.T) {
+	p := newTestProcessor()
+	p.Templates = map[string][]string{
+		"logger": {`@goimport: "log/slog"`, "@gofield: log *slog.Logger"},
+		"bad":    {"@gotemplate: logger"},
+	}
+	out := processSource(t, p, "test.pb.go", "package pb\n\ntype User struct {\n\t// @gotemplate: logger\n\tName string\n}\n")
+	if !strings.Contains(out, "\t\"log/slog\"\n") && !strings.Contains(out, "import \"log/slog\"\n") {
+		t.Errorf("template import not added:\n%s", out)
+	}
+	if got := structDecl(out, "User"); !strings.Contains(got, "\tlog  *slog.Logger\n") {
+		t.Errorf("template field not injected:\n%s", got)
+	}
+
+	for _, tt := range []struct{ src, wantErr string }{
+		{"// @gotemplate: missing", "unknown @gotemplate missing"},
+		{"// @gotemplate: bad", "template bad: @gotemplate can't be used in templates"},
+	} {
+		path := filepath.Join(t.TempDir(), "test.pb.go")
+		if err := os.WriteFile(path, []byte("package pb\n\ntype User struct {\n\t"+tt.src+"\n}\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		var err error
+		captureStdout(t, func() { _, err = p.processFile(path) })
+		if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("%s: got %v, want %q", tt.src, err, tt.wantErr)
+		}
+	}
+}
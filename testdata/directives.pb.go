@@ -0,0 +1,18 @@
+package pb
+
+// @gotype: Account
+// @gofield[after: id]: OwnerId string
+// @gofield[after: id]: TeamId string
+// @gofield[before: balance]: Currency string
+// @gofield[before: balance]: Region string
+// @gotags(id): gorm:"primaryKey"
+// @gotags(balance): gorm:"not null"
+// @gotags(note): json:"note"
+
+type Account struct {
+	Id int64 `protobuf:"varint,1,opt,name=id,proto3" json:"id,omitempty"` //nolint:lll // generated
+	//nolint:revive // proto name
+	Balance int64 `protobuf:"varint,2,opt,name=balance,proto3" json:"balance,omitempty"` //nolint:lll
+	//lint:ignore U1000 kept for wire compatibility
+	Note string `protobuf:"bytes,3,opt,name=note,proto3"` //nolint:lll,unused
+}
@@ -0,0 +1,13 @@
+package pb
+
+// User is a user.
+// @gotags: json:",omitempty" gorm:"not null"
+type User struct {
+	state protoimpl.MessageState
+
+	Id       int64  `protobuf:"varint,1,opt,name=id,proto3" json:"id,omitempty"`
+	UserName string `protobuf:"bytes,2,opt,name=user_name,json=userName,proto3" json:"user_name"`
+	Password string `protobuf:"bytes,3,opt,name=password,proto3" json:"-"`
+	Email    string `protobuf:"bytes,4,opt,name=email,proto3" json:"email" gorm:"column:mail;index"`
+	Bio      string `protobuf:"bytes,5,opt,name=bio,proto3" json:"bio"` // @gotags: json:"biography"
+}
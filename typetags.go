@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"go/ast"
+	"go/types"
+	"os"
+	"strings"
+)
+
+// typeTagFlag collects --type-tag Type=tags defaults
+type typeTagFlag struct {
+	p *Processor
+}
+
+func (f typeTagFlag) String() string {
+	return ""
+}
+
+func (f typeTagFlag) Set(value string) error {
+	return f.p.addTypeTags(value)
+}
+
+// addTypeTags records the default tags of a Go type from a Type=tags rule.
+// Pointers are matched by the type they point to, so a leading * is
+// dropped.
+func (p *Processor) addTypeTags(rule string) error {
+	typeName, tagStr, ok := strings.Cut(rule, "=")
+	typeName = strings.TrimPrefix(strings.TrimSpace(typeName), "*")
+	if !ok || typeName == "" || strings.TrimSpace(tagStr) == "" {
+		return fmt.Errorf("expected Type=tags, got %q", rule)
+	}
+	if p.TypeTags == nil {
+		p.TypeTags = make(map[string]string)
+	}
+	p.TypeTags[typeName] = strings.TrimSpace(tagStr)
+	return nil
+}
+
+// readTypeTags loads the Type=tags rules of a --type-tags file, one per
+// line. Blank lines and # comments are skipped.
+func (p *Processor) readTypeTags(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open type tags: %v", err)
+	}
+	defer file.Close()
+
+	lineNum := 0
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if err := p.addTypeTags(line); err != nil {
+			return fmt.Errorf("%s:%d: %v", path, lineNum, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read type tags: %v", err)
+	}
+	return nil
+}
+
+// typeTags returns the default tags configured for the type of a field, or
+// "". Pointers are matched by the type they point to, and qualified types
+// either as written (time.Time) or by their import path
+// (github.com/google/uuid.UUID), so a rule still matches when the file
+// imports the package under another name.
+func (p *Processor) typeTags(astFile *ast.File, field *ast.Field) string {
+	if p.TypeTags == nil {
+		return ""
+	}
+	typeName := strings.TrimPrefix(types.ExprString(field.Type), "*")
+	if tagStr, ok := p.TypeTags[typeName]; ok {
+		return tagStr
+	}
+	if pkgName, name, ok := strings.Cut(typeName, "."); ok {
+		if path := importedPath(astFile, pkgName); path != "" {
+			return p.TypeTags[path+"."+name]
+		}
+	}
+	return ""
+}
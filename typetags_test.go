@@ -0,0 +1,66 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestReadTypeTags(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "type-tags.conf")
+	conf := "# defaults\n\ntime.Time=gorm:\"type:timestamptz\"\n*github.com/google/uuid.UUID = gorm:\"type:uuid\"\n"
+	if err := os.WriteFile(path, []byte(conf), 0644); err != nil {
+		t.Fatal(err)
+	}
+	p := newTestProcessor()
+	if err := p.readTypeTags(path); err != nil {
+		t.Fatal(err)
+	}
+	if got := p.TypeTags["time.Time"]; got != `gorm:"type:timestamptz"` {
+		t.Errorf("time.Time = %q", got)
+	}
+	if got := p.TypeTags["github.com/google/uuid.UUID"]; got != `gorm:"type:uuid"` {
+		t.Errorf("uuid.UUID = %q", got)
+	}
+
+	if err := os.WriteFile(path, []byte("time.Time=gorm:\"x\"\nbroken\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.readTypeTags(path); err == nil || !strings.Contains(err.Error(), ":2: ") {
+		t.Errorf("err = %v, want one for line 2", err)
+	}
+}
+
+func TestTypeTags(t *testing.T) {
+	src := `package pb
+
+import (
+	"time"
+
+	guuid "github.com/google/uuid"
+)
+
+type User struct {
+	Id        guuid.UUID
+	CreatedAt *time.Time
+	UpdatedAt time.Time ` + "`gorm:\"autoUpdateTime\"`" + `
+	Name      string
+}
+`
+	p := newTestProcessor()
+	for _, rule := range []string{`time.Time=gorm:"type:timestamptz"`, `github.com/google/uuid.UUID=gorm:"type:uuid"`} {
+		if err := (typeTagFlag{p}).Set(rule); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := "type User struct {\n" +
+		"\tId        guuid.UUID `gorm:\"type:uuid\"`\n" +
+		"\tCreatedAt *time.Time `gorm:\"type:timestamptz\"`\n" +
+		"\tUpdatedAt time.Time  `gorm:\"autoUpdateTime\"`\n" +
+		"\tName      string\n" +
+		"}"
+	if got := structDecl(processSource(t, p, "test.pb.go", src), "User"); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
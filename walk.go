@@ -0,0 +1,336 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// defaultInclude selects the files processed when walking a directory
+// without --include: protobuf output
+const defaultInclude = "*.pb.go"
+
+// includeFlag collects the comma-separated file patterns given with
+// --include
+type includeFlag struct {
+	patterns *[]string
+}
+
+func (f includeFlag) String() string {
+	return ""
+}
+
+func (f includeFlag) Set(value string) error {
+	for _, pattern := range strings.Split(value, ",") {
+		if pattern = strings.TrimSpace(pattern); pattern != "" {
+			*f.patterns = append(*f.patterns, pattern)
+		}
+	}
+	return nil
+}
+
+// ignoreRule is a single pattern from a .gitignore file
+type ignoreRule struct {
+	Base     string // Directory of the .gitignore, relative to the walk root
+	Pattern  *regexp.Regexp
+	Negate   bool // ! pattern, re-including a path
+	DirOnly  bool // Trailing /, only matching directories
+	BaseName bool // No / in the pattern, matching the name at any depth
+}
+
+// matches reports whether the rule applies to a path relative to the walk
+// root, using / separators
+func (r ignoreRule) matches(rel string, isDir bool) bool {
+	if r.DirOnly && !isDir {
+		return false
+	}
+	if r.Base != "" {
+		if !strings.HasPrefix(rel, r.Base+"/") {
+			return false
+		}
+		rel = strings.TrimPrefix(rel, r.Base+"/")
+	}
+	if r.BaseName {
+		rel = path.Base(rel)
+	}
+	return r.Pattern.MatchString(rel)
+}
+
+// readGitignore parses the .gitignore in dir, if any. Blank lines and
+// comments are skipped; patterns follow git's rules for !, leading and
+// trailing slashes, and * ? [] and ** wildcards.
+func readGitignore(dir, base string) ([]ignoreRule, error) {
+	file, err := os.Open(filepath.Join(dir, ".gitignore"))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var rules []ignoreRule
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), " \t\r")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		rule := ignoreRule{Base: base}
+		if strings.HasPrefix(line, "!") {
+			rule.Negate = true
+			line = line[1:]
+		}
+		line = strings.TrimPrefix(line, `\`) // \# and \! escape a leading # or !
+		if strings.HasSuffix(line, "/") {
+			rule.DirOnly = true
+			line = strings.TrimSuffix(line, "/")
+		}
+		rule.BaseName = !strings.Contains(line, "/")
+		line = strings.TrimPrefix(line, "/")
+		pattern, err := regexp.Compile("^" + globToRegexp(line) + "$")
+		if err != nil {
+			continue
+		}
+		rule.Pattern = pattern
+		rules = append(rules, rule)
+	}
+	return rules, scanner.Err()
+}
+
+// globToRegexp translates a gitignore glob into a regular expression
+func globToRegexp(glob string) string {
+	var sb strings.Builder
+	for i := 0; i < len(glob); i++ {
+		switch c := glob[i]; {
+		case strings.HasPrefix(glob[i:], "**/"):
+			sb.WriteString("(?:.*/)?")
+			i += 2
+		case strings.HasPrefix(glob[i:], "/**"):
+			sb.WriteString("(?:/.*)?")
+			i += 2
+		case strings.HasPrefix(glob[i:], "**"):
+			sb.WriteString(".*")
+			i++
+		case c == '*':
+			sb.WriteString("[^/]*")
+		case c == '?':
+			sb.WriteString("[^/]")
+		case c == '[':
+			end := strings.IndexByte(glob[i:], ']')
+			if end < 0 {
+				sb.WriteString(`\[`)
+				continue
+			}
+			class := glob[i+1 : i+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			sb.WriteString("[" + class + "]")
+			i += end
+		case c == '\\' && i+1 < len(glob):
+			i++
+			sb.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+		default:
+			sb.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	return sb.String()
+}
+
+// ignored reports whether the last rule matching a path ignores it
+func ignored(rules []ignoreRule, rel string, isDir bool) bool {
+	result := false
+	for _, rule := range rules {
+		if rule.matches(rel, isDir) {
+			result = !rule.Negate
+		}
+	}
+	return result
+}
+
+// includeRule is a file pattern from --include. Like a gitignore pattern, it
+// matches the file name unless it has a /, in which case it matches the path
+// relative to the walk root.
+type includeRule struct {
+	Pattern  *regexp.Regexp
+	BaseName bool
+}
+
+// compileIncludes compiles --include patterns, defaulting to *.pb.go
+func compileIncludes(patterns []string) ([]includeRule, error) {
+	if len(patterns) == 0 {
+		patterns = []string{defaultInclude}
+	}
+	var rules []includeRule
+	for _, pattern := range patterns {
+		re, err := regexp.Compile("^" + globToRegexp(strings.TrimPrefix(pattern, "/")) + "$")
+		if err != nil {
+			return nil, fmt.Errorf("invalid --include pattern %q: %v", pattern, err)
+		}
+		rules = append(rules, includeRule{Pattern: re, BaseName: !strings.Contains(pattern, "/")})
+	}
+	return rules, nil
+}
+
+// included reports whether any include rule matches a path relative to the
+// walk root
+func included(rules []includeRule, rel string) bool {
+	for _, rule := range rules {
+		name := rel
+		if rule.BaseName {
+			name = path.Base(rel)
+		}
+		if rule.Pattern.MatchString(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// repoRoot returns the closest directory at or above dir that contains
+// .git, or "" outside of a repository
+func repoRoot(dir string) string {
+	for {
+		if _, err := os.Stat(filepath.Join(dir, ".git")); err == nil {
+			return dir
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+		dir = parent
+	}
+}
+
+// parentGitignores reads the .gitignore files of the directories from the
+// repository root down to root's parent, which apply to root too. It returns
+// them with root's path relative to the repository root, which the walk
+// prefixes its paths with so the rules' bases line up.
+func parentGitignores(root string) ([]ignoreRule, string, error) {
+	abs, err := filepath.Abs(root)
+	if err != nil {
+		return nil, "", err
+	}
+	repo := repoRoot(abs)
+	if repo == "" || repo == abs {
+		return nil, "", nil
+	}
+	prefix, err := filepath.Rel(repo, abs)
+	if err != nil {
+		return nil, "", err
+	}
+	prefix = filepath.ToSlash(prefix)
+
+	var rules []ignoreRule
+	base := ""
+	for _, elem := range append([]string{""}, strings.Split(prefix, "/")...) {
+		base = path.Join(base, elem)
+		if base == prefix {
+			break
+		}
+		dirRules, err := readGitignore(filepath.Join(repo, filepath.FromSlash(base)), base)
+		if err != nil {
+			return nil, "", err
+		}
+		rules = append(rules, dirRules...)
+	}
+	return rules, prefix, nil
+}
+
+// walkDir returns the files under root matching the include patterns
+// (*.pb.go by default), skipping the .git directory. With gitignore, paths
+// ignored by .gitignore files are skipped too: those found along the way
+// and those of the parent directories up to the repository root.
+func walkDir(root string, include []string, gitignore bool) ([]string, error) {
+	includes, err := compileIncludes(include)
+	if err != nil {
+		return nil, err
+	}
+	return walkFiles(root, gitignore, func(rel string) bool {
+		return included(includes, rel)
+	})
+}
+
+// globDir returns the files under root whose path relative to it matches
+// any of the patterns, which use / separators and the wildcards of
+// .gitignore patterns: * and ? within a path segment, [] classes, and **
+// across directories (**/*.pb.go, api/**/v1/*.go). The .git directory and,
+// with gitignore, the paths .gitignore files ignore are skipped as with
+// walkDir.
+func globDir(root string, patterns []string, gitignore bool) ([]string, error) {
+	var res []*regexp.Regexp
+	for _, pattern := range patterns {
+		pattern = strings.TrimPrefix(filepath.ToSlash(pattern), "./")
+		re, err := regexp.Compile("^" + globToRegexp(pattern) + "$")
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %v", pattern, err)
+		}
+		res = append(res, re)
+	}
+	return walkFiles(root, gitignore, func(rel string) bool {
+		for _, re := range res {
+			if re.MatchString(rel) {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// walkFiles returns the files under root for which match, given their path
+// relative to root with / separators, is true, skipping the .git directory
+// and with gitignore the paths ignored by .gitignore files
+func walkFiles(root string, gitignore bool, match func(rel string) bool) ([]string, error) {
+	var rules []ignoreRule
+	var err error
+	prefix := ""
+	if gitignore {
+		if rules, prefix, err = parentGitignores(root); err != nil {
+			return nil, err
+		}
+	}
+
+	var files []string
+	err = filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if rel == "." {
+			rel = ""
+		}
+		// Gitignore rules see the path from the repository root
+		repoRel := path.Join(prefix, rel)
+
+		if d.IsDir() {
+			if rel != "" && (d.Name() == ".git" || (gitignore && ignored(rules, repoRel, true))) {
+				return filepath.SkipDir
+			}
+			if !gitignore {
+				return nil
+			}
+			dirRules, err := readGitignore(p, repoRel)
+			if err != nil {
+				return err
+			}
+			rules = append(rules, dirRules...)
+			return nil
+		}
+
+		if !match(rel) || (gitignore && ignored(rules, repoRel, false)) {
+			return nil
+		}
+		files = append(files, p)
+		return nil
+	})
+	return files, err
+}
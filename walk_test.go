@@ -0,0 +1,199 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"testing"
+)
+
+func TestGlobToRegexp(t *testing.T) {
+	tests := []struct {
+		glob    string
+		match   []string
+		noMatch []string
+	}{
+		{"*.pb.go", []string{"a.pb.go", ".pb.go"}, []string{"a.go", "x/a.pb.go"}},
+		{"a?c", []string{"abc"}, []string{"ac", "a/c"}},
+		{"[ab].go", []string{"a.go", "b.go"}, []string{"c.go"}},
+		{"[!ab].go", []string{"c.go"}, []string{"a.go"}},
+		{"**/gen", []string{"gen", "a/gen", "a/b/gen"}, []string{"agen"}},
+		{"gen/**", []string{"gen", "gen/a", "gen/a/b.go"}, []string{"generated"}},
+		{"a/**/b", []string{"a/b", "a/x/b", "a/x/y/b"}, []string{"a/xb"}},
+		{`\*.go`, []string{"*.go"}, []string{"a.go"}},
+		{"a.b+c", []string{"a.b+c"}, []string{"axb+c", "a.bbc"}},
+		{"[abc", []string{"[abc"}, []string{"a"}},
+	}
+	for _, tt := range tests {
+		re := regexp.MustCompile("^" + globToRegexp(tt.glob) + "$")
+		for _, name := range tt.match {
+			if !re.MatchString(name) {
+				t.Errorf("%q does not match %q", tt.glob, name)
+			}
+		}
+		for _, name := range tt.noMatch {
+			if re.MatchString(name) {
+				t.Errorf("%q matches %q", tt.glob, name)
+			}
+		}
+	}
+}
+
+// writeTree creates files under dir, named by slash-separated paths
+func writeTree(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// walkRel walks root and returns the files found relative to it
+func walkRel(t *testing.T, root string, include []string, gitignore bool) []string {
+	t.Helper()
+	files, err := walkDir(root, include, gitignore)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rel []string
+	for _, file := range files {
+		r, err := filepath.Rel(root, file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rel = append(rel, filepath.ToSlash(r))
+	}
+	sort.Strings(rel)
+	return rel
+}
+
+func TestWalkDirGitignore(t *testing.T) {
+	root := t.TempDir()
+	writeTree(t, root, map[string]string{
+		".gitignore":          "# comment\nvendor/\n*_mock.pb.go\n/top.pb.go\n",
+		"a.pb.go":             "",
+		"a.go":                "",
+		"top.pb.go":           "",
+		"x_mock.pb.go":        "",
+		"vendor/v.pb.go":      "",
+		"sub/.gitignore":      "old/\n!keep_mock.pb.go\n",
+		"sub/top.pb.go":       "",
+		"sub/keep_mock.pb.go": "",
+		"sub/old/o.pb.go":     "",
+		"other/old/o.pb.go":   "",
+		".git/h.pb.go":        "",
+	})
+
+	got := walkRel(t, root, nil, true)
+	want := []string{"a.pb.go", "other/old/o.pb.go", "sub/keep_mock.pb.go", "sub/top.pb.go"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("with .gitignore got %v, want %v", got, want)
+	}
+
+	got = walkRel(t, root, nil, false)
+	want = []string{"a.pb.go", "other/old/o.pb.go", "sub/keep_mock.pb.go", "sub/old/o.pb.go", "sub/top.pb.go", "top.pb.go", "vendor/v.pb.go", "x_mock.pb.go"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("without .gitignore got %v, want %v", got, want)
+	}
+}
+
+func TestWalkDirParentGitignore(t *testing.T) {
+	repo := t.TempDir()
+	writeTree(t, repo, map[string]string{
+		".git/HEAD":                   "",
+		".gitignore":                  "*_mock.pb.go\n/api/gen/skip/\n",
+		"api/.gitignore":              "legacy.pb.go\n",
+		"api/gen/a.pb.go":             "",
+		"api/gen/a_mock.pb.go":        "",
+		"api/gen/legacy.pb.go":        "",
+		"api/gen/skip/s.pb.go":        "",
+		"api/gen/nested/n.pb.go":      "",
+		"api/gen/nested/.gitignore":   "!legacy.pb.go\n",
+		"api/gen/nested/legacy.pb.go": "",
+	})
+
+	got := walkRel(t, filepath.Join(repo, "api", "gen"), nil, true)
+	want := []string{"a.pb.go", "nested/legacy.pb.go", "nested/n.pb.go"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestWalkDirInclude(t *testing.T) {
+	root := t.TempDir()
+	writeTree(t, root, map[string]string{
+		"a.pb.go":            "",
+		"config.go":          "",
+		"README.md":          "",
+		"models/user.go":     "",
+		"models/sub/item.go": "",
+		"other/item.go":      "",
+	})
+
+	got := walkRel(t, root, []string{"*.go"}, true)
+	want := []string{"a.pb.go", "config.go", "models/sub/item.go", "models/user.go", "other/item.go"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("*.go got %v, want %v", got, want)
+	}
+
+	got = walkRel(t, root, []string{"models/**/*.go", "config.go"}, true)
+	want = []string{"config.go", "models/sub/item.go", "models/user.go"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("paths got %v, want %v", got, want)
+	}
+}
+
+func TestGlobDir(t *testing.T) {
+	root := t.TempDir()
+	writeTree(t, root, map[string]string{
+		".gitignore":                   "legacy/\n",
+		"top.pb.go":                    "",
+		"billing/v1/bill.pb.go":        "",
+		"billing/api/v1/invoice.pb.go": "",
+		"billing/api/v2/invoice.pb.go": "",
+		"billing/legacy/v1/old.pb.go":  "",
+		"users/user.pb.go":             "",
+		"users/v1/user.pb.go":          "",
+	})
+	glob := func(gitignore bool, patterns ...string) []string {
+		t.Helper()
+		files, err := globDir(root, patterns, gitignore)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var rel []string
+		for _, file := range files {
+			r, err := filepath.Rel(root, file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			rel = append(rel, filepath.ToSlash(r))
+		}
+		sort.Strings(rel)
+		return rel
+	}
+
+	tests := []struct {
+		patterns  []string
+		gitignore bool
+		want      []string
+	}{
+		{[]string{"**/*.pb.go"}, true, []string{"billing/api/v1/invoice.pb.go", "billing/api/v2/invoice.pb.go", "billing/v1/bill.pb.go", "top.pb.go", "users/user.pb.go", "users/v1/user.pb.go"}},
+		{[]string{"billing/**/v1/*.pb.go"}, true, []string{"billing/api/v1/invoice.pb.go", "billing/v1/bill.pb.go"}},
+		{[]string{"billing/**/v1/*.pb.go"}, false, []string{"billing/api/v1/invoice.pb.go", "billing/legacy/v1/old.pb.go", "billing/v1/bill.pb.go"}},
+		// A file matching several patterns is listed once
+		{[]string{"users/*.pb.go", "./users/user.pb.go", "*.pb.go"}, true, []string{"top.pb.go", "users/user.pb.go"}},
+	}
+	for _, tt := range tests {
+		if got := glob(tt.gitignore, tt.patterns...); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("globDir(%q, gitignore %v) = %v, want %v", tt.patterns, tt.gitignore, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/types"
+	"strings"
+)
+
+// defaultWKTTags maps the Go types generated for protobuf well-known types
+// to the tags injected for them with --wkt-tags. They can't be stored by
+// gorm directly, so the default asks it to serialize them as JSON.
+var defaultWKTTags = map[string]string{
+	"timestamppb.Timestamp":  `gorm:"serializer:json"`,
+	"durationpb.Duration":    `gorm:"serializer:json"`,
+	"structpb.Struct":        `gorm:"serializer:json"`,
+	"structpb.Value":         `gorm:"serializer:json"`,
+	"structpb.ListValue":     `gorm:"serializer:json"`,
+	"anypb.Any":              `gorm:"serializer:json"`,
+	"fieldmaskpb.FieldMask":  `gorm:"serializer:json"`,
+	"wrapperspb.StringValue": `gorm:"serializer:json"`,
+	"wrapperspb.BoolValue":   `gorm:"serializer:json"`,
+	"wrapperspb.Int32Value":  `gorm:"serializer:json"`,
+	"wrapperspb.Int64Value":  `gorm:"serializer:json"`,
+	"wrapperspb.UInt32Value": `gorm:"serializer:json"`,
+	"wrapperspb.UInt64Value": `gorm:"serializer:json"`,
+	"wrapperspb.FloatValue":  `gorm:"serializer:json"`,
+	"wrapperspb.DoubleValue": `gorm:"serializer:json"`,
+	"wrapperspb.BytesValue":  `gorm:"serializer:json"`,
+	"emptypb.Empty":          `gorm:"serializer:json"`,
+}
+
+// wktTagFlag collects --wkt-tag Type=tags overrides
+type wktTagFlag struct {
+	p *Processor
+}
+
+func (f wktTagFlag) String() string {
+	return ""
+}
+
+func (f wktTagFlag) Set(value string) error {
+	typeName, tagStr, ok := strings.Cut(value, "=")
+	if !ok || strings.TrimSpace(typeName) == "" {
+		return fmt.Errorf("expected Type=tags, got %q", value)
+	}
+	f.p.enableWKTTags()
+	f.p.WKTTags[strings.TrimPrefix(strings.TrimSpace(typeName), "*")] = strings.TrimSpace(tagStr)
+	return nil
+}
+
+// enableWKTTags turns on well-known type tagging with the default mapping
+func (p *Processor) enableWKTTags() {
+	if p.WKTTags != nil {
+		return
+	}
+	p.WKTTags = make(map[string]string)
+	for typeName, tagStr := range defaultWKTTags {
+		p.WKTTags[typeName] = tagStr
+	}
+}
+
+// wktTags returns the tags configured for a field's well-known type, or ""
+// when the field isn't of such a type. Pointers are matched by the type
+// they point to.
+func (p *Processor) wktTags(field *ast.Field) string {
+	if p.WKTTags == nil {
+		return ""
+	}
+	return p.WKTTags[strings.TrimPrefix(types.ExprString(field.Type), "*")]
+}
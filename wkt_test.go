@@ -0,0 +1,56 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestWKTTagFlag(t *testing.T) {
+	p := newTestProcessor()
+	f := wktTagFlag{p}
+	if err := f.Set(`*timestamppb.Timestamp = gorm:"type:timestamptz"`); err != nil {
+		t.Fatal(err)
+	}
+	if got := p.WKTTags["timestamppb.Timestamp"]; got != `gorm:"type:timestamptz"` {
+		t.Errorf("override = %q", got)
+	}
+	// The other defaults stay in place
+	if got := p.WKTTags["durationpb.Duration"]; got != defaultWKTTags["durationpb.Duration"] {
+		t.Errorf("default for durationpb.Duration = %q", got)
+	}
+	for _, value := range []string{"timestamppb.Timestamp", `=gorm:"x"`} {
+		if err := f.Set(value); err == nil {
+			t.Errorf("Set(%q) gave no error", value)
+		}
+	}
+}
+
+func TestWKTTags(t *testing.T) {
+	src := "package pb\n\ntype User struct {\n" +
+		"\tCreated *timestamppb.Timestamp `protobuf:\"bytes,1,opt,name=created,proto3\"`\n" +
+		"\tTtl *durationpb.Duration `protobuf:\"bytes,2,opt,name=ttl,proto3\" gorm:\"type:bigint\"`\n" +
+		"\tUpdated *timestamppb.Timestamp `protobuf:\"bytes,3,opt,name=updated,proto3\"` // @gotags: gorm:\"autoUpdateTime\"\n" +
+		"\tName string `protobuf:\"bytes,4,opt,name=name,proto3\"`\n" +
+		"}\n"
+	p := newTestProcessor()
+	p.enableWKTTags()
+	p.WKTTags["durationpb.Duration"] = `gorm:"serializer:json" json:"ttl_ms"`
+	out := structDecl(processSource(t, p, "test.pb.go", src), "User")
+	for _, want := range []string{
+		"`protobuf:\"bytes,1,opt,name=created,proto3\" gorm:\"serializer:json\"`",
+		// Keys the field has are kept, new ones added
+		"`protobuf:\"bytes,2,opt,name=ttl,proto3\" gorm:\"type:bigint\" json:\"ttl_ms\"`",
+		// @gotags wins over the well-known type's tags
+		"`protobuf:\"bytes,3,opt,name=updated,proto3\" gorm:\"autoUpdateTime\"`",
+		"`protobuf:\"bytes,4,opt,name=name,proto3\"`\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("%s not found in:\n%s", want, out)
+		}
+	}
+
+	// Nothing is tagged unless enabled
+	if out := process(t, src); strings.Contains(out, "serializer") {
+		t.Errorf("tagged without --wkt-tags:\n%s", out)
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"strings"
+	"unicode"
+)
+
+// yamlCasings convert the name of a field's json tag to the name of the yaml
+// tag mirrored from it with --yaml-tags, selected with --yaml-case
+var yamlCasings = map[string]func(name string) string{
+	"json":  func(name string) string { return name },
+	"snake": toSnakeCase,
+	"camel": toLowerCamelCase,
+	"lower": strings.ToLower,
+}
+
+// yamlCaseFlag sets the casing of mirrored yaml tags with --yaml-case
+type yamlCaseFlag struct {
+	p *Processor
+}
+
+func (f yamlCaseFlag) String() string {
+	return ""
+}
+
+func (f yamlCaseFlag) Set(value string) error {
+	if _, ok := yamlCasings[value]; !ok {
+		return fmt.Errorf("unknown yaml case %q", value)
+	}
+	f.p.YAMLCase = value
+	return nil
+}
+
+// yamlTag returns a yaml tag mirroring the name in the field's json tag, or
+// "" when yaml tags are off or the field has no json name. Fields left out
+// of JSON (json:"-") are left out of YAML too.
+func (p *Processor) yamlTag(field *ast.Field) string {
+	if p.YAMLCase == "" || field.Tag == nil {
+		return ""
+	}
+	tags, _ := fieldTags(field)
+	for _, tag := range tags {
+		if tag.Key != "json" {
+			continue
+		}
+		name, _, _ := strings.Cut(tag.Value, ",")
+		switch name {
+		case "":
+			return ""
+		case "-":
+			return `yaml:"-"`
+		}
+		return fmt.Sprintf(`yaml:"%s"`, yamlCasings[p.YAMLCase](name))
+	}
+	return ""
+}
+
+// toLowerCamelCase converts a snake_case or Go name to lowerCamelCase, the
+// way protoc-gen-go names JSON fields (user_id -> userId)
+func toLowerCamelCase(name string) string {
+	var sb strings.Builder
+	upper := false
+	for i, r := range toSnakeCase(name) {
+		switch {
+		case r == '_':
+			upper = i > 0
+		case upper:
+			sb.WriteRune(unicode.ToUpper(r))
+			upper = false
+		default:
+			sb.WriteRune(r)
+		}
+	}
+	return sb.String()
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestToLowerCamelCase(t *testing.T) {
+	tests := map[string]string{
+		"user_id":   "userId",
+		"UserID":    "userId",
+		"name":      "name",
+		"_private":  "private",
+		"HTTPProxy": "httpProxy",
+	}
+	for name, want := range tests {
+		if got := toLowerCamelCase(name); got != want {
+			t.Errorf("toLowerCamelCase(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestYAMLTags(t *testing.T) {
+	src := "package pb\n\ntype User struct {\n" +
+		"\tUserId string `json:\"user_id,omitempty\"`\n" +
+		"\tSecret string `json:\"-\"`\n" +
+		"\tNickName string `json:\",omitempty\"`\n" +
+		"\tEmail string `json:\"email\" yaml:\"mail\"`\n" +
+		"\tLastName string `json:\"last_name\"` // @gotags: json:\"surname\"\n" +
+		"\tPlain string\n" +
+		"}\n"
+	tests := []struct {
+		yamlCase string
+		want     []string
+	}{
+		{"json", []string{`json:"user_id,omitempty" yaml:"user_id"`, `json:"-" yaml:"-"`, "`json:\",omitempty\"`", `yaml:"mail"`, `json:"surname" yaml:"surname"`, "\tPlain    string\n"}},
+		{"camel", []string{`json:"user_id,omitempty" yaml:"userId"`, `json:"-" yaml:"-"`, `yaml:"mail"`}},
+		{"snake", []string{`yaml:"user_id"`}},
+		{"lower", []string{`yaml:"user_id"`}},
+	}
+	for _, tt := range tests {
+		p := newTestProcessor()
+		if err := (yamlCaseFlag{p}).Set(tt.yamlCase); err != nil {
+			t.Fatal(err)
+		}
+		out := processSource(t, p, "test.pb.go", src)
+		for _, want := range tt.want {
+			if !strings.Contains(out, want) {
+				t.Errorf("%s: %s not found in:\n%s", tt.yamlCase, want, out)
+			}
+		}
+	}
+
+	if err := (yamlCaseFlag{newTestProcessor()}).Set("kebab"); err == nil {
+		t.Error("unknown yaml case accepted")
+	}
+	// Without the flag no yaml tags are added
+	if out := process(t, src); strings.Contains(out, `yaml:"user_id"`) {
+		t.Errorf("yaml tags added by default:\n%s", out)
+	}
+}